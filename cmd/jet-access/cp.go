@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	ssh "github.com/Stone-IT-Cloud/jet-access/pkg/sshclient"
+)
+
+// remotePath is a "[user@]host:path" copy argument.
+type remotePath struct {
+	target string // [user@]host
+	path   string
+}
+
+// parseRemotePath reports whether arg names a remote path. Like scp, a colon before
+// the first slash marks a remote path, so local paths containing colons can be given
+// as ./name. IPv6 hosts are written in brackets, e.g. [::1]:file.
+func parseRemotePath(arg string) (remotePath, bool) {
+	at := strings.LastIndex(arg, "@[")
+	if at >= 0 || strings.HasPrefix(arg, "[") {
+		end := strings.Index(arg, "]:")
+		if end < 0 {
+			return remotePath{}, false
+		}
+		user := ""
+		if at >= 0 {
+			user = arg[:at+1]
+		}
+		return remotePath{target: user + arg[at+2:end], path: arg[end+2:]}, true
+	}
+	colon := strings.Index(arg, ":")
+	if colon <= 0 || strings.Contains(arg[:colon], "/") {
+		return remotePath{}, false
+	}
+	return remotePath{target: arg[:colon], path: arg[colon+1:]}, true
+}
+
+// runCopy implements "jet-access cp [-r] [-q] src dst", where exactly one of src and
+// dst is a remote "[user@]host:path". An empty remote path is the remote home directory.
+func runCopy(args []string) error {
+	fs := flag.NewFlagSet("cp", flag.ExitOnError)
+	recursive := fs.Bool("r", false, "copy directories recursively")
+	quiet := fs.Bool("q", false, "do not show progress")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: jet-access cp [-r] [-q] [user@]host:path local_path")
+		fmt.Fprintln(fs.Output(), "       jet-access cp [-r] [-q] local_path [user@]host:path")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("expected a source and a destination")
+	}
+	src, dst := fs.Arg(0), fs.Arg(1)
+	remoteSrc, download := parseRemotePath(src)
+	remoteDst, upload := parseRemotePath(dst)
+	if download == upload {
+		return errors.New("exactly one of the source and destination must be a remote [user@]host:path")
+	}
+	remote := remoteSrc
+	if upload {
+		remote = remoteDst
+	}
+	if remote.path == "" {
+		remote.path = "."
+	}
+
+	sshConfig, err := hostSSHConfig(remote.target)
+	if err != nil {
+		return err
+	}
+	client, err := ssh.ConnectFileTransfer(sshConfig)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer client.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	opts := ssh.TransferOptions{Recursive: *recursive}
+	if !*quiet {
+		opts.Progress = printProgress
+	}
+	if upload {
+		err = client.Upload(ctx, src, remote.path, opts)
+	} else {
+		err = client.Download(ctx, remote.path, dst, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("copy failed: %w", err)
+	}
+	return nil
+}
+
+// printProgress shows a progress line per file on stderr, overwritten as it advances.
+func printProgress(path string, transferred, total int64) {
+	percent := int64(100)
+	if total > 0 {
+		percent = transferred * 100 / total
+	}
+	fmt.Fprintf(os.Stderr, "\r%s  %d/%d bytes (%d%%)", path, transferred, total, percent)
+	if transferred == total {
+		fmt.Fprintln(os.Stderr)
+	}
+}
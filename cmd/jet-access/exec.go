@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	ssh "github.com/Stone-IT-Cloud/jet-access/pkg/sshclient"
+	"golang.org/x/term"
+)
+
+// remoteFailureStatus is the exit status when the command cannot be run, reports no status
+// or is killed by a signal, matching ssh, so scripts can tell it from the command's own.
+const remoteFailureStatus = 255
+
+// execFlags are the options of "jet-access exec".
+type execFlags struct {
+	parallel   int
+	timeout    time.Duration
+	json       bool
+	reconnect  bool
+	repeatable bool
+}
+
+// execReconnectAttempts bounds how often exec -reconnect tries to connect in a row.
+const execReconnectAttempts = 5
+
+// runExec implements "jet-access exec [flags] target -- command...". For a single
+// [user@]host it streams the output and exits with the remote exit status; standard input
+// is forwarded when it is not a terminal, so data can be piped to the command. For a Vault
+// host pattern such as 'dev/*' it runs the command on every matching host in parallel.
+// With -reconnect, connecting to a single host is retried; with -repeatable as well, the
+// command is run again if the connection is lost while it runs.
+func runExec(args []string) error {
+	var opts execFlags
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	fs.IntVar(&opts.parallel, "parallel", 10, "with a host pattern, run on at most `n` hosts at once")
+	fs.DurationVar(&opts.timeout, "timeout", 0, "with a host pattern, give up on a host after `duration` (0 for no limit)")
+	fs.BoolVar(&opts.json, "json", false, "with a host pattern, print the results as JSON instead of streaming output")
+	fs.BoolVar(&opts.reconnect, "reconnect", false, "with a single host, retry connecting")
+	fs.BoolVar(&opts.repeatable, "repeatable", false,
+		"with -reconnect, run the command again if the connection is lost while it runs; it must be safe to repeat (input is not forwarded)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: jet-access exec [-reconnect [-repeatable]] [user@]host -- command [args...]")
+		fmt.Fprintln(fs.Output(), "       jet-access exec [-parallel n] [-timeout d] [-json] 'env/host-pattern' -- command [args...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	rest := fs.Args()
+	if len(rest) > 1 && rest[1] == "--" {
+		rest = append(rest[:1:1], rest[2:]...)
+	}
+	if len(rest) < 2 {
+		fs.Usage()
+		return errors.New("expected a host and a command")
+	}
+	target, command := rest[0], strings.Join(rest[1:], " ")
+	if opts.repeatable && !opts.reconnect {
+		return errors.New("-repeatable only works with -reconnect")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if isHostPattern(target) {
+		if opts.reconnect {
+			return errors.New("-reconnect only works with a single host")
+		}
+		return runFanOut(ctx, target, command, opts)
+	}
+
+	config := func(context.Context) (ssh.SSHConfig, error) { return hostSSHConfig(target) }
+	session := &ssh.Session{Stdout: os.Stdout, Stderr: os.Stderr}
+	// Input cannot be replayed when the command is run again
+	if !opts.repeatable && !term.IsTerminal(int(os.Stdin.Fd())) {
+		session.Stdin = os.Stdin
+	}
+
+	var result *ssh.RunResult
+	var err error
+	if opts.reconnect {
+		policy := ssh.ReconnectPolicy{MaxAttempts: execReconnectAttempts, RepeatCommand: opts.repeatable}
+		result, err = session.RunWithReconnect(ctx, config, command, policy)
+	} else {
+		var sshConfig ssh.SSHConfig
+		sshConfig, err = config(ctx)
+		if err != nil {
+			return err
+		}
+		result, err = session.Run(ctx, sshConfig, command)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitStatusError(remoteFailureStatus)
+	}
+	if result.Signal != "" {
+		fmt.Fprintf(os.Stderr, "Remote command killed by signal %s\n", result.Signal)
+		return exitStatusError(remoteFailureStatus)
+	}
+	if result.ExitCode != 0 {
+		return exitStatusError(result.ExitCode)
+	}
+	return nil
+}
+
+// runFanOut runs command on the Vault hosts matching pattern, then prints a summary to
+// stderr and, with -json, the results to stdout. It exits with status 1 unless the
+// command succeeded everywhere.
+func runFanOut(ctx context.Context, pattern, command string, opts execFlags) error {
+	client, stopRenewal, err := vaultClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer stopRenewal()
+	targets, err := vaultTargets(ctx, client, pattern)
+	if err != nil {
+		return err
+	}
+
+	fanOut := ssh.FanOutOptions{Concurrency: opts.parallel, Timeout: opts.timeout}
+	if !opts.json {
+		fanOut.Stdout = os.Stdout
+		fanOut.Stderr = os.Stderr
+	}
+	results := ssh.RunAll(ctx, targets, command, fanOut)
+
+	if opts.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return fmt.Errorf("failed to write results: %w", err)
+		}
+	}
+	if !printSummary(results) {
+		return exitStatusError(1)
+	}
+	return nil
+}
+
+// printSummary prints one line per host, grouped by status, and reports whether every
+// host succeeded.
+func printSummary(results []ssh.HostResult) bool {
+	counts := map[ssh.HostStatus]int{}
+	for _, r := range results {
+		counts[r.Status]++
+	}
+	fmt.Fprintf(os.Stderr, "\n%d hosts: %d succeeded, %d failed, %d unreachable\n", len(results),
+		counts[ssh.HostSucceeded], counts[ssh.HostFailed], counts[ssh.HostUnreachable])
+
+	for _, status := range []ssh.HostStatus{ssh.HostSucceeded, ssh.HostFailed, ssh.HostUnreachable} {
+		for _, r := range results {
+			if r.Status != status {
+				continue
+			}
+			detail := ""
+			switch {
+			case r.Error != "":
+				detail = ": " + r.Error
+			case r.Signal != "":
+				detail = ": killed by signal " + r.Signal
+			case r.ExitCode != 0:
+				detail = fmt.Sprintf(": exit status %d", r.ExitCode)
+			}
+			fmt.Fprintf(os.Stderr, "  %-12s %s%s\n", r.Status, r.Host, detail)
+		}
+	}
+	return counts[ssh.HostSucceeded] == len(results)
+}
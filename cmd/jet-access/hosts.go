@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/Stone-IT-Cloud/jet-access/internal/vault"
+	ssh "github.com/Stone-IT-Cloud/jet-access/pkg/sshclient"
+)
+
+// vaultClient returns a Vault client configured from the environment. Without
+// VAULT_TOKEN it logs in with AppRole if role credentials are configured, and keeps the
+// token renewed until stop is called.
+func vaultClient(ctx context.Context) (client *vault.Client, stop func(), err error) {
+	client, err = vault.NewClient(vault.ConfigFromEnv())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Vault client: %w", err)
+	}
+	if client.Token() != "" {
+		return client, func() {}, nil
+	}
+	auth := vault.AppRoleAuthFromEnv()
+	if auth.RoleID == "" && auth.RoleIDFile == "" {
+		return nil, nil, fmt.Errorf("no Vault credentials: set $%s or AppRole credentials", vault.EnvToken)
+	}
+	renewer, err := client.LoginAppRoleWithRenewal(ctx, auth)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to log in to Vault: %w", err)
+	}
+	return client, renewer.Stop, nil
+}
+
+// isHostPattern reports whether target names Vault hosts ("env/host") rather than a
+// single [user@]host.
+func isHostPattern(target string) bool {
+	return strings.Contains(target, "/")
+}
+
+// vaultTargets resolves an "env/host" pattern in shell glob syntax, such as "dev/*" or
+// "*/web-?", to the matching Vault hosts, sorted by environment and name.
+func vaultTargets(ctx context.Context, client *vault.Client, pattern string) ([]ssh.Target, error) {
+	envPattern, hostPattern, ok := strings.Cut(pattern, "/")
+	if !ok || envPattern == "" || hostPattern == "" || strings.Contains(hostPattern, "/") {
+		return nil, fmt.Errorf("invalid host pattern %q: expected env/host", pattern)
+	}
+	for _, p := range []string{envPattern, hostPattern} {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid host pattern %q: %w", pattern, err)
+		}
+	}
+
+	envs := []string{envPattern}
+	if hasGlob(envPattern) {
+		all, err := client.ListEnvironments(ctx)
+		if err != nil {
+			return nil, err
+		}
+		envs = matching(all, envPattern)
+	}
+
+	var targets []ssh.Target
+	for _, env := range envs {
+		hosts, err := client.ListHosts(ctx, env)
+		if errors.Is(err, vault.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range matching(hosts, hostPattern) {
+			targets = append(targets, ssh.Target{
+				Name: env + "/" + host,
+				Config: func(ctx context.Context) (ssh.SSHConfig, error) {
+					return client.HostSSHConfig(ctx, env, host)
+				},
+			})
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no hosts in Vault match %q", pattern)
+	}
+	return targets, nil
+}
+
+// hasGlob reports whether pattern contains glob metacharacters.
+func hasGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+// matching returns the names that match pattern (which has already been validated).
+func matching(names []string, pattern string) []string {
+	var out []string
+	for _, name := range names {
+		if ok, _ := path.Match(pattern, name); ok {
+			out = append(out, name)
+		}
+	}
+	return out
+}
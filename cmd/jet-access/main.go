@@ -1,34 +1,161 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
 	ssh "github.com/Stone-IT-Cloud/jet-access/pkg/sshclient"
+	"golang.org/x/term"
 )
 
+// commands are the subcommands; without one, jet-access opens an interactive shell.
+var commands = map[string]func(args []string) error{
+	"tunnel": runTunnel,
+	"cp":     runCopy,
+	"exec":   runExec,
+	"replay": runReplay,
+	"master": runMaster,
+}
+
+// envAlgorithms selects the algorithm policy: default, modern, compatible or fips.
+const envAlgorithms = "JET_ACCESS_ALGORITHMS"
+
+// exitStatusError makes jet-access exit with the given status without printing an error.
+type exitStatusError int
+
+func (e exitStatusError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
 func main() {
-	fmt.Println("Hello, World!")
+	run, args := runShell, os.Args[1:]
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			run, args = command, os.Args[2:]
+		}
+	}
+	if err := run(args); err != nil {
+		var status exitStatusError
+		if errors.As(err, &status) {
+			os.Exit(int(status))
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runShell implements the default command: an interactive shell on a host.
+func runShell(args []string) error {
+	fs := flag.NewFlagSet("jet-access", flag.ExitOnError)
+	record := fs.String("record", "", "record the session to `file` in asciicast v2 format (play it back with jet-access replay)")
+	recordInput := fs.Bool("record-input", false, "with -record, also record keystrokes, including any passwords typed")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: jet-access [-record file] [-record-input] [user@]host")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	sshConfig, err := hostSSHConfig(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *record != "" {
+		// Recordings may contain secrets shown in the session, so keep them private
+		f, err := os.OpenFile(*record, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to create recording: %w", err)
+		}
+		defer f.Close()
+		sshConfig.Recording = &ssh.RecordingOptions{Writer: f, RecordInput: *recordInput}
+	}
+	if err := ssh.ConnectAndShell(sshConfig); err != nil {
+		return fmt.Errorf("failed to connect to SSH: %w", err)
+	}
+	return nil
+}
+
+// keyCache keeps the keys decrypted after asking for their passphrase, so that it is asked
+// for once per run even with jump hosts, reconnects or many hosts.
+var keyCache = &ssh.KeyCache{}
+
+// defaultIdentities are the keys in ~/.ssh tried, like OpenSSH, when ~/.ssh/config names none.
+var defaultIdentities = []string{"id_rsa", "id_ecdsa", "id_ecdsa_sk", "id_ed25519", "id_ed25519_sk"}
+
+// defaultSSHConfig returns the connection settings shared by all commands, before those
+// ~/.ssh/config has for the host are applied.
+func defaultSSHConfig() (ssh.SSHConfig, error) {
 	sshConfig := ssh.SSHConfig{
-		Address: "45.55.41.188:22",
-		User:    "root",
+		User: "root",
+		// Ask before trusting a host that is not yet in ~/.ssh/known_hosts
+		HostKeyPolicy: ssh.HostKeyAsk,
+		// Notice connections that died silently (e.g. after a network change) within a minute
+		KeepaliveInterval: 15 * time.Second,
+		// Like OpenSSH, also offer the keys of a running ssh-agent
+		UseAgent: os.Getenv("SSH_AUTH_SOCK") != "",
+		KeyCache: keyCache,
+		// Questions are asked on the terminal, whatever the command does with the streams
+		HostKeyPrompt:    ssh.TerminalHostKeyPrompt,
+		PassphrasePrompt: ssh.TerminalPassphrasePrompt,
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		// Answer keyboard-interactive questions, such as one-time codes, on the terminal
+		sshConfig.ChallengeResponder = ssh.TerminalResponder()
+	}
+	// Compliance requirements or old appliances may call for a different algorithm set
+	policy, err := ssh.ParseAlgorithmPolicy(os.Getenv(envAlgorithms))
+	if err != nil {
+		return ssh.SSHConfig{}, fmt.Errorf("invalid $%s: %w", envAlgorithms, err)
 	}
+	sshConfig.AlgorithmPolicy = policy
+
 	// Get home directory
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		fmt.Printf("Error getting home directory: %v\n", err)
-		return
+		return ssh.SSHConfig{}, fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	// Read the first default SSH key file; IdentityFile in ~/.ssh/config takes precedence
+	for _, name := range defaultIdentities {
+		sshKey, err := os.ReadFile(filepath.Join(homeDir, ".ssh", name))
+		if err == nil {
+			sshConfig.Key = sshKey
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return ssh.SSHConfig{}, fmt.Errorf("failed to read SSH key file: %w", err)
+		}
 	}
+	return sshConfig, nil
+}
 
-	// Read the SSH key file
-	sshKey, err := os.ReadFile(homeDir + "/.ssh/id_rsa")
+// hostSSHConfig returns defaultSSHConfig pointed at target, "[user@]host", where host may
+// be a Host alias from ~/.ssh/config, with the settings the file has for it.
+func hostSSHConfig(target string) (ssh.SSHConfig, error) {
+	sshConfig, err := defaultSSHConfig()
 	if err != nil {
-		fmt.Printf("Error reading SSH key file: %v\n", err)
-		return
+		return ssh.SSHConfig{}, err
 	}
-	sshConfig.Key = sshKey
-	err = ssh.ConnectAndShell(sshConfig)
+	config, err := ssh.LoadConfigFile(ssh.DefaultConfigFile())
 	if err != nil {
-		fmt.Printf("Error connecting to SSH: %v\n", err)
+		return ssh.SSHConfig{}, err
+	}
+	sshConfig, err = config.Resolve(target, sshConfig)
+	if err != nil {
+		return ssh.SSHConfig{}, err
+	}
+	// Without a key or an agent, only a password or keyboard-interactive prompt can work
+	if sshConfig.Key == nil && !sshConfig.UseAgent && !term.IsTerminal(int(os.Stdin.Fd())) {
+		return ssh.SSHConfig{}, errors.New("no SSH key found: set IdentityFile in ~/.ssh/config, create ~/.ssh/id_ed25519 or start ssh-agent")
 	}
+	// Reuse the connection of a running "jet-access master", if any
+	sshConfig.ControlPath = ssh.DefaultControlPath(sshConfig.User, sshConfig.Address)
+	return sshConfig, nil
 }
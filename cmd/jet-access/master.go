@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	ssh "github.com/Stone-IT-Cloud/jet-access/pkg/sshclient"
+)
+
+// runMaster implements "jet-access master [-persist d] [user@]host": it connects once and
+// shares the connection with the other jet-access commands for the same user and host,
+// which then skip connecting and authenticating, until interrupted.
+func runMaster(args []string) error {
+	fs := flag.NewFlagSet("master", flag.ExitOnError)
+	persist := fs.Duration("persist", 0, "stop once no command has used the connection for `duration` (0 to keep it until interrupted)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: jet-access master [-persist d] [user@]host")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a host")
+	}
+
+	sshConfig, err := hostSSHConfig(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	master, err := ssh.StartControlMaster(ctx, sshConfig, ssh.ControlOptions{IdleTimeout: *persist})
+	if err != nil {
+		return fmt.Errorf("failed to start control master: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Sharing the connection to %s@%s on %s. Press Ctrl+C to stop.\n",
+		sshConfig.User, sshConfig.Address, master.Path())
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- master.Wait() }()
+	select {
+	case <-ctx.Done():
+		fmt.Fprintln(os.Stderr, "Stopping control master...")
+		return master.Close()
+	case err := <-stopped:
+		if err != nil {
+			return fmt.Errorf("SSH connection lost: %w", err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	ssh "github.com/Stone-IT-Cloud/jet-access/pkg/sshclient"
+)
+
+// runReplay implements "jet-access replay [-speed x] [-idle d] file", which plays back a
+// session recorded with -record in the terminal.
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	speed := fs.Float64("speed", 1, "play back `factor` times faster")
+	idle := fs.Duration("idle", 0, "shorten pauses to at most `duration` (0 for the recording's own limit)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: jet-access replay [-speed x] [-idle d] file")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a recording file")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer f.Close()
+	rec, err := ssh.ReadRecording(f)
+	if err != nil {
+		return err
+	}
+	if m := rec.Metadata; m != nil {
+		fmt.Fprintf(os.Stderr, "Session of %s@%s", m.User, m.Host)
+		if m.VaultIdentity != "" {
+			fmt.Fprintf(os.Stderr, " (Vault identity %s)", m.VaultIdentity)
+		}
+		fmt.Fprintf(os.Stderr, " started %s\n", m.StartTime.Local().Format(time.DateTime))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = rec.Play(ctx, os.Stdout, ssh.PlayOptions{Speed: *speed, MaxIdle: *idle})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	ssh "github.com/Stone-IT-Cloud/jet-access/pkg/sshclient"
+)
+
+// tunnelShutdownTimeout is how long open connections may take to finish on exit.
+const tunnelShutdownTimeout = 5 * time.Second
+
+// envSOCKSPassword holds the SOCKS proxy password, so it does not show up in the process list.
+const envSOCKSPassword = "JET_ACCESS_SOCKS_PASSWORD"
+
+// forwardFlags collects repeated -L, -R or -D flags into forwards, using parse.
+type forwardFlags struct {
+	forwards *[]ssh.Forward
+	parse    func(spec string) (ssh.Forward, error)
+}
+
+func (f forwardFlags) String() string {
+	if f.forwards == nil {
+		return ""
+	}
+	specs := make([]string, 0, len(*f.forwards))
+	for _, fw := range *f.forwards {
+		specs = append(specs, fw.String())
+	}
+	return strings.Join(specs, ", ")
+}
+
+func (f forwardFlags) Set(spec string) error {
+	fw, err := f.parse(spec)
+	if err != nil {
+		return err
+	}
+	*f.forwards = append(*f.forwards, fw)
+	return nil
+}
+
+// runTunnel implements "jet-access tunnel [-L spec] [-R spec] [-D spec] ... [user@]host": it forwards
+// local ports through the SSH server (-L), ports on the SSH server back to local services
+// (-R) and runs SOCKS5 proxies through the SSH server (-D) until interrupted or disconnected.
+// With -reconnect, a lost connection is re-established instead of ending the tunnels.
+func runTunnel(args []string) error {
+	var forwards []ssh.Forward
+	var socks ssh.SOCKSOptions
+	var reconnect bool
+	fs := flag.NewFlagSet("tunnel", flag.ExitOnError)
+	fs.Var(forwardFlags{forwards: &forwards, parse: ssh.ParseForward}, "L",
+		"forward local `[bind_address:]port:host:hostport` through the SSH server (repeatable)")
+	fs.Var(forwardFlags{forwards: &forwards, parse: ssh.ParseRemoteForward}, "R",
+		"forward `[bind_address:]port:host:hostport` on the SSH server to a local service (repeatable)")
+	fs.Var(forwardFlags{forwards: &forwards, parse: ssh.ParseDynamicForward}, "D",
+		"run a SOCKS5 proxy on `[bind_address:]port` that connects through the SSH server (repeatable)")
+	fs.StringVar(&socks.Username, "socks-user", "",
+		"require this SOCKS5 `username`; the password is read from $"+envSOCKSPassword)
+	fs.Func("socks-allow", "only let SOCKS5 clients connect to these comma-separated `CIDRs, IPs or host patterns` (e.g. *.internal)", func(v string) error {
+		for _, entry := range strings.Split(v, ",") {
+			entry = strings.TrimSpace(entry)
+			ip := net.ParseIP(entry)
+			switch {
+			case entry == "":
+			case strings.Contains(entry, "/"):
+				socks.AllowedNetworks = append(socks.AllowedNetworks, entry)
+			case ip != nil && ip.To4() != nil:
+				socks.AllowedNetworks = append(socks.AllowedNetworks, entry+"/32")
+			case ip != nil:
+				socks.AllowedNetworks = append(socks.AllowedNetworks, entry+"/128")
+			default:
+				socks.AllowedHosts = append(socks.AllowedHosts, entry)
+			}
+		}
+		return nil
+	})
+	fs.BoolVar(&reconnect, "reconnect", false, "reconnect with backoff when the SSH connection is lost, keeping local ports open")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: jet-access tunnel [-reconnect] [-L spec ...] [-R spec ...] [-D spec ...] [user@]host")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(forwards) == 0 {
+		fs.Usage()
+		return errors.New("at least one -L, -R or -D forward is required")
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a host")
+	}
+	target := fs.Arg(0)
+	if socks.Username != "" {
+		socks.Password = os.Getenv(envSOCKSPassword)
+		if socks.Password == "" {
+			return fmt.Errorf("-socks-user requires the password in $%s", envSOCKSPassword)
+		}
+	}
+
+	var forwarder *ssh.PortForwarder
+	var err error
+	if reconnect {
+		// The configuration is loaded again for every attempt, so changed credentials are picked up
+		config := func(context.Context) (ssh.SSHConfig, error) { return hostSSHConfig(target) }
+		forwarder, err = ssh.ConnectForwarderWithReconnect(context.Background(), config, ssh.ReconnectPolicy{})
+	} else {
+		var sshConfig ssh.SSHConfig
+		sshConfig, err = hostSSHConfig(target)
+		if err != nil {
+			return err
+		}
+		forwarder, err = ssh.ConnectForwarder(sshConfig)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+
+	for _, fw := range forwards {
+		var err error
+		switch {
+		case fw.Dynamic:
+			_, err = forwarder.ForwardDynamic(fw.LocalAddr, socks)
+		case fw.Reverse:
+			_, err = forwarder.ForwardRemote(fw)
+		default:
+			_, err = forwarder.ForwardLocal(fw)
+		}
+		if err != nil {
+			forwarder.Close()
+			return err
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Tunnels are up. Press Ctrl+C to stop.")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	disconnected := make(chan error, 1)
+	go func() { disconnected <- forwarder.Wait() }()
+
+	var runErr error
+	select {
+	case <-signals:
+		fmt.Fprintln(os.Stderr, "Shutting down tunnels...")
+	case err := <-disconnected:
+		if err != nil {
+			runErr = fmt.Errorf("SSH connection lost: %w", err)
+		} else {
+			fmt.Fprintln(os.Stderr, "SSH connection closed, shutting down tunnels...")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tunnelShutdownTimeout)
+	defer cancel()
+	if err := forwarder.Shutdown(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: closed tunnels with open connections: %v\n", err)
+	}
+
+	for _, t := range forwarder.Tunnels() {
+		stats := t.Stats()
+		fmt.Fprintf(os.Stderr, "%s: %d connections, %d bytes sent, %d bytes received\n",
+			t.Forward, stats.Connections, stats.BytesSent, stats.BytesReceived)
+	}
+	return runErr
+}
@@ -4,11 +4,13 @@ go 1.24.1
 
 require (
 	github.com/gliderlabs/ssh v0.3.8
+	github.com/pkg/sftp v1.13.9
 	golang.org/x/crypto v0.37.0
 	golang.org/x/term v0.31.0
 )
 
 require (
 	github.com/anmitsu/go-shlex v0.0.0-20200514113438-38f4b401e2be // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	golang.org/x/sys v0.32.0 // indirect
 )
@@ -19,6 +19,15 @@ variable "host1_secrets" {
     password       = string
     key            = string
     key_passphrase = string
+    # Optional host key pinning: public key(s), one per line, and/or SHA256 fingerprints
+    host_key              = optional(string, "")
+    host_key_fingerprints = optional(string, "")
+    # Optional credential mode: "static" (default), "cert" or "otp", plus the SSH secrets engine role
+    credential_mode = optional(string, "static")
+    ssh_mount       = optional(string, "ssh")
+    ssh_role        = optional(string, "")
+    # Optional bastion to connect through: another host secret, "<host>" or "<env>/<host>"
+    jump_host = optional(string, "")
   })
   sensitive = true
 }
@@ -33,6 +42,15 @@ variable "host2_secrets" {
     password       = string
     key            = string
     key_passphrase = string
+    # Optional host key pinning: public key(s), one per line, and/or SHA256 fingerprints
+    host_key              = optional(string, "")
+    host_key_fingerprints = optional(string, "")
+    # Optional credential mode: "static" (default), "cert" or "otp", plus the SSH secrets engine role
+    credential_mode = optional(string, "static")
+    ssh_mount       = optional(string, "ssh")
+    ssh_role        = optional(string, "")
+    # Optional bastion to connect through: another host secret, "<host>" or "<env>/<host>"
+    jump_host = optional(string, "")
   })
   sensitive = true
 }
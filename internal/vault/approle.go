@@ -0,0 +1,332 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAppRoleMount is the auth mount used when AppRoleAuth.MountPath is empty.
+	DefaultAppRoleMount = "approle"
+
+	// Environment variables read by AppRoleAuthFromEnv.
+	EnvAppRolePath  = "VAULT_APPROLE_PATH"
+	EnvRoleID       = "VAULT_ROLE_ID"
+	EnvRoleIDFile   = "VAULT_ROLE_ID_FILE"
+	EnvSecretID     = "VAULT_SECRET_ID"
+	EnvSecretIDFile = "VAULT_SECRET_ID_FILE"
+
+	// minRetryInterval bounds how often a failed login is retried by the renewer.
+	minRetryInterval = time.Second
+)
+
+// AppRoleAuth holds AppRole credentials. Inline values take precedence over files;
+// files are re-read on every login so rotated secret IDs are picked up.
+type AppRoleAuth struct {
+	MountPath    string // Auth mount path, e.g. "approle/limited-dev" (default: DefaultAppRoleMount)
+	RoleID       string // Optional: role ID (alternative to RoleIDFile)
+	RoleIDFile   string // Optional: file containing the role ID
+	SecretID     string // Optional: secret ID (alternative to SecretIDFile)
+	SecretIDFile string // Optional: file containing the secret ID
+}
+
+// AppRoleAuthFromEnv builds an AppRoleAuth from the VAULT_APPROLE_PATH, VAULT_ROLE_ID(_FILE)
+// and VAULT_SECRET_ID(_FILE) environment variables.
+func AppRoleAuthFromEnv() AppRoleAuth {
+	return AppRoleAuth{
+		MountPath:    os.Getenv(EnvAppRolePath),
+		RoleID:       os.Getenv(EnvRoleID),
+		RoleIDFile:   os.Getenv(EnvRoleIDFile),
+		SecretID:     os.Getenv(EnvSecretID),
+		SecretIDFile: os.Getenv(EnvSecretIDFile),
+	}
+}
+
+// credentials resolves the role ID and secret ID, reading files when needed.
+func (a AppRoleAuth) credentials() (roleID, secretID string, err error) {
+	roleID, err = valueOrFile(a.RoleID, a.RoleIDFile)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read role ID: %w", err)
+	}
+	if roleID == "" {
+		return "", "", errors.New("no AppRole role ID configured")
+	}
+	// The secret ID may legitimately be empty for roles with bind_secret_id = false.
+	secretID, err = valueOrFile(a.SecretID, a.SecretIDFile)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read secret ID: %w", err)
+	}
+	return roleID, secretID, nil
+}
+
+// mountPath returns the auth mount path without surrounding slashes.
+func (a AppRoleAuth) mountPath() string {
+	if p := strings.Trim(a.MountPath, "/"); p != "" {
+		return p
+	}
+	return DefaultAppRoleMount
+}
+
+// valueOrFile returns value if set, otherwise the trimmed contents of file (if set).
+func valueOrFile(value, file string) (string, error) {
+	if value != "" || file == "" {
+		return value, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// TokenInfo describes a Vault token obtained by logging in or renewing.
+type TokenInfo struct {
+	ClientToken   string
+	Accessor      string
+	Policies      []string
+	Metadata      map[string]string
+	EntityID      string
+	LeaseDuration time.Duration
+	Renewable     bool
+}
+
+// authResponse is the "auth" block of Vault login and renew responses.
+type authResponse struct {
+	Auth *struct {
+		ClientToken   string            `json:"client_token"`
+		Accessor      string            `json:"accessor"`
+		Policies      []string          `json:"policies"`
+		Metadata      map[string]string `json:"metadata"`
+		EntityID      string            `json:"entity_id"`
+		LeaseDuration int               `json:"lease_duration"`
+		Renewable     bool              `json:"renewable"`
+	} `json:"auth"`
+}
+
+func (r authResponse) tokenInfo() (*TokenInfo, error) {
+	if r.Auth == nil || r.Auth.ClientToken == "" {
+		return nil, errors.New("vault response contained no auth data")
+	}
+	return &TokenInfo{
+		ClientToken:   r.Auth.ClientToken,
+		Accessor:      r.Auth.Accessor,
+		Policies:      r.Auth.Policies,
+		Metadata:      r.Auth.Metadata,
+		EntityID:      r.Auth.EntityID,
+		LeaseDuration: time.Duration(r.Auth.LeaseDuration) * time.Second,
+		Renewable:     r.Auth.Renewable,
+	}, nil
+}
+
+// LoginAppRole logs in with AppRole and, on success, uses the new token for subsequent requests.
+func (c *Client) LoginAppRole(ctx context.Context, auth AppRoleAuth) (*TokenInfo, error) {
+	roleID, secretID, err := auth.credentials()
+	if err != nil {
+		return nil, err
+	}
+	body := map[string]string{"role_id": roleID}
+	if secretID != "" {
+		body["secret_id"] = secretID
+	}
+
+	var resp authResponse
+	if err := c.doUnauthenticated(ctx, http.MethodPost, "auth/"+auth.mountPath()+"/login", body, &resp); err != nil {
+		return nil, fmt.Errorf("AppRole login at %s failed: %w", auth.mountPath(), err)
+	}
+	info, err := resp.tokenInfo()
+	if err != nil {
+		return nil, fmt.Errorf("AppRole login at %s failed: %w", auth.mountPath(), err)
+	}
+	c.SetToken(info.ClientToken)
+	return info, nil
+}
+
+// RenewSelf renews the client's current token by increment (0 lets Vault use the role TTL).
+// Near the max TTL Vault returns a shorter lease than requested.
+func (c *Client) RenewSelf(ctx context.Context, increment time.Duration) (*TokenInfo, error) {
+	body := map[string]any{}
+	if increment > 0 {
+		body["increment"] = int(increment / time.Second)
+	}
+	var resp authResponse
+	if err := c.do(ctx, http.MethodPost, "auth/token/renew-self", nil, body, &resp); err != nil {
+		return nil, fmt.Errorf("token renewal failed: %w", err)
+	}
+	return resp.tokenInfo()
+}
+
+// doUnauthenticated is like do but never sends the current token (used for logins).
+func (c *Client) doUnauthenticated(ctx context.Context, method, path string, body, out any) error {
+	anon := &Client{
+		address:    c.address,
+		namespace:  c.namespace,
+		httpClient: c.httpClient,
+	}
+	return anon.do(ctx, method, path, nil, body, out)
+}
+
+// Renewer keeps an AppRole token valid in the background. It renews the token
+// before its TTL runs out and logs in again once the max TTL is reached.
+type Renewer struct {
+	client *Client
+	auth   AppRoleAuth
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu   sync.Mutex
+	info *TokenInfo
+}
+
+// StartRenewer starts renewing the token described by info (as returned by LoginAppRole)
+// until ctx is cancelled or Stop is called.
+func (c *Client) StartRenewer(ctx context.Context, auth AppRoleAuth, info *TokenInfo) *Renewer {
+	ctx, cancel := context.WithCancel(ctx)
+	r := &Renewer{
+		client: c,
+		auth:   auth,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		info:   info,
+	}
+	go r.run(ctx)
+	return r
+}
+
+// LoginAppRoleWithRenewal logs in with AppRole and starts a Renewer for the resulting token.
+func (c *Client) LoginAppRoleWithRenewal(ctx context.Context, auth AppRoleAuth) (*Renewer, error) {
+	info, err := c.LoginAppRole(ctx, auth)
+	if err != nil {
+		return nil, err
+	}
+	return c.StartRenewer(ctx, auth, info), nil
+}
+
+// Stop stops the renewer and waits for it to exit. The current token is left valid.
+func (r *Renewer) Stop() {
+	r.cancel()
+	<-r.done
+}
+
+// Done is closed when the renewer has exited.
+func (r *Renewer) Done() <-chan struct{} {
+	return r.done
+}
+
+// TokenInfo returns the most recent token information.
+func (r *Renewer) TokenInfo() *TokenInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.info
+}
+
+func (r *Renewer) setInfo(info *TokenInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.info = info
+}
+
+// updateLease publishes a copy of info with the renewed lease duration. Copies are used
+// so TokenInfo callers never observe a value being modified.
+func (r *Renewer) updateLease(info *TokenInfo, lease time.Duration) *TokenInfo {
+	updated := *info
+	updated.LeaseDuration = lease
+	r.setInfo(&updated)
+	return &updated
+}
+
+// renewAfter returns how long to wait before acting on a token with the given TTL:
+// two thirds of the TTL, so there is time to retry before it expires.
+func renewAfter(ttl time.Duration) time.Duration {
+	return ttl * 2 / 3
+}
+
+func (r *Renewer) run(ctx context.Context) {
+	defer close(r.done)
+
+	info := r.TokenInfo()
+	// The TTL granted at login is what a renewal should give back; anything shorter
+	// means the token is capped by its max TTL and must be replaced by a fresh login.
+	roleTTL := info.LeaseDuration
+	relogin := !info.Renewable
+
+	for {
+		if info.LeaseDuration <= 0 {
+			// Non-expiring token: nothing to do.
+			<-ctx.Done()
+			return
+		}
+
+		timer := time.NewTimer(renewAfter(info.LeaseDuration))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !relogin {
+			renewed, err := r.client.RenewSelf(ctx, roleTTL)
+			switch {
+			case err != nil:
+				log.Printf("Warning: Vault token renewal failed, logging in again: %v", err)
+				relogin = true
+			case renewed.LeaseDuration < roleTTL:
+				// Max TTL reached: use what is left (if anything), then log in again.
+				relogin = true
+				if renewed.LeaseDuration > 0 {
+					info = r.updateLease(info, renewed.LeaseDuration)
+					continue
+				}
+			default:
+				info = r.updateLease(info, renewed.LeaseDuration)
+				continue
+			}
+		}
+
+		fresh, err := r.login(ctx, info.LeaseDuration)
+		if err != nil {
+			// Only fails when ctx is done.
+			return
+		}
+		info = fresh
+		roleTTL = info.LeaseDuration
+		relogin = !info.Renewable
+		r.setInfo(info)
+	}
+}
+
+// login logs in again, retrying with backoff until it succeeds or ctx is done.
+func (r *Renewer) login(ctx context.Context, lastTTL time.Duration) (*TokenInfo, error) {
+	backoff := minRetryInterval
+	maxBackoff := lastTTL / 3
+	if maxBackoff < minRetryInterval {
+		maxBackoff = minRetryInterval
+	}
+	for {
+		info, err := r.client.LoginAppRole(ctx, r.auth)
+		if err == nil {
+			log.Println("Vault AppRole login renewed.")
+			return info, nil
+		}
+		log.Printf("Warning: Vault AppRole login failed, retrying in %s: %v", backoff, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAppRole adds AppRole login and token renewal endpoints to a fakeVault.
+type fakeAppRole struct {
+	fv       *fakeVault
+	roleID   string
+	secretID string
+	ttl      int // Token TTL in seconds
+	maxTTL   int // Token max TTL in seconds
+
+	mu      sync.Mutex
+	logins  int
+	renews  int
+	created map[string]time.Time // Token -> creation time
+}
+
+func newFakeAppRole(fv *fakeVault, mount string, ttl, maxTTL int) *fakeAppRole {
+	fa := &fakeAppRole{
+		fv:       fv,
+		roleID:   "role-123",
+		secretID: "secret-456",
+		ttl:      ttl,
+		maxTTL:   maxTTL,
+		created:  map[string]time.Time{},
+	}
+	fv.handle("/v1/auth/"+mount+"/login", fa.login)
+	fv.handle("/v1/auth/token/renew-self", fa.renewSelf)
+	return fa
+}
+
+func (fa *fakeAppRole) counts() (logins, renews int) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	return fa.logins, fa.renews
+}
+
+func (fa *fakeAppRole) login(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RoleID   string `json:"role_id"`
+		SecretID string `json:"secret_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RoleID != fa.roleID || body.SecretID != fa.secretID {
+		writeVaultError(w, http.StatusBadRequest, "invalid role or secret ID")
+		return
+	}
+
+	fa.mu.Lock()
+	fa.logins++
+	token := fmt.Sprintf("approle-token-%d", fa.logins)
+	fa.created[token] = time.Now()
+	fa.mu.Unlock()
+
+	fa.fv.mu.Lock()
+	fa.fv.tokens[token] = true
+	fa.fv.mu.Unlock()
+
+	writeVaultJSON(w, map[string]any{"auth": map[string]any{
+		"client_token":   token,
+		"policies":       []string{"ssh-hosts-limited-dev-reader"},
+		"metadata":       map[string]string{"role_name": "ssh-access-role"},
+		"lease_duration": fa.ttl,
+		"renewable":      true,
+	}})
+}
+
+func (fa *fakeAppRole) renewSelf(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("X-Vault-Token")
+	fa.mu.Lock()
+	created, ok := fa.created[token]
+	if ok {
+		fa.renews++
+	}
+	fa.mu.Unlock()
+	if !ok {
+		writeVaultError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	// Like Vault, never extend past the max TTL.
+	remaining := fa.maxTTL - int(time.Since(created).Seconds())
+	lease := fa.ttl
+	if remaining < lease {
+		lease = remaining
+	}
+	if lease < 0 {
+		lease = 0
+	}
+	writeVaultJSON(w, map[string]any{"auth": map[string]any{
+		"client_token":   token,
+		"lease_duration": lease,
+		"renewable":      true,
+	}})
+}
+
+func TestAppRoleCredentials(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret-id")
+	if err := os.WriteFile(secretFile, []byte("secret-from-file\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write secret file: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		auth          AppRoleAuth
+		env           map[string]string
+		expectRoleID  string
+		expectSecret  string
+		expectError   bool
+		errorContains string
+	}{
+		{
+			name:         "Inline Values",
+			auth:         AppRoleAuth{RoleID: "r", SecretID: "s"},
+			expectRoleID: "r",
+			expectSecret: "s",
+		},
+		{
+			name:         "Secret ID From File",
+			auth:         AppRoleAuth{RoleID: "r", SecretIDFile: secretFile},
+			expectRoleID: "r",
+			expectSecret: "secret-from-file",
+		},
+		{
+			name:         "From Environment",
+			env:          map[string]string{EnvRoleID: "env-role", EnvSecretIDFile: secretFile},
+			expectRoleID: "env-role",
+			expectSecret: "secret-from-file",
+		},
+		{
+			name:          "Missing Role ID",
+			auth:          AppRoleAuth{SecretID: "s"},
+			expectError:   true,
+			errorContains: "no AppRole role ID",
+		},
+		{
+			name:          "Unreadable Secret File",
+			auth:          AppRoleAuth{RoleID: "r", SecretIDFile: filepath.Join(dir, "missing")},
+			expectError:   true,
+			errorContains: "failed to read secret ID",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := tt.auth
+			if tt.env != nil {
+				for k, v := range tt.env {
+					t.Setenv(k, v)
+				}
+				auth = AppRoleAuthFromEnv()
+			}
+			roleID, secretID, err := auth.credentials()
+			if tt.expectError {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if roleID != tt.expectRoleID || secretID != tt.expectSecret {
+				t.Errorf("Expected (%s, %s), got (%s, %s)", tt.expectRoleID, tt.expectSecret, roleID, secretID)
+			}
+		})
+	}
+}
+
+func TestLoginAppRole(t *testing.T) {
+	fv := newTestVaultWithHosts(t)
+	fa := newFakeAppRole(fv, "approle/limited-dev", 3600, 86400)
+	ctx := context.Background()
+
+	c, err := NewClient(Config{Address: fv.server.URL})
+	if err != nil {
+		t.Fatalf("NewClient() unexpected error: %v", err)
+	}
+
+	auth := AppRoleAuth{MountPath: "approle/limited-dev", RoleID: fa.roleID, SecretID: "wrong"}
+	if _, err := c.LoginAppRole(ctx, auth); err == nil {
+		t.Fatalf("Expected login with a wrong secret ID to fail")
+	}
+
+	auth.SecretID = fa.secretID
+	info, err := c.LoginAppRole(ctx, auth)
+	if err != nil {
+		t.Fatalf("LoginAppRole() unexpected error: %v", err)
+	}
+	if info.LeaseDuration != time.Hour || !info.Renewable {
+		t.Errorf("Expected a renewable 1h token, got %+v", info)
+	}
+	if c.Token() != info.ClientToken {
+		t.Errorf("Expected client to use the new token")
+	}
+	// The new token must grant access to host secrets.
+	if _, err := c.GetHost(ctx, "dev", "busybox-host-1"); err != nil {
+		t.Errorf("GetHost() after login unexpected error: %v", err)
+	}
+}
+
+// TestRenewer checks that the token is renewed while possible and replaced by a new
+// login once the max TTL is reached.
+func TestRenewer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping renewer timing test in short mode")
+	}
+
+	fv := newTestVaultWithHosts(t)
+	fa := newFakeAppRole(fv, "approle", 1, 2)
+
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	c, err := NewClient(Config{Address: fv.server.URL})
+	if err != nil {
+		t.Fatalf("NewClient() unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	renewer, err := c.LoginAppRoleWithRenewal(ctx, AppRoleAuth{RoleID: fa.roleID, SecretID: fa.secretID})
+	if err != nil {
+		t.Fatalf("LoginAppRoleWithRenewal() unexpected error: %v", err)
+	}
+	firstToken := c.Token()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logins, renews := fa.counts()
+		if logins >= 2 && renews >= 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a renewal and a re-login, got %d logins and %d renewals", logins, renews)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	renewer.Stop()
+	select {
+	case <-renewer.Done():
+	default:
+		t.Errorf("Expected Done to be closed after Stop")
+	}
+	if c.Token() == firstToken {
+		t.Errorf("Expected the client token to change after re-login")
+	}
+	if got := renewer.TokenInfo().ClientToken; got != c.Token() {
+		t.Errorf("Expected renewer token %s to match client token %s", got, c.Token())
+	}
+}
@@ -0,0 +1,147 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/Stone-IT-Cloud/jet-access/pkg/sshclient"
+)
+
+// defaultSSHPort is used when a host secret does not specify a port.
+const defaultSSHPort = "22"
+
+// CredentialMode selects how jet-access obtains credentials for a host.
+type CredentialMode string
+
+const (
+	// CredentialStatic uses the key and/or password stored in the host secret (default).
+	CredentialStatic CredentialMode = "static"
+	// CredentialCert signs an ephemeral key with the SSH secrets engine (ssh/sign/<role>).
+	CredentialCert CredentialMode = "cert"
+	// CredentialOTP requests a one-time password from the SSH secrets engine (ssh/creds/<role>).
+	CredentialOTP CredentialMode = "otp"
+)
+
+// HostSecret mirrors the data stored in a Vault KV host secret
+// (secret/data/ssh/hosts/<env>/<host>).
+type HostSecret struct {
+	Hostname      string `json:"hostname"`
+	IP            string `json:"ip"`
+	Port          string `json:"port"`
+	Username      string `json:"username"`
+	Password      string `json:"password,omitempty"`
+	Key           string `json:"key,omitempty"`
+	KeyPassphrase string `json:"key_passphrase,omitempty"`
+
+	// Optional host key pinning. HostKey holds one or more public keys (one per line) in
+	// authorized_keys or known_hosts format; HostKeyFingerprints holds "SHA256:..." or
+	// "MD5:..." fingerprints separated by commas or whitespace.
+	HostKey             string `json:"host_key,omitempty"`
+	HostKeyFingerprints string `json:"host_key_fingerprints,omitempty"`
+
+	// Optional credential mode ("static", "cert" or "otp") and, for cert and otp,
+	// the SSH secrets engine mount and role to use.
+	CredentialMode CredentialMode `json:"credential_mode,omitempty"`
+	SSHMount       string         `json:"ssh_mount,omitempty"`
+	SSHRole        string         `json:"ssh_role,omitempty"`
+
+	// Optional bastion to connect through: the name of another host secret, either
+	// "<host>" in the same environment or "<env>/<host>".
+	JumpHost string `json:"jump_host,omitempty"`
+}
+
+// DecodeHostSecret decodes the JSON data of a host secret.
+func DecodeHostSecret(data []byte) (*HostSecret, error) {
+	var h HostSecret
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, fmt.Errorf("failed to decode host secret: %w", err)
+	}
+	return &h, nil
+}
+
+// Mode returns the credential mode of the host, defaulting to CredentialStatic.
+func (h HostSecret) Mode() (CredentialMode, error) {
+	switch mode := CredentialMode(strings.ToLower(string(h.CredentialMode))); mode {
+	case "":
+		return CredentialStatic, nil
+	case CredentialStatic, CredentialCert, CredentialOTP:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown credential mode %q (expected static, cert or otp)", h.CredentialMode)
+	}
+}
+
+// JumpHostRef returns the environment and name of the host secret to connect through,
+// resolving a bare host name against env. ok is false if the host has no jump host.
+func (h HostSecret) JumpHostRef(env string) (jumpEnv, jumpHost string, ok bool) {
+	ref := strings.Trim(strings.TrimSpace(h.JumpHost), "/")
+	if ref == "" {
+		return "", "", false
+	}
+	if e, host, found := strings.Cut(ref, "/"); found {
+		return e, host, true
+	}
+	return env, ref, true
+}
+
+// Address returns the host:port to dial, preferring the IP over the hostname.
+func (h HostSecret) Address() string {
+	host := h.IP
+	if host == "" {
+		host = h.Hostname
+	}
+	port := h.Port
+	if port == "" {
+		port = defaultSSHPort
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// SSHConfig converts the host secret into an sshclient.SSHConfig. Host keys in the
+// secret are passed through as pins, so the connection fails closed on a mismatch.
+func (h HostSecret) SSHConfig() (sshclient.SSHConfig, error) {
+	if h.IP == "" && h.Hostname == "" {
+		return sshclient.SSHConfig{}, fmt.Errorf("host secret has neither ip nor hostname")
+	}
+	if h.Username == "" {
+		return sshclient.SSHConfig{}, fmt.Errorf("host secret for %s has no username", h.Address())
+	}
+
+	cfg := sshclient.SSHConfig{
+		Address:             h.Address(),
+		User:                h.Username,
+		Port:                h.Port,
+		Passphrase:          h.KeyPassphrase,
+		Password:            h.Password,
+		HostKeys:            splitLines(h.HostKey),
+		HostKeyFingerprints: splitList(h.HostKeyFingerprints),
+	}
+	if h.Key != "" {
+		cfg.Key = []byte(h.Key)
+	}
+	return cfg, nil
+}
+
+// splitLines splits s into its non-empty, trimmed lines.
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// splitList splits a list stored as a single string on commas and whitespace.
+func splitList(s string) []string {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
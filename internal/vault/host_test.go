@@ -0,0 +1,106 @@
+package vault
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHostSecretSSHConfig(t *testing.T) {
+	tests := []struct {
+		name               string
+		data               string
+		expectError        bool
+		errorContains      string
+		expectAddress      string
+		expectHostKeys     []string
+		expectFingerprints []string
+	}{
+		{
+			name:          "Basic Secret",
+			data:          `{"hostname":"busybox-host-1","ip":"10.0.0.5","port":"2222","username":"root","password":"pw"}`,
+			expectAddress: "10.0.0.5:2222",
+		},
+		{
+			name:          "Hostname And Default Port",
+			data:          `{"hostname":"busybox-host-1","username":"root"}`,
+			expectAddress: "busybox-host-1:22",
+		},
+		{
+			name:           "Pinned Host Keys",
+			data:           `{"ip":"10.0.0.5","username":"root","host_key":"ssh-ed25519 AAAA1 a\nssh-rsa AAAA2 b\n"}`,
+			expectAddress:  "10.0.0.5:22",
+			expectHostKeys: []string{"ssh-ed25519 AAAA1 a", "ssh-rsa AAAA2 b"},
+		},
+		{
+			name:               "Pinned Fingerprints",
+			data:               `{"ip":"10.0.0.5","username":"root","host_key_fingerprints":"SHA256:abc, SHA256:def"}`,
+			expectAddress:      "10.0.0.5:22",
+			expectFingerprints: []string{"SHA256:abc", "SHA256:def"},
+		},
+		{
+			name:          "Missing Address",
+			data:          `{"username":"root"}`,
+			expectError:   true,
+			errorContains: "neither ip nor hostname",
+		},
+		{
+			name:          "Missing Username",
+			data:          `{"ip":"10.0.0.5"}`,
+			expectError:   true,
+			errorContains: "no username",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, err := DecodeHostSecret([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("DecodeHostSecret() unexpected error: %v", err)
+			}
+			cfg, err := secret.SSHConfig()
+			if tt.expectError {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SSHConfig() unexpected error: %v", err)
+			}
+			if cfg.Address != tt.expectAddress {
+				t.Errorf("Expected address %s, got %s", tt.expectAddress, cfg.Address)
+			}
+			if !reflect.DeepEqual(cfg.HostKeys, tt.expectHostKeys) {
+				t.Errorf("Expected host keys %v, got %v", tt.expectHostKeys, cfg.HostKeys)
+			}
+			if !reflect.DeepEqual(cfg.HostKeyFingerprints, tt.expectFingerprints) {
+				t.Errorf("Expected fingerprints %v, got %v", tt.expectFingerprints, cfg.HostKeyFingerprints)
+			}
+		})
+	}
+}
+
+func TestHostSecretJumpHostRef(t *testing.T) {
+	tests := []struct {
+		name       string
+		jumpHost   string
+		expectEnv  string
+		expectHost string
+		expectOK   bool
+	}{
+		{name: "No Jump Host", jumpHost: ""},
+		{name: "Same Environment", jumpHost: "bastion", expectEnv: "dev", expectHost: "bastion", expectOK: true},
+		{name: "Other Environment", jumpHost: "shared/bastion", expectEnv: "shared", expectHost: "bastion", expectOK: true},
+		{name: "Surrounding Slashes", jumpHost: " /shared/bastion/ ", expectEnv: "shared", expectHost: "bastion", expectOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, host, ok := HostSecret{JumpHost: tt.jumpHost}.JumpHostRef("dev")
+			if env != tt.expectEnv || host != tt.expectHost || ok != tt.expectOK {
+				t.Errorf("Expected (%q, %q, %v), got (%q, %q, %v)", tt.expectEnv, tt.expectHost, tt.expectOK, env, host, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package vault
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/Stone-IT-Cloud/jet-access/pkg/sshclient"
+)
+
+const (
+	// DefaultSSHMount is the mount path of Vault's SSH secrets engine.
+	DefaultSSHMount = "ssh"
+	// DefaultCertTTL is the validity requested for signed certificates. It only needs to
+	// cover the handshake, so it is kept short.
+	DefaultCertTTL = 5 * time.Minute
+)
+
+// CertOptions configures signing of ephemeral keys by Vault's SSH secrets engine.
+type CertOptions struct {
+	Mount      string        // Optional: SSH secrets engine mount path (default: DefaultSSHMount)
+	Role       string        // Signing role, i.e. ssh/sign/<role>
+	TTL        time.Duration // Optional: certificate TTL (default: DefaultCertTTL)
+	Principals []string      // Optional: principals to request (default: the host's username)
+}
+
+func (o CertOptions) mount() string {
+	if m := strings.Trim(o.Mount, "/"); m != "" {
+		return m
+	}
+	return DefaultSSHMount
+}
+
+// SignedKey is a certificate issued by Vault's SSH secrets engine.
+type SignedKey struct {
+	Certificate  []byte // OpenSSH certificate in authorized_keys format
+	SerialNumber string
+}
+
+// SignSSHKey asks Vault to sign pub as a user certificate using opts.Role.
+func (c *Client) SignSSHKey(ctx context.Context, pub ssh.PublicKey, opts CertOptions) (*SignedKey, error) {
+	if err := validateSegment("SSH role", opts.Role); err != nil {
+		return nil, err
+	}
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = DefaultCertTTL
+	}
+
+	body := map[string]string{
+		"public_key": strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))),
+		"cert_type":  "user",
+		"ttl":        fmt.Sprintf("%ds", int(ttl/time.Second)),
+	}
+	if len(opts.Principals) > 0 {
+		body["valid_principals"] = strings.Join(opts.Principals, ",")
+	}
+
+	var resp struct {
+		Data struct {
+			SignedKey    string `json:"signed_key"`
+			SerialNumber string `json:"serial_number"`
+		} `json:"data"`
+	}
+	path := opts.mount() + "/sign/" + opts.Role
+	if err := c.do(ctx, http.MethodPost, path, nil, body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to sign SSH key with %s: %w", path, err)
+	}
+	if resp.Data.SignedKey == "" {
+		return nil, fmt.Errorf("failed to sign SSH key with %s: %w", path, errors.New("response contained no signed key"))
+	}
+	return &SignedKey{
+		Certificate:  []byte(resp.Data.SignedKey),
+		SerialNumber: resp.Data.SerialNumber,
+	}, nil
+}
+
+// SignEphemeralKey generates an ephemeral in-memory keypair, has Vault sign it for user and
+// returns a signer presenting the certificate. The private key never leaves memory.
+func (c *Client) SignEphemeralKey(ctx context.Context, user string, opts CertOptions) (ssh.Signer, []byte, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create signer for ephemeral key: %w", err)
+	}
+
+	if len(opts.Principals) == 0 {
+		opts.Principals = []string{user}
+	}
+	signed, err := c.SignSSHKey(ctx, signer.PublicKey(), opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return signer, signed.Certificate, nil
+}
+
+// HostSSHConfigWithCert reads the host secret env/host and returns an SSHConfig that
+// authenticates with a freshly signed ephemeral certificate instead of the stored
+// key or password. A jump host named by the secret is loaded with HostSSHConfig.
+func (c *Client) HostSSHConfigWithCert(ctx context.Context, env, host string, opts CertOptions) (sshclient.SSHConfig, error) {
+	secret, err := c.GetHost(ctx, env, host)
+	if err != nil {
+		return sshclient.SSHConfig{}, err
+	}
+	cfg, err := c.certSSHConfig(ctx, secret, opts)
+	if err != nil {
+		return sshclient.SSHConfig{}, err
+	}
+	return c.withJumpHost(ctx, env, host, secret, cfg, nil)
+}
+
+// certSSHConfig converts secret to an SSHConfig whose only credential is a fresh certificate.
+func (c *Client) certSSHConfig(ctx context.Context, secret *HostSecret, opts CertOptions) (sshclient.SSHConfig, error) {
+	cfg, err := secret.SSHConfig()
+	if err != nil {
+		return sshclient.SSHConfig{}, err
+	}
+	signer, cert, err := c.SignEphemeralKey(ctx, cfg.User, opts)
+	if err != nil {
+		return sshclient.SSHConfig{}, err
+	}
+
+	cfg.Key = nil
+	cfg.Passphrase = ""
+	cfg.Password = ""
+	cfg.Signer = signer
+	cfg.Certificate = cert
+	return cfg, nil
+}
@@ -0,0 +1,126 @@
+package vault
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// addFakeSSHSigner adds an ssh/sign/<role> endpoint to fv that signs with a fresh CA.
+// It returns the CA public key.
+func addFakeSSHSigner(t *testing.T, fv *fakeVault, role string) ssh.PublicKey {
+	t.Helper()
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatalf("Failed to create CA signer: %v", err)
+	}
+
+	fv.handle("/v1/ssh/sign/"+role, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != testToken {
+			writeVaultError(w, http.StatusForbidden, "permission denied")
+			return
+		}
+		var body struct {
+			PublicKey       string `json:"public_key"`
+			ValidPrincipals string `json:"valid_principals"`
+			TTL             string `json:"ttl"`
+			CertType        string `json:"cert_type"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.CertType != "user" {
+			writeVaultError(w, http.StatusBadRequest, "invalid request")
+			return
+		}
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(body.PublicKey))
+		if err != nil {
+			writeVaultError(w, http.StatusBadRequest, "invalid public key")
+			return
+		}
+		ttl, err := time.ParseDuration(body.TTL)
+		if err != nil {
+			writeVaultError(w, http.StatusBadRequest, "invalid ttl")
+			return
+		}
+		cert := &ssh.Certificate{
+			Key:             pub,
+			Serial:          42,
+			CertType:        ssh.UserCert,
+			ValidPrincipals: strings.Split(body.ValidPrincipals, ","),
+			ValidAfter:      uint64(time.Now().Add(-30 * time.Second).Unix()),
+			ValidBefore:     uint64(time.Now().Add(ttl).Unix()),
+		}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			writeVaultError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeVaultJSON(w, map[string]any{"data": map[string]any{
+			"signed_key":    string(ssh.MarshalAuthorizedKey(cert)),
+			"serial_number": "000000000000002a",
+		}})
+	})
+	return ca.PublicKey()
+}
+
+func TestHostSSHConfigWithCert(t *testing.T) {
+	fv := newTestVaultWithHosts(t)
+	caPub := addFakeSSHSigner(t, fv, "dev-role")
+	c := fv.client(t)
+
+	cfg, err := c.HostSSHConfigWithCert(context.Background(), "dev", "busybox-host-1",
+		CertOptions{Role: "dev-role", TTL: time.Minute})
+	if err != nil {
+		t.Fatalf("HostSSHConfigWithCert() unexpected error: %v", err)
+	}
+
+	if cfg.Key != nil || cfg.Password != "" {
+		t.Errorf("Expected static credentials to be cleared, got key=%v password=%q", cfg.Key != nil, cfg.Password)
+	}
+	if cfg.Signer == nil {
+		t.Fatalf("Expected an ephemeral signer")
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(cfg.Certificate)
+	if err != nil {
+		t.Fatalf("Failed to parse returned certificate: %v", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		t.Fatalf("Expected a certificate, got %T", pub)
+	}
+	if string(cert.Key.Marshal()) != string(cfg.Signer.PublicKey().Marshal()) {
+		t.Errorf("Certificate was not issued for the ephemeral key")
+	}
+	if string(cert.SignatureKey.Marshal()) != string(caPub.Marshal()) {
+		t.Errorf("Certificate was not signed by the Vault CA")
+	}
+	if len(cert.ValidPrincipals) != 1 || cert.ValidPrincipals[0] != "root" {
+		t.Errorf("Expected principal [root], got %v", cert.ValidPrincipals)
+	}
+	if _, err := ssh.NewCertSigner(cert, cfg.Signer); err != nil {
+		t.Errorf("Certificate and signer cannot be combined: %v", err)
+	}
+}
+
+func TestSignSSHKey_Errors(t *testing.T) {
+	fv := newTestVaultWithHosts(t)
+	addFakeSSHSigner(t, fv, "dev-role")
+	c := fv.client(t)
+	ctx := context.Background()
+
+	if _, _, err := c.SignEphemeralKey(ctx, "root", CertOptions{Role: "unknown-role"}); err == nil {
+		t.Errorf("Expected an error for an unknown role")
+	}
+	if _, _, err := c.SignEphemeralKey(ctx, "root", CertOptions{}); err == nil {
+		t.Errorf("Expected an error for a missing role")
+	}
+}
@@ -0,0 +1,109 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/Stone-IT-Cloud/jet-access/pkg/sshclient"
+)
+
+// OTPOptions configures one-time password requests to Vault's SSH secrets engine.
+type OTPOptions struct {
+	Mount string // Optional: SSH secrets engine mount path (default: DefaultSSHMount)
+	Role  string // OTP role, i.e. ssh/creds/<role>
+}
+
+func (o OTPOptions) mount() string {
+	if m := strings.Trim(o.Mount, "/"); m != "" {
+		return m
+	}
+	return DefaultSSHMount
+}
+
+// RequestOTP asks Vault for a one-time password for user on the host with the given IP.
+func (c *Client) RequestOTP(ctx context.Context, ip, user string, opts OTPOptions) (string, error) {
+	if err := validateSegment("SSH role", opts.Role); err != nil {
+		return "", err
+	}
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("invalid IP address %q for OTP request", ip)
+	}
+
+	body := map[string]string{"ip": ip}
+	if user != "" {
+		body["username"] = user
+	}
+	var resp struct {
+		Data struct {
+			Key     string `json:"key"`
+			KeyType string `json:"key_type"`
+		} `json:"data"`
+	}
+	path := opts.mount() + "/creds/" + opts.Role
+	if err := c.do(ctx, http.MethodPost, path, nil, body, &resp); err != nil {
+		return "", fmt.Errorf("failed to request OTP from %s: %w", path, err)
+	}
+	if resp.Data.KeyType != "" && resp.Data.KeyType != "otp" {
+		return "", fmt.Errorf("failed to request OTP from %s: role issues %q credentials, not otp", path, resp.Data.KeyType)
+	}
+	if resp.Data.Key == "" {
+		return "", fmt.Errorf("failed to request OTP from %s: %w", path, errors.New("response contained no key"))
+	}
+	return resp.Data.Key, nil
+}
+
+// HostSSHConfigWithOTP reads the host secret env/host and returns an SSHConfig that
+// authenticates with a Vault-issued one-time password instead of the stored credentials.
+// A jump host named by the secret is loaded with HostSSHConfig.
+func (c *Client) HostSSHConfigWithOTP(ctx context.Context, env, host string, opts OTPOptions) (sshclient.SSHConfig, error) {
+	secret, err := c.GetHost(ctx, env, host)
+	if err != nil {
+		return sshclient.SSHConfig{}, err
+	}
+	cfg, err := c.otpSSHConfig(ctx, secret, opts)
+	if err != nil {
+		return sshclient.SSHConfig{}, err
+	}
+	return c.withJumpHost(ctx, env, host, secret, cfg, nil)
+}
+
+// otpSSHConfig converts secret to an SSHConfig whose only credential is a fresh OTP.
+func (c *Client) otpSSHConfig(ctx context.Context, secret *HostSecret, opts OTPOptions) (sshclient.SSHConfig, error) {
+	cfg, err := secret.SSHConfig()
+	if err != nil {
+		return sshclient.SSHConfig{}, err
+	}
+	ip, err := secret.resolveIP(ctx)
+	if err != nil {
+		return sshclient.SSHConfig{}, err
+	}
+	otp, err := c.RequestOTP(ctx, ip, cfg.User, opts)
+	if err != nil {
+		return sshclient.SSHConfig{}, err
+	}
+
+	cfg.Key = nil
+	cfg.Passphrase = ""
+	cfg.Password = otp
+	return cfg, nil
+}
+
+// resolveIP returns the host's IP, resolving the hostname if the secret has no IP.
+// Vault OTP roles are bound to IPs (cidr_list), so a name is not enough.
+func (h HostSecret) resolveIP(ctx context.Context) (string, error) {
+	if h.IP != "" {
+		return h.IP, nil
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, h.Hostname)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s for OTP request: %w", h.Hostname, err)
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("failed to resolve %s for OTP request: no addresses", h.Hostname)
+	}
+	return addrs[0], nil
+}
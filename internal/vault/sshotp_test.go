@@ -0,0 +1,109 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// addFakeOTPRole adds an ssh/creds/<role> endpoint to fv that issues otp for any IP.
+func addFakeOTPRole(fv *fakeVault, role, otp string) {
+	fv.handle("/v1/ssh/creds/"+role, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != testToken {
+			writeVaultError(w, http.StatusForbidden, "permission denied")
+			return
+		}
+		var body struct {
+			IP       string `json:"ip"`
+			Username string `json:"username"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.IP == "" {
+			writeVaultError(w, http.StatusBadRequest, "missing ip")
+			return
+		}
+		writeVaultJSON(w, map[string]any{"data": map[string]any{
+			"key":      otp,
+			"key_type": "otp",
+			"ip":       body.IP,
+			"username": body.Username,
+			"port":     22,
+		}})
+	})
+}
+
+func TestHostSSHConfig_CredentialModes(t *testing.T) {
+	const otp = "7d7a1f6e-5c0b-4d5e-8f2a-1b2c3d4e5f60"
+	fv := newTestVaultWithHosts(t)
+	addFakeOTPRole(fv, "otp-role", otp)
+	addFakeSSHSigner(t, fv, "cert-role")
+
+	fv.putSecret("ssh/hosts/dev/legacy-host", map[string]any{
+		"hostname": "legacy-host", "ip": "10.0.0.9", "port": "22", "username": "ubuntu",
+		"password": "static-pw", "credential_mode": "otp", "ssh_role": "otp-role",
+	})
+	fv.putSecret("ssh/hosts/dev/cert-host", map[string]any{
+		"hostname": "cert-host", "ip": "10.0.0.10", "port": "22", "username": "ubuntu",
+		"credential_mode": "cert", "ssh_role": "cert-role",
+	})
+	fv.putSecret("ssh/hosts/dev/bad-mode-host", map[string]any{
+		"hostname": "bad-mode-host", "ip": "10.0.0.11", "username": "ubuntu", "credential_mode": "kerberos",
+	})
+	fv.putSecret("ssh/hosts/dev/otp-no-role", map[string]any{
+		"hostname": "otp-no-role", "ip": "10.0.0.12", "username": "ubuntu", "credential_mode": "otp",
+	})
+
+	c := fv.client(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string
+		host           string
+		expectError    bool
+		errorContains  string
+		expectPassword string
+		expectCert     bool
+	}{
+		{name: "Static (Default)", host: "busybox-host-1", expectPassword: "pw"},
+		{name: "OTP", host: "legacy-host", expectPassword: otp},
+		{name: "Certificate", host: "cert-host", expectCert: true},
+		{name: "Unknown Mode", host: "bad-mode-host", expectError: true, errorContains: "unknown credential mode"},
+		{name: "OTP Without Role", host: "otp-no-role", expectError: true, errorContains: "invalid SSH role"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := c.HostSSHConfig(ctx, "dev", tt.host)
+			if tt.expectError {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if cfg.Password != tt.expectPassword {
+				t.Errorf("Expected password %q, got %q", tt.expectPassword, cfg.Password)
+			}
+			if (cfg.Certificate != nil) != tt.expectCert || (cfg.Signer != nil) != tt.expectCert {
+				t.Errorf("Expected certificate auth=%v, got certificate=%v signer=%v",
+					tt.expectCert, cfg.Certificate != nil, cfg.Signer != nil)
+			}
+		})
+	}
+}
+
+func TestRequestOTP_Validation(t *testing.T) {
+	fv := newTestVaultWithHosts(t)
+	addFakeOTPRole(fv, "otp-role", "x")
+	c := fv.client(t)
+
+	if _, err := c.RequestOTP(context.Background(), "not-an-ip", "root", OTPOptions{Role: "otp-role"}); err == nil {
+		t.Errorf("Expected an error for an invalid IP")
+	}
+	if _, err := c.RequestOTP(context.Background(), "10.0.0.1", "root", OTPOptions{Role: "missing-role"}); err == nil {
+		t.Errorf("Expected an error for an unknown role")
+	}
+}
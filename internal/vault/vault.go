@@ -1,8 +1,336 @@
+// Package vault reads SSH host credentials from a HashiCorp Vault KV v2 secrets engine.
+//
+// Hosts are stored as secret/data/ssh/hosts/<env>/<host>, as created by the Terraform
+// in infrastructure/tf/vault.
 package vault
 
-import "fmt"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
-// Vault prints "Hello, World!" to the standard output.
-func Vault() {
-	fmt.Println("Hello, World!")
+	"github.com/Stone-IT-Cloud/jet-access/pkg/sshclient"
+)
+
+const (
+	// DefaultMount is the mount path of the KV v2 engine holding host secrets.
+	DefaultMount = "secret"
+	// DefaultHostsPath is the path under the mount where environments are stored.
+	DefaultHostsPath = "ssh/hosts"
+
+	// Environment variables read by ConfigFromEnv (same names as the Vault CLI).
+	EnvAddress   = "VAULT_ADDR"
+	EnvToken     = "VAULT_TOKEN"
+	EnvNamespace = "VAULT_NAMESPACE"
+
+	defaultTimeout = 30 * time.Second
+
+	// maxJumpHosts bounds how many jump_host references are followed for one host.
+	maxJumpHosts = 8
+)
+
+// ErrNotFound is returned when a secret or listing does not exist.
+var ErrNotFound = errors.New("vault: not found")
+
+// APIError is returned when Vault answers with an unexpected HTTP status.
+type APIError struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *APIError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vault: unexpected status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("vault: unexpected status %d: %s", e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+// Config holds the parameters needed to talk to Vault.
+type Config struct {
+	Address    string       // Vault server address (e.g., "https://vault.example.com:8200")
+	Token      string       // Optional: Vault token (can be set later via SetToken, e.g. after a login)
+	Namespace  string       // Optional: Vault Enterprise namespace
+	Mount      string       // Optional: KV v2 mount path (default: DefaultMount)
+	HostsPath  string       // Optional: path of the hosts tree inside the mount (default: DefaultHostsPath)
+	HTTPClient *http.Client // Optional: HTTP client to use (default: 30s timeout client)
+}
+
+// ConfigFromEnv returns a Config populated from VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE.
+func ConfigFromEnv() Config {
+	return Config{
+		Address:   os.Getenv(EnvAddress),
+		Token:     os.Getenv(EnvToken),
+		Namespace: os.Getenv(EnvNamespace),
+	}
+}
+
+// Client is a minimal Vault KV v2 client for reading host credentials.
+// It is safe for concurrent use.
+type Client struct {
+	address    *url.URL
+	namespace  string
+	mount      string
+	hostsPath  string
+	httpClient *http.Client
+
+	mu    sync.RWMutex
+	token string
+}
+
+// NewClient creates a Client from cfg.
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("vault address is required")
+	}
+	addr, err := url.Parse(strings.TrimRight(cfg.Address, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid vault address %q: %w", cfg.Address, err)
+	}
+	if addr.Scheme != "http" && addr.Scheme != "https" {
+		return nil, fmt.Errorf("invalid vault address %q: scheme must be http or https", cfg.Address)
+	}
+
+	c := &Client{
+		address:    addr,
+		namespace:  cfg.Namespace,
+		mount:      strings.Trim(cfg.Mount, "/"),
+		hostsPath:  strings.Trim(cfg.HostsPath, "/"),
+		httpClient: cfg.HTTPClient,
+		token:      cfg.Token,
+	}
+	if c.mount == "" {
+		c.mount = DefaultMount
+	}
+	if c.hostsPath == "" {
+		c.hostsPath = DefaultHostsPath
+	}
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+	return c, nil
+}
+
+// SetToken replaces the token used for subsequent requests.
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+// Token returns the token currently in use.
+func (c *Client) Token() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token
+}
+
+// ListEnvironments returns the environments (e.g. "dev", "prod") under the hosts path.
+func (c *Client) ListEnvironments(ctx context.Context) ([]string, error) {
+	keys, err := c.list(ctx, c.hostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list environments: %w", err)
+	}
+	return filterKeys(keys, true), nil
+}
+
+// ListHosts returns the host secret names in the given environment.
+func (c *Client) ListHosts(ctx context.Context, env string) ([]string, error) {
+	if err := validateSegment("environment", env); err != nil {
+		return nil, err
+	}
+	keys, err := c.list(ctx, c.hostsPath+"/"+env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list hosts in %s: %w", env, err)
+	}
+	return filterKeys(keys, false), nil
+}
+
+// GetHost reads the host secret env/host.
+func (c *Client) GetHost(ctx context.Context, env, host string) (*HostSecret, error) {
+	if err := validateSegment("environment", env); err != nil {
+		return nil, err
+	}
+	if err := validateSegment("host", host); err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Data struct {
+			Data json.RawMessage `json:"data"`
+		} `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, c.mount+"/data/"+c.hostsPath+"/"+env+"/"+host, nil, nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to read host secret %s/%s: %w", env, host, err)
+	}
+	// A deleted (but not destroyed) version has null data.
+	if len(resp.Data.Data) == 0 || string(resp.Data.Data) == "null" {
+		return nil, fmt.Errorf("failed to read host secret %s/%s: %w", env, host, ErrNotFound)
+	}
+	return DecodeHostSecret(resp.Data.Data)
+}
+
+// HostSSHConfig reads the host secret env/host and converts it to an sshclient.SSHConfig,
+// obtaining credentials according to the secret's credential mode (static, cert or otp).
+// If the secret names a jump host, that host's configuration is loaded the same way and
+// set as the config's jump host.
+func (c *Client) HostSSHConfig(ctx context.Context, env, host string) (sshclient.SSHConfig, error) {
+	return c.hostSSHConfig(ctx, env, host, nil)
+}
+
+// hostSSHConfig implements HostSSHConfig. chain lists the hosts ("env/host") whose
+// jump_host reference led to this one.
+func (c *Client) hostSSHConfig(ctx context.Context, env, host string, chain []string) (sshclient.SSHConfig, error) {
+	secret, err := c.GetHost(ctx, env, host)
+	if err != nil {
+		return sshclient.SSHConfig{}, err
+	}
+	mode, err := secret.Mode()
+	if err != nil {
+		return sshclient.SSHConfig{}, fmt.Errorf("host secret %s/%s: %w", env, host, err)
+	}
+
+	var cfg sshclient.SSHConfig
+	switch mode {
+	case CredentialCert:
+		cfg, err = c.certSSHConfig(ctx, secret, CertOptions{Mount: secret.SSHMount, Role: secret.SSHRole})
+	case CredentialOTP:
+		cfg, err = c.otpSSHConfig(ctx, secret, OTPOptions{Mount: secret.SSHMount, Role: secret.SSHRole})
+	default:
+		cfg, err = secret.SSHConfig()
+	}
+	if err != nil {
+		return sshclient.SSHConfig{}, err
+	}
+	return c.withJumpHost(ctx, env, host, secret, cfg, chain)
+}
+
+// withJumpHost sets cfg.JumpHosts to the configuration of the jump host named by secret
+// (the host secret env/host), if any.
+func (c *Client) withJumpHost(ctx context.Context, env, host string, secret *HostSecret,
+	cfg sshclient.SSHConfig, chain []string) (sshclient.SSHConfig, error) {
+	jumpEnv, jumpHost, ok := secret.JumpHostRef(env)
+	if !ok {
+		return cfg, nil
+	}
+
+	chain = append(chain, env+"/"+host)
+	ref := jumpEnv + "/" + jumpHost
+	if slices.Contains(chain, ref) {
+		return sshclient.SSHConfig{}, fmt.Errorf("jump host loop: %s -> %s", strings.Join(chain, " -> "), ref)
+	}
+	if len(chain) > maxJumpHosts {
+		return sshclient.SSHConfig{}, fmt.Errorf("jump host chain for %s is longer than %d hosts", chain[0], maxJumpHosts)
+	}
+
+	jumpCfg, err := c.hostSSHConfig(ctx, jumpEnv, jumpHost, chain)
+	if err != nil {
+		return sshclient.SSHConfig{}, fmt.Errorf("failed to load jump host %s for %s/%s: %w", ref, env, host, err)
+	}
+	cfg.JumpHosts = []sshclient.SSHConfig{jumpCfg}
+	return cfg, nil
+}
+
+// list runs a KV v2 metadata listing of path and returns its keys.
+func (c *Client) list(ctx context.Context, path string) ([]string, error) {
+	var resp struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	query := url.Values{"list": {"true"}}
+	if err := c.do(ctx, http.MethodGet, c.mount+"/metadata/"+path, query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data.Keys, nil
+}
+
+// do sends an API request to /v1/<path> and decodes the JSON response into out (if non-nil).
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	u := *c.address
+	u.Path = strings.TrimRight(u.Path, "/") + "/v1/" + path
+	u.RawQuery = query.Encode()
+
+	var reqBody io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if token := c.Token(); token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		var errResp struct {
+			Errors []string `json:"errors"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&errResp) == nil {
+			apiErr.Errors = errResp.Errors
+		}
+		return apiErr
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode vault response: %w", err)
+	}
+	return nil
+}
+
+// filterKeys returns the folder keys (trailing "/") when folders is true, or the leaf keys
+// otherwise, with the trailing slash removed and sorted.
+func filterKeys(keys []string, folders bool) []string {
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		isFolder := strings.HasSuffix(k, "/")
+		if isFolder == folders {
+			out = append(out, strings.TrimSuffix(k, "/"))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// validateSegment makes sure name can be used as a single path segment.
+func validateSegment(kind, name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/?#") {
+		return fmt.Errorf("invalid %s name %q", kind, name)
+	}
+	return nil
 }
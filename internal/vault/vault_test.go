@@ -0,0 +1,317 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testToken = "test-token"
+
+// fakeVault is an in-process stand-in for the parts of the Vault HTTP API used by this package.
+type fakeVault struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu      sync.Mutex
+	tokens  map[string]bool           // Tokens accepted on authenticated endpoints
+	secrets map[string]map[string]any // KV v2 secrets by path under the "secret" mount
+	routes  map[string]http.HandlerFunc
+}
+
+// newFakeVault starts a fake Vault server that accepts testToken.
+func newFakeVault(t *testing.T) *fakeVault {
+	t.Helper()
+	fv := &fakeVault{
+		t:       t,
+		tokens:  map[string]bool{testToken: true},
+		secrets: map[string]map[string]any{},
+		routes:  map[string]http.HandlerFunc{},
+	}
+	fv.server = httptest.NewServer(http.HandlerFunc(fv.serveHTTP))
+	t.Cleanup(fv.server.Close)
+	return fv
+}
+
+// putSecret stores data at the given path under the KV mount.
+func (fv *fakeVault) putSecret(path string, data map[string]any) {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	fv.secrets[path] = data
+}
+
+// handle registers a handler for an exact API path (e.g. "/v1/auth/approle/login").
+// Handlers registered this way do not require a token.
+func (fv *fakeVault) handle(path string, h http.HandlerFunc) {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	fv.routes[path] = h
+}
+
+// client returns a Client pointed at the fake server.
+func (fv *fakeVault) client(t *testing.T) *Client {
+	t.Helper()
+	c, err := NewClient(Config{Address: fv.server.URL, Token: testToken})
+	if err != nil {
+		t.Fatalf("NewClient() unexpected error: %v", err)
+	}
+	return c
+}
+
+func writeVaultError(w http.ResponseWriter, status int, msgs ...string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{"errors": append([]string{}, msgs...)})
+}
+
+func writeVaultJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (fv *fakeVault) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	fv.mu.Lock()
+	route, ok := fv.routes[r.URL.Path]
+	authorized := fv.tokens[r.Header.Get("X-Vault-Token")]
+	fv.mu.Unlock()
+	if ok {
+		route(w, r)
+		return
+	}
+	if !authorized {
+		writeVaultError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/data/") && r.Method == http.MethodGet:
+		path := strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")
+		fv.mu.Lock()
+		data, found := fv.secrets[path]
+		fv.mu.Unlock()
+		if !found {
+			writeVaultError(w, http.StatusNotFound)
+			return
+		}
+		writeVaultJSON(w, map[string]any{
+			"data": map[string]any{"data": data, "metadata": map[string]any{"version": 1}},
+		})
+
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/metadata/") &&
+		(r.Method == "LIST" || (r.Method == http.MethodGet && r.URL.Query().Get("list") == "true")):
+		prefix := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/secret/metadata/"), "/") + "/"
+		keys := fv.listKeys(prefix)
+		if len(keys) == 0 {
+			writeVaultError(w, http.StatusNotFound)
+			return
+		}
+		writeVaultJSON(w, map[string]any{"data": map[string]any{"keys": keys}})
+
+	default:
+		writeVaultError(w, http.StatusNotFound)
+	}
+}
+
+// listKeys mimics a KV v2 metadata LIST: direct children of prefix, folders suffixed with "/".
+func (fv *fakeVault) listKeys(prefix string) []string {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	seen := map[string]bool{}
+	for path := range fv.secrets {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(path, prefix)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			rest = rest[:i+1]
+		}
+		seen[rest] = true
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func newTestVaultWithHosts(t *testing.T) *fakeVault {
+	t.Helper()
+	fv := newFakeVault(t)
+	fv.putSecret("ssh/hosts/dev/busybox-host-1", map[string]any{
+		"hostname": "busybox-host-1", "ip": "10.0.0.5", "port": "2222",
+		"username": "root", "password": "pw", "key": "", "key_passphrase": "",
+	})
+	fv.putSecret("ssh/hosts/dev/busybox-host-3", map[string]any{
+		"hostname": "busybox-host-3", "ip": "10.0.0.7", "port": "22", "username": "admin",
+	})
+	fv.putSecret("ssh/hosts/prod/busybox-host-2", map[string]any{
+		"hostname": "busybox-host-2", "ip": "10.0.1.5", "port": "22", "username": "root",
+	})
+	return fv
+}
+
+func TestClientListing(t *testing.T) {
+	fv := newTestVaultWithHosts(t)
+	c := fv.client(t)
+	ctx := context.Background()
+
+	envs, err := c.ListEnvironments(ctx)
+	if err != nil {
+		t.Fatalf("ListEnvironments() unexpected error: %v", err)
+	}
+	if want := []string{"dev", "prod"}; !reflect.DeepEqual(envs, want) {
+		t.Errorf("Expected environments %v, got %v", want, envs)
+	}
+
+	hosts, err := c.ListHosts(ctx, "dev")
+	if err != nil {
+		t.Fatalf("ListHosts() unexpected error: %v", err)
+	}
+	if want := []string{"busybox-host-1", "busybox-host-3"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("Expected hosts %v, got %v", want, hosts)
+	}
+
+	if _, err := c.ListHosts(ctx, "staging"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for unknown environment, got: %v", err)
+	}
+	if _, err := c.ListHosts(ctx, "../prod"); err == nil {
+		t.Errorf("Expected an error for an invalid environment name")
+	}
+}
+
+func TestClientGetHost(t *testing.T) {
+	fv := newTestVaultWithHosts(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		token         string
+		env, host     string
+		expectError   error
+		expectAddress string
+	}{
+		{
+			name:          "Existing Host",
+			token:         testToken,
+			env:           "dev",
+			host:          "busybox-host-1",
+			expectAddress: "10.0.0.5:2222",
+		},
+		{
+			name:        "Missing Host",
+			token:       testToken,
+			env:         "dev",
+			host:        "nope",
+			expectError: ErrNotFound,
+		},
+		{
+			name:        "Permission Denied",
+			token:       "bad-token",
+			env:         "prod",
+			host:        "busybox-host-2",
+			expectError: &APIError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fv.client(t)
+			c.SetToken(tt.token)
+			cfg, err := c.HostSSHConfig(ctx, tt.env, tt.host)
+
+			switch tt.expectError.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if cfg.Address != tt.expectAddress {
+					t.Errorf("Expected address %s, got %s", tt.expectAddress, cfg.Address)
+				}
+			case *APIError:
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+					t.Errorf("Expected a 403 APIError, got: %v", err)
+				}
+			default:
+				if !errors.Is(err, tt.expectError) {
+					t.Errorf("Expected %v, got: %v", tt.expectError, err)
+				}
+			}
+		})
+	}
+}
+
+func TestHostSSHConfig_JumpHost(t *testing.T) {
+	fv := newTestVaultWithHosts(t)
+	host := func(name, ip, jumpHost string) map[string]any {
+		return map[string]any{"hostname": name, "ip": ip, "username": "ops", "password": "pw-" + name, "jump_host": jumpHost}
+	}
+	fv.putSecret("ssh/hosts/shared/edge", host("edge", "203.0.113.1", ""))
+	fv.putSecret("ssh/hosts/dev/bastion", host("bastion", "10.0.0.2", "shared/edge"))
+	fv.putSecret("ssh/hosts/dev/app", host("app", "10.0.0.20", "bastion"))
+	fv.putSecret("ssh/hosts/dev/loop-a", host("loop-a", "10.0.0.30", "loop-b"))
+	fv.putSecret("ssh/hosts/dev/loop-b", host("loop-b", "10.0.0.31", "loop-a"))
+	fv.putSecret("ssh/hosts/dev/orphan", host("orphan", "10.0.0.40", "gone"))
+	c := fv.client(t)
+
+	tests := []struct {
+		name          string
+		host          string
+		expectChain   []string // Addresses from the target outwards
+		expectError   bool
+		errorContains string
+	}{
+		{name: "No Jump Host", host: "busybox-host-1", expectChain: []string{"10.0.0.5:2222"}},
+		{name: "Chained Bastions", host: "app", expectChain: []string{"10.0.0.20:22", "10.0.0.2:22", "203.0.113.1:22"}},
+		{name: "Loop", host: "loop-a", expectError: true, errorContains: "jump host loop: dev/loop-a -> dev/loop-b -> dev/loop-a"},
+		{name: "Missing Jump Host", host: "orphan", expectError: true, errorContains: "failed to load jump host dev/gone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := c.HostSSHConfig(context.Background(), "dev", tt.host)
+			if tt.expectError {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			var chain []string
+			for hop := &cfg; hop != nil; {
+				chain = append(chain, hop.Address)
+				switch len(hop.JumpHosts) {
+				case 0:
+					hop = nil
+				case 1:
+					hop = &hop.JumpHosts[0]
+				default:
+					t.Fatalf("Expected at most one jump host per hop, got %d", len(hop.JumpHosts))
+				}
+			}
+			if !reflect.DeepEqual(chain, tt.expectChain) {
+				t.Errorf("Expected chain %v, got %v", tt.expectChain, chain)
+			}
+		})
+	}
+}
+
+func TestNewClientValidation(t *testing.T) {
+	if _, err := NewClient(Config{}); err == nil {
+		t.Errorf("Expected an error for a missing address")
+	}
+	if _, err := NewClient(Config{Address: "vault:8200"}); err == nil {
+		t.Errorf("Expected an error for an address without scheme")
+	}
+}
@@ -0,0 +1,103 @@
+// pkg/sshclient/agent.go
+
+package sshclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentSocket returns the ssh-agent socket path: cfg.AgentSocket or $SSH_AUTH_SOCK.
+func (cfg SSHConfig) agentSocket() string {
+	if cfg.AgentSocket != "" {
+		return cfg.AgentSocket
+	}
+	return os.Getenv("SSH_AUTH_SOCK")
+}
+
+// agent returns the agent to use for authentication and/or forwarding, or nil if neither
+// is enabled. A security key in cfg.Key also needs the agent (see securityKeySigner). The
+// returned closer (possibly nil) releases the connection to a local agent.
+func (cfg SSHConfig) agent() (agent.Agent, io.Closer, error) {
+	securityKey := cfg.Signer == nil && isSecurityKey(cfg.Key)
+	if !cfg.UseAgent && !cfg.ForwardAgent && !securityKey {
+		return nil, nil, nil
+	}
+	if cfg.Agent != nil {
+		return cfg.Agent, nil, nil
+	}
+
+	socket := cfg.agentSocket()
+	if socket == "" {
+		if !cfg.UseAgent && !cfg.ForwardAgent {
+			return nil, nil, nil // Reported by securityKeySigner
+		}
+		return nil, nil, errors.New("ssh-agent requested but SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to ssh-agent at %s: %w", socket, err)
+	}
+	return agent.NewClient(conn), conn, nil
+}
+
+// NewKeyringAgent returns an in-memory agent holding only the key (and certificate, if any)
+// from cfg. Set it as SSHConfig.Agent with ForwardAgent to let the remote session use the
+// Vault-provided key for onward hops without the key ever touching disk.
+func NewKeyringAgent(cfg SSHConfig) (agent.Agent, error) {
+	if len(cfg.Key) == 0 {
+		return nil, errors.New("no private key to add to the agent")
+	}
+	rawKey, err := cfg.privateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	added := agent.AddedKey{PrivateKey: rawKey, Comment: "jet-access"}
+	if len(cfg.Certificate) > 0 {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey(cfg.Certificate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SSH certificate: %w", err)
+		}
+		cert, ok := pub.(*ssh.Certificate)
+		if !ok {
+			return nil, fmt.Errorf("failed to parse SSH certificate: got a plain %s public key", pub.Type())
+		}
+		added.Certificate = cert
+	}
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(added); err != nil {
+		return nil, fmt.Errorf("failed to add key to in-memory agent: %w", err)
+	}
+	return keyring, nil
+}
+
+// forwardAgent arranges for agent channels opened by the server on client to be served by ag.
+func forwardAgent(client *ssh.Client, ag agent.Agent) error {
+	if err := agent.ForwardToAgent(client, ag); err != nil {
+		return fmt.Errorf("failed to set up agent forwarding: %w", err)
+	}
+	return nil
+}
+
+// openSession creates a new session on client, requesting agent forwarding if configured.
+func openSession(client *ssh.Client, cfg SSHConfig) (*ssh.Session, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	if cfg.ForwardAgent {
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			session.Close()
+			return nil, fmt.Errorf("failed to request agent forwarding: %w", err)
+		}
+	}
+	return session, nil
+}
@@ -0,0 +1,233 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// startTestAgent serves keyring on a unix socket in a temp dir and returns the socket path.
+func startTestAgent(t *testing.T, keyring agent.Agent) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on agent socket: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	return socket
+}
+
+func TestDial_AgentAuth(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ed25519 key: %v", err)
+	}
+	agentKey, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatalf("Failed to add key to agent: %v", err)
+	}
+	socket := startTestAgent(t, keyring)
+
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {},
+		ssh.PublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
+			return bytes.Equal(key.Marshal(), agentKey.PublicKey().Marshal())
+		}))
+	defer stopServer()
+
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	tests := []struct {
+		name          string
+		useAgent      bool
+		socket        string
+		expectError   bool
+		errorContains string
+	}{
+		{name: "Agent Key Accepted", useAgent: true, socket: socket},
+		{name: "Agent Not Enabled", useAgent: false, socket: socket, expectError: true, errorContains: "no authentication methods"},
+		{name: "Missing Socket", useAgent: true, socket: filepath.Join(t.TempDir(), "missing.sock"), expectError: true, errorContains: "failed to connect to ssh-agent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := dial(context.Background(), SSHConfig{
+				Address:         addr,
+				User:            "agentuser",
+				UseAgent:        tt.useAgent,
+				AgentSocket:     tt.socket,
+				KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+				HostKeyPolicy:   HostKeyAcceptNew,
+			})
+			if tt.expectError {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+				}
+				if client != nil {
+					client.Close()
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			client.Close()
+		})
+	}
+}
+
+// TestOpenSession_ForwardAgent checks that the remote side can list the keys of a
+// forwarded in-memory agent built from the configured private key.
+func TestOpenSession_ForwardAgent(t *testing.T) {
+	privateKey, publicKey, err := generateTestKey(2048, nil)
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+	pub, _, _, _, err := gossh.ParseAuthorizedKey(publicKey)
+	if err != nil {
+		t.Fatalf("Failed to parse public key: %v", err)
+	}
+
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {
+		if !ssh.AgentRequested(s) {
+			io.WriteString(s, "no agent")
+			s.Exit(1)
+			return
+		}
+		l, err := ssh.NewAgentListener()
+		if err != nil {
+			s.Exit(1)
+			return
+		}
+		defer l.Close()
+		go ssh.ForwardAgentConnections(l, s)
+
+		conn, err := net.Dial(l.Addr().Network(), l.Addr().String())
+		if err != nil {
+			s.Exit(1)
+			return
+		}
+		defer conn.Close()
+		keys, err := agent.NewClient(conn).List()
+		if err != nil {
+			io.WriteString(s, err.Error())
+			s.Exit(1)
+			return
+		}
+		for _, k := range keys {
+			io.WriteString(s, gossh.FingerprintSHA256(k)+"\n")
+		}
+		s.Exit(0)
+	}, ssh.PasswordAuth(func(ctx ssh.Context, password string) bool { return password == "pw" }))
+	defer stopServer()
+
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	cfg := SSHConfig{
+		Address:         addr,
+		User:            "forwarduser",
+		Password:        "pw",
+		ForwardAgent:    true,
+		KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+		HostKeyPolicy:   HostKeyAcceptNew,
+	}
+	keyring, err := NewKeyringAgent(SSHConfig{Key: privateKey})
+	if err != nil {
+		t.Fatalf("Failed to create keyring agent: %v", err)
+	}
+	cfg.Agent = keyring
+
+	client, err := dial(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer client.Close()
+
+	session, err := openSession(client, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer session.Close()
+
+	out, err := session.Output("list-keys")
+	if err != nil {
+		var exitErr *gossh.ExitError
+		if !errors.As(err, &exitErr) {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		t.Fatalf("Remote side could not use the forwarded agent: %s", out)
+	}
+	if want := gossh.FingerprintSHA256(pub); strings.TrimSpace(string(out)) != want {
+		t.Errorf("Expected forwarded key %s, got %q", want, out)
+	}
+}
+
+func TestNewKeyringAgent(t *testing.T) {
+	keyPassphrase := "testpass"
+	privateKeyEnc, _, err := generateTestKey(2048, []byte(keyPassphrase))
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		cfg           SSHConfig
+		expectError   bool
+		errorContains string
+	}{
+		{name: "Encrypted Key With Passphrase", cfg: SSHConfig{Key: privateKeyEnc, Passphrase: keyPassphrase}},
+		{name: "Encrypted Key Without Passphrase", cfg: SSHConfig{Key: privateKeyEnc}, expectError: true, errorContains: "failed to parse private key"},
+		{name: "No Key", cfg: SSHConfig{}, expectError: true, errorContains: "no private key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ag, err := NewKeyringAgent(tt.cfg)
+			if tt.expectError {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if keys, err := ag.List(); err != nil || len(keys) != 1 {
+				t.Errorf("Expected 1 key in agent, got %d (err: %v)", len(keys), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+// pkg/sshclient/algorithms.go
+
+package sshclient
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AlgorithmPolicy is a named set of the cryptographic algorithms offered to servers.
+type AlgorithmPolicy int
+
+const (
+	// AlgorithmsDefault offers the x/crypto defaults (default).
+	AlgorithmsDefault AlgorithmPolicy = iota
+	// AlgorithmsModern only offers algorithms without known weaknesses: no SHA-1, no CBC
+	// ciphers and no ssh-rsa (SHA-1) host key signatures.
+	AlgorithmsModern
+	// AlgorithmsCompatible also offers the legacy algorithms old appliances and embedded
+	// devices may need, after the modern ones.
+	AlgorithmsCompatible
+	// AlgorithmsFIPS only offers algorithms approved by FIPS 140-3 (no Curve25519,
+	// ChaCha20-Poly1305 or Ed25519). It restricts what is negotiated; it does not make
+	// the implementation a validated module.
+	AlgorithmsFIPS
+)
+
+// String returns the name of the policy, as accepted by ParseAlgorithmPolicy.
+func (p AlgorithmPolicy) String() string {
+	switch p {
+	case AlgorithmsDefault:
+		return "default"
+	case AlgorithmsModern:
+		return "modern"
+	case AlgorithmsCompatible:
+		return "compatible"
+	case AlgorithmsFIPS:
+		return "fips"
+	default:
+		return fmt.Sprintf("AlgorithmPolicy(%d)", int(p))
+	}
+}
+
+// ParseAlgorithmPolicy returns the policy with the given name ("default", "modern",
+// "compatible" or "fips"); an empty name is the default policy.
+func ParseAlgorithmPolicy(name string) (AlgorithmPolicy, error) {
+	for _, p := range []AlgorithmPolicy{AlgorithmsDefault, AlgorithmsModern, AlgorithmsCompatible, AlgorithmsFIPS} {
+		if strings.EqualFold(name, p.String()) {
+			return p, nil
+		}
+	}
+	if name == "" {
+		return AlgorithmsDefault, nil
+	}
+	return 0, fmt.Errorf("unknown algorithm policy %q (expected default, modern, compatible or fips)", name)
+}
+
+// Algorithms lists the algorithms offered for each part of the SSH transport, in order
+// of preference. A nil list keeps the x/crypto default.
+type Algorithms struct {
+	KeyExchanges      []string
+	Ciphers           []string
+	MACs              []string
+	HostKeyAlgorithms []string
+}
+
+var modernAlgorithms = Algorithms{
+	KeyExchanges: []string{
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group16-sha512",
+	},
+	Ciphers: []string{
+		"chacha20-poly1305@openssh.com", "aes256-gcm@openssh.com", "aes128-gcm@openssh.com",
+		"aes256-ctr", "aes192-ctr", "aes128-ctr",
+	},
+	MACs: []string{"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com"},
+	HostKeyAlgorithms: []string{
+		ssh.CertAlgoED25519v01, ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+		ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+		ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+	},
+}
+
+var compatibleAlgorithms = Algorithms{
+	KeyExchanges: append(slices.Clone(modernAlgorithms.KeyExchanges),
+		"diffie-hellman-group14-sha256", "diffie-hellman-group-exchange-sha256",
+		"diffie-hellman-group14-sha1", "diffie-hellman-group-exchange-sha1", "diffie-hellman-group1-sha1",
+	),
+	Ciphers: append(slices.Clone(modernAlgorithms.Ciphers), "aes128-cbc", "3des-cbc"),
+	MACs:    append(slices.Clone(modernAlgorithms.MACs), "hmac-sha2-256", "hmac-sha2-512", "hmac-sha1", "hmac-sha1-96"),
+	HostKeyAlgorithms: append(slices.Clone(modernAlgorithms.HostKeyAlgorithms),
+		ssh.CertAlgoRSAv01, ssh.CertAlgoDSAv01, ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+	),
+}
+
+var fipsAlgorithms = Algorithms{
+	KeyExchanges: []string{
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group16-sha512", "diffie-hellman-group14-sha256",
+	},
+	Ciphers: []string{"aes256-gcm@openssh.com", "aes128-gcm@openssh.com", "aes256-ctr", "aes192-ctr", "aes128-ctr"},
+	MACs:    []string{"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com", "hmac-sha2-256", "hmac-sha2-512"},
+	HostKeyAlgorithms: []string{
+		ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+		ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+	},
+}
+
+// supportedAlgorithms are all the algorithms x/crypto implements for clients.
+var supportedAlgorithms = Algorithms{
+	KeyExchanges:      compatibleAlgorithms.KeyExchanges,
+	Ciphers:           append(slices.Clone(compatibleAlgorithms.Ciphers), "arcfour256", "arcfour128", "arcfour"),
+	MACs:              compatibleAlgorithms.MACs,
+	HostKeyAlgorithms: compatibleAlgorithms.HostKeyAlgorithms,
+}
+
+// Algorithms returns the algorithms of the policy. All lists are nil for AlgorithmsDefault.
+func (p AlgorithmPolicy) Algorithms() Algorithms {
+	var a Algorithms
+	switch p {
+	case AlgorithmsModern:
+		a = modernAlgorithms
+	case AlgorithmsCompatible:
+		a = compatibleAlgorithms
+	case AlgorithmsFIPS:
+		a = fipsAlgorithms
+	}
+	return Algorithms{
+		KeyExchanges:      slices.Clone(a.KeyExchanges),
+		Ciphers:           slices.Clone(a.Ciphers),
+		MACs:              slices.Clone(a.MACs),
+		HostKeyAlgorithms: slices.Clone(a.HostKeyAlgorithms),
+	}
+}
+
+// algorithms returns the algorithms to offer for cfg: cfg.AlgorithmPolicy, with any list
+// set explicitly in cfg taking precedence. Unsupported algorithm names are an error, as
+// x/crypto would otherwise silently drop them.
+func (cfg SSHConfig) algorithms() (Algorithms, error) {
+	a := cfg.AlgorithmPolicy.Algorithms()
+	lists := []struct {
+		kind      string
+		list      *[]string
+		override  []string
+		supported []string
+	}{
+		{"key exchange", &a.KeyExchanges, cfg.KeyExchanges, supportedAlgorithms.KeyExchanges},
+		{"cipher", &a.Ciphers, cfg.Ciphers, supportedAlgorithms.Ciphers},
+		{"MAC", &a.MACs, cfg.MACs, supportedAlgorithms.MACs},
+		{"host key", &a.HostKeyAlgorithms, cfg.HostKeyAlgorithms, supportedAlgorithms.HostKeyAlgorithms},
+	}
+	for _, l := range lists {
+		if len(l.override) > 0 {
+			*l.list = l.override
+		}
+		for _, name := range *l.list {
+			if !slices.Contains(l.supported, name) {
+				return Algorithms{}, fmt.Errorf("unsupported %s algorithm %q", l.kind, name)
+			}
+		}
+	}
+	return a, nil
+}
+
+// restrictHostKeyAlgorithms narrows the host key algorithms asked for to those allowed.
+// preferred (e.g. the algorithms of the keys in known_hosts) come first; if none of them
+// is allowed, all allowed algorithms are asked for, so that the server's key is at least
+// reported. A nil allowed list puts no restriction on preferred.
+func restrictHostKeyAlgorithms(preferred, allowed []string) []string {
+	if allowed == nil {
+		return preferred
+	}
+	var algos []string
+	for _, algo := range preferred {
+		if slices.Contains(allowed, algo) {
+			algos = append(algos, algo)
+		}
+	}
+	if len(algos) == 0 {
+		return allowed
+	}
+	return algos
+}
+
+// logNegotiationFailure logs what the server at addr offered when no algorithm could be
+// agreed on, from the x/crypto error "ssh: no common algorithm for <what>; client
+// offered: [...], server offered: [...]".
+func logNegotiationFailure(addr string, err error) {
+	_, rest, _ := strings.Cut(err.Error(), "no common algorithm for ")
+	what, rest, _ := strings.Cut(rest, "; client offered: ")
+	offered, serverOffered, _ := strings.Cut(rest, ", server offered: ")
+	log.Printf("Warning: %s and this client have no %s algorithm in common.", addr, what)
+	log.Printf("Warning: The server offers: %s", strings.Trim(serverOffered, "[]"))
+	log.Printf("Warning: The client allows: %s (see the algorithm policy)", strings.Trim(offered, "[]"))
+}
@@ -0,0 +1,210 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// serverAlgorithms returns a server option restricting the algorithms the server offers.
+func serverAlgorithms(config gossh.Config) ssh.Option {
+	return func(srv *ssh.Server) error {
+		srv.ServerConfigCallback = func(ssh.Context) *gossh.ServerConfig {
+			return &gossh.ServerConfig{Config: config}
+		}
+		return nil
+	}
+}
+
+// ed25519HostKey returns a server option replacing the server's host keys with an Ed25519 key.
+func ed25519HostKey(t *testing.T) ssh.Option {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("Failed to create host key signer: %v", err)
+	}
+	return func(srv *ssh.Server) error {
+		srv.HostSigners = []ssh.Signer{signer}
+		return nil
+	}
+}
+
+func TestParseAlgorithmPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		expectPolicy AlgorithmPolicy
+		expectError  bool
+	}{
+		{name: "", expectPolicy: AlgorithmsDefault},
+		{name: "default", expectPolicy: AlgorithmsDefault},
+		{name: "modern", expectPolicy: AlgorithmsModern},
+		{name: "Compatible", expectPolicy: AlgorithmsCompatible},
+		{name: "FIPS", expectPolicy: AlgorithmsFIPS},
+		{name: "legacy", expectError: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAlgorithmPolicy(tt.name)
+		if (err != nil) != tt.expectError || got != tt.expectPolicy {
+			t.Errorf("ParseAlgorithmPolicy(%q) = %v, %v; expected %v (error: %v)", tt.name, got, err, tt.expectPolicy, tt.expectError)
+		}
+	}
+}
+
+func TestAlgorithmPolicy_Presets(t *testing.T) {
+	tests := []struct {
+		policy    AlgorithmPolicy
+		forbidden []string // Substrings no algorithm of the policy may contain
+	}{
+		{policy: AlgorithmsModern, forbidden: []string{"sha1", "cbc", "arcfour", "ssh-rsa", "ssh-dss"}},
+		{policy: AlgorithmsCompatible},
+		{policy: AlgorithmsFIPS, forbidden: []string{"sha1", "cbc", "arcfour", "curve25519", "chacha20", "ed25519"}},
+	}
+	for _, tt := range tests {
+		a, err := SSHConfig{AlgorithmPolicy: tt.policy}.algorithms()
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tt.policy, err)
+		}
+		all := slices.Concat(a.KeyExchanges, a.Ciphers, a.MACs, a.HostKeyAlgorithms)
+		if len(a.KeyExchanges) == 0 || len(a.Ciphers) == 0 || len(a.MACs) == 0 || len(a.HostKeyAlgorithms) == 0 {
+			t.Errorf("%v: expected every list to be set, got %+v", tt.policy, a)
+		}
+		for _, algo := range all {
+			for _, bad := range tt.forbidden {
+				if strings.Contains(algo, bad) {
+					t.Errorf("%v: unexpected algorithm %q", tt.policy, algo)
+				}
+			}
+		}
+	}
+
+	// Presets are copied, so callers cannot change them for everyone
+	AlgorithmsModern.Algorithms().Ciphers[0] = "3des-cbc"
+	if AlgorithmsModern.Algorithms().Ciphers[0] == "3des-cbc" {
+		t.Error("Expected Algorithms() to return a copy of the preset")
+	}
+}
+
+func TestRestrictHostKeyAlgorithms(t *testing.T) {
+	tests := []struct {
+		name               string
+		preferred, allowed []string
+		expectAlgorithms   []string
+	}{
+		{name: "No Policy", preferred: []string{"ssh-ed25519"}, expectAlgorithms: []string{"ssh-ed25519"}},
+		{name: "Unknown Host", allowed: []string{"ecdsa-sha2-nistp256", "rsa-sha2-512"}, expectAlgorithms: []string{"ecdsa-sha2-nistp256", "rsa-sha2-512"}},
+		{
+			name:             "Known Keys Filtered",
+			preferred:        []string{"rsa-sha2-512", "rsa-sha2-256", "ssh-rsa"},
+			allowed:          []string{"ecdsa-sha2-nistp256", "rsa-sha2-512", "rsa-sha2-256"},
+			expectAlgorithms: []string{"rsa-sha2-512", "rsa-sha2-256"},
+		},
+		{name: "No Known Key Allowed", preferred: []string{"ssh-ed25519"}, allowed: []string{"rsa-sha2-512"}, expectAlgorithms: []string{"rsa-sha2-512"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := restrictHostKeyAlgorithms(tt.preferred, tt.allowed); !slices.Equal(got, tt.expectAlgorithms) {
+				t.Errorf("Expected %v, got %v", tt.expectAlgorithms, got)
+			}
+		})
+	}
+}
+
+func TestAlgorithmPolicy_Negotiation(t *testing.T) {
+	originalLogOutput := log.Writer()
+	defer log.SetOutput(originalLogOutput)
+
+	tests := []struct {
+		name          string
+		serverOptions []ssh.Option
+		policy        AlgorithmPolicy
+		ciphers       []string
+		expectError   error
+		errorContains string
+		expectLog     []string
+	}{
+		{name: "Modern", policy: AlgorithmsModern},
+		{name: "FIPS", policy: AlgorithmsFIPS},
+		{
+			name:          "Legacy Server Rejected",
+			serverOptions: []ssh.Option{serverAlgorithms(gossh.Config{Ciphers: []string{"aes128-cbc"}})},
+			policy:        AlgorithmsModern,
+			expectError:   ErrNoCommonAlgorithm,
+			expectLog:     []string{"no client to server cipher algorithm in common", "The server offers: aes128-cbc", "The client allows: chacha20-poly1305@openssh.com"},
+		},
+		{
+			name:          "Legacy Server Compatible",
+			serverOptions: []ssh.Option{serverAlgorithms(gossh.Config{Ciphers: []string{"aes128-cbc"}})},
+			policy:        AlgorithmsCompatible,
+		},
+		{
+			name:          "Explicit Ciphers Override Policy",
+			serverOptions: []ssh.Option{serverAlgorithms(gossh.Config{Ciphers: []string{"aes128-cbc"}})},
+			policy:        AlgorithmsModern,
+			ciphers:       []string{"aes128-cbc"},
+		},
+		{
+			name:          "FIPS Key Exchange",
+			serverOptions: []ssh.Option{serverAlgorithms(gossh.Config{KeyExchanges: []string{"curve25519-sha256"}})},
+			policy:        AlgorithmsFIPS,
+			expectError:   ErrNoCommonAlgorithm,
+			expectLog:     []string{"no key exchange algorithm in common", "The server offers: curve25519-sha256"},
+		},
+		{
+			name:          "FIPS Host Key",
+			serverOptions: []ssh.Option{ed25519HostKey(t)},
+			policy:        AlgorithmsFIPS,
+			expectError:   ErrNoCommonAlgorithm,
+			expectLog:     []string{"no host key algorithm in common", "The server offers: ssh-ed25519"},
+		},
+		{
+			name:          "Unsupported Algorithm",
+			ciphers:       []string{"aes512-ctr"},
+			errorContains: `unsupported cipher algorithm "aes512-ctr"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			addr, stopServer := startMockSSHServer(t, commandHandler, append(tt.serverOptions, passwordFor("runner", "pw"))...)
+			defer stopServer()
+
+			cfg := runTestConfig(t, addr)
+			cfg.AlgorithmPolicy = tt.policy
+			cfg.Ciphers = tt.ciphers
+			result, err := Run(context.Background(), cfg, "echo negotiated")
+			switch {
+			case tt.expectError != nil:
+				if !errors.Is(err, tt.expectError) {
+					t.Errorf("Expected error matching %v, got: %v", tt.expectError, err)
+				}
+			case tt.errorContains != "":
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+				}
+			case err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			case string(result.Stdout) != "negotiated\n":
+				t.Errorf("Expected output %q, got %q", "negotiated\n", result.Stdout)
+			}
+			for _, want := range tt.expectLog {
+				if !strings.Contains(logs.String(), want) {
+					t.Errorf("Expected the log to contain %q, got:\n%s", want, logs.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// pkg/sshclient/auth.go
+
+package sshclient
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// authMethods builds the SSH authentication methods for cfg, in order of preference:
+// public key (the configured key or certificate first, then keys from ag when
+// cfg.UseAgent is set), then password and keyboard-interactive.
+func (cfg SSHConfig) authMethods(ag agent.Agent) ([]ssh.AuthMethod, error) {
+	authMethods := []ssh.AuthMethod{}
+
+	signer, err := cfg.signer(ag)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.UseAgent {
+		ag = nil
+	}
+	// The client only tries one "publickey" method, so all signers go into one callback.
+	if signer != nil || ag != nil {
+		authMethods = append(authMethods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			var signers []ssh.Signer
+			if signer != nil {
+				signers = append(signers, signer)
+			}
+			if ag != nil {
+				agentSigners, err := ag.Signers()
+				if err != nil {
+					return nil, fmt.Errorf("failed to list ssh-agent keys: %w", err)
+				}
+				signers = append(signers, agentSigners...)
+			}
+			return signers, nil
+		}))
+	}
+
+	// Add password authentication if password is provided (Key takes precedence if both exist)
+	// A real-world scenario might prioritize key auth, but this adds password if key isn't used.
+	// You might adjust this logic based on your Vault secret structure and priority.
+	if cfg.Password != "" {
+		authMethods = append(authMethods, ssh.Password(cfg.Password))
+	}
+
+	// PAM-based servers (MFA, Vault's SSH OTP helper) ask for the password and any
+	// further codes via keyboard-interactive instead of the password method.
+	if responder := cfg.challengeResponder(); responder != nil {
+		authMethods = append(authMethods, ssh.KeyboardInteractive(ssh.KeyboardInteractiveChallenge(responder)))
+	}
+
+	if len(authMethods) == 0 {
+		return nil, fmt.Errorf("no authentication methods successfully configured (no valid key, agent or password provided)")
+	}
+	return authMethods, nil
+}
+
+// signer returns the public key signer for cfg: cfg.Signer, or cfg.Key parsed (see
+// privateKey). Security keys are used through ag (see securityKeySigner). If
+// cfg.Certificate is set the signer presents that certificate. It returns nil if no key
+// is configured.
+func (cfg SSHConfig) signer(ag agent.Agent) (ssh.Signer, error) {
+	signer := cfg.Signer
+	switch {
+	case signer != nil || len(cfg.Key) == 0:
+	case isSecurityKey(cfg.Key):
+		var err error
+		if signer, err = securityKeySigner(cfg.Key, ag); err != nil {
+			return nil, err
+		}
+	default:
+		rawKey, err := cfg.privateKey()
+		if err != nil {
+			return nil, err
+		}
+		if signer, err = ssh.NewSignerFromKey(rawKey); err != nil {
+			return nil, fmt.Errorf("failed to create signer from parsed key: %w", err)
+		}
+	}
+
+	if len(cfg.Certificate) == 0 {
+		return signer, nil
+	}
+	if signer == nil {
+		return nil, fmt.Errorf("a certificate was provided without a private key or signer")
+	}
+	return newCertSigner(cfg.Certificate, signer)
+}
+
+// newCertSigner parses an OpenSSH certificate (authorized_keys format, as returned by
+// Vault's ssh/sign endpoint) and combines it with signer.
+func newCertSigner(certBytes []byte, signer ssh.Signer) (ssh.Signer, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH certificate: %w", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse SSH certificate: got a plain %s public key", pub.Type())
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("SSH certificate is not a user certificate")
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("SSH certificate does not match the private key: %w", err)
+	}
+	return certSigner, nil
+}
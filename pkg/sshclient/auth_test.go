@@ -0,0 +1,213 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// generateEd25519Signer returns a new in-memory ed25519 signer.
+func generateEd25519Signer(t *testing.T) gossh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ed25519 key: %v", err)
+	}
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return signer
+}
+
+// signUserCert signs pub as a user certificate for principals with the given CA.
+func signUserCert(t *testing.T, ca gossh.Signer, pub gossh.PublicKey, principals ...string) []byte {
+	t.Helper()
+	cert := &gossh.Certificate{
+		Key:             pub,
+		Serial:          1,
+		CertType:        gossh.UserCert,
+		KeyId:           "jet-access-test",
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(time.Now().Add(5 * time.Minute).Unix()),
+		Permissions:     gossh.Permissions{Extensions: map[string]string{"permit-pty": ""}},
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatalf("Failed to sign certificate: %v", err)
+	}
+	return gossh.MarshalAuthorizedKey(cert)
+}
+
+// certAuthOption returns a server option that accepts user certificates signed by ca.
+func certAuthOption(ca gossh.PublicKey) ssh.Option {
+	checker := &gossh.CertChecker{
+		IsUserAuthority: func(auth gossh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), ca.Marshal())
+		},
+	}
+	return ssh.PublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
+		_, err := checker.Authenticate(ctxConnMetadata{ctx}, key)
+		return err == nil
+	})
+}
+
+// ctxConnMetadata adapts an ssh.Context to gossh.ConnMetadata for CertChecker.
+type ctxConnMetadata struct{ ssh.Context }
+
+func (c ctxConnMetadata) User() string          { return c.Context.User() }
+func (c ctxConnMetadata) SessionID() []byte     { return []byte(c.Context.SessionID()) }
+func (c ctxConnMetadata) ClientVersion() []byte { return []byte(c.Context.ClientVersion()) }
+func (c ctxConnMetadata) ServerVersion() []byte { return []byte(c.Context.ServerVersion()) }
+
+func TestDial_CertificateAuth(t *testing.T) {
+	ca := generateEd25519Signer(t)
+	userKey := generateEd25519Signer(t)
+	otherKey := generateEd25519Signer(t)
+
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {}, certAuthOption(ca.PublicKey()))
+	defer stopServer()
+
+	tests := []struct {
+		name          string
+		cfg           SSHConfig
+		expectError   bool
+		errorContains string
+	}{
+		{
+			name: "Valid Certificate",
+			cfg: SSHConfig{
+				User:        "deploy",
+				Signer:      userKey,
+				Certificate: signUserCert(t, ca, userKey.PublicKey(), "deploy"),
+			},
+		},
+		{
+			name: "Wrong Principal",
+			cfg: SSHConfig{
+				User:        "root",
+				Signer:      userKey,
+				Certificate: signUserCert(t, ca, userKey.PublicKey(), "deploy"),
+			},
+			expectError:   true,
+			errorContains: "unable to authenticate",
+		},
+		{
+			name: "Plain Key Without Certificate",
+			cfg: SSHConfig{
+				User:   "deploy",
+				Signer: userKey,
+			},
+			expectError:   true,
+			errorContains: "unable to authenticate",
+		},
+		{
+			name: "Certificate For Another Key",
+			cfg: SSHConfig{
+				User:        "deploy",
+				Signer:      otherKey,
+				Certificate: signUserCert(t, ca, userKey.PublicKey(), "deploy"),
+			},
+			expectError:   true,
+			errorContains: "does not match the private key",
+		},
+		{
+			name: "Certificate Without Key",
+			cfg: SSHConfig{
+				User:        "deploy",
+				Certificate: signUserCert(t, ca, userKey.PublicKey(), "deploy"),
+			},
+			expectError:   true,
+			errorContains: "without a private key",
+		},
+		{
+			name: "Not A Certificate",
+			cfg: SSHConfig{
+				User:        "deploy",
+				Signer:      userKey,
+				Certificate: gossh.MarshalAuthorizedKey(userKey.PublicKey()),
+			},
+			expectError:   true,
+			errorContains: "plain",
+		},
+	}
+
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Address = addr
+			tt.cfg.KnownHostsFiles = []string{filepath.Join(t.TempDir(), "known_hosts")}
+			tt.cfg.HostKeyPolicy = HostKeyAcceptNew
+
+			client, err := dial(context.Background(), tt.cfg)
+			if tt.expectError {
+				if err == nil {
+					client.Close()
+					t.Fatalf("Expected an error, but got nil")
+				}
+				if !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			client.Close()
+		})
+	}
+}
+
+func TestDial_KeyboardInteractivePassword(t *testing.T) {
+	const otp = "2f7e25a2-9a2f-4c7a-9d8b-3f3a9c0a6d11"
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {},
+		ssh.KeyboardInteractiveAuth(func(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) bool {
+			answers, err := challenger("", "", []string{"Password: "}, []bool{false})
+			return err == nil && len(answers) == 1 && answers[0] == otp
+		}))
+	defer stopServer()
+
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	for _, tc := range []struct {
+		password    string
+		expectError bool
+	}{
+		{password: otp},
+		{password: "wrong", expectError: true},
+	} {
+		client, err := dial(context.Background(), SSHConfig{
+			Address:         addr,
+			User:            "otpuser",
+			Password:        tc.password,
+			KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+			HostKeyPolicy:   HostKeyAcceptNew,
+		})
+		if tc.expectError {
+			if err == nil {
+				client.Close()
+				t.Errorf("Expected password %q to be rejected", tc.password)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		client.Close()
+	}
+}
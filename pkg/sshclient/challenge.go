@@ -0,0 +1,148 @@
+// pkg/sshclient/challenge.go
+
+package sshclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ChallengeResponder answers keyboard-interactive prompts, such as a PAM password
+// followed by a TOTP code or a Duo-style push confirmation. It has the same shape as
+// ssh.KeyboardInteractiveChallenge: one answer per question, echos[i] tells whether
+// the answer to questions[i] may be displayed.
+type ChallengeResponder func(name, instruction string, questions []string, echos []bool) ([]string, error)
+
+// isPasswordPrompt reports whether a keyboard-interactive question asks for the account password.
+func isPasswordPrompt(question string, echo bool) bool {
+	return !echo && strings.Contains(strings.ToLower(question), "password")
+}
+
+// PasswordResponder answers "Password:" prompts with password. Any other prompt (e.g. a
+// "Verification code:" question) is passed on to fallback; without a fallback it fails.
+func PasswordResponder(password string, fallback ChallengeResponder) ChallengeResponder {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		var rest []int // Indexes of questions left for the fallback
+		for i, q := range questions {
+			if isPasswordPrompt(q, echos[i]) {
+				answers[i] = password
+				continue
+			}
+			rest = append(rest, i)
+		}
+
+		// Informational rounds (no questions) only need a fallback to show the instruction.
+		if len(rest) == 0 && (len(questions) > 0 || fallback == nil) {
+			return answers, nil
+		}
+		if fallback == nil {
+			return nil, fmt.Errorf("cannot answer keyboard-interactive prompt %q with a password", questions[rest[0]])
+		}
+
+		restQuestions := make([]string, len(rest))
+		restEchos := make([]bool, len(rest))
+		for j, i := range rest {
+			restQuestions[j] = questions[i]
+			restEchos[j] = echos[i]
+		}
+		restAnswers, err := fallback(name, instruction, restQuestions, restEchos)
+		if err != nil {
+			return nil, err
+		}
+		if len(restAnswers) != len(rest) {
+			return nil, fmt.Errorf("challenge responder returned %d answers for %d questions", len(restAnswers), len(rest))
+		}
+		for j, i := range rest {
+			answers[i] = restAnswers[j]
+		}
+		return answers, nil
+	}
+}
+
+// TerminalResponder asks every keyboard-interactive question on the local terminal,
+// hiding answers that must not be echoed. It fails when stdin is not a terminal.
+func TerminalResponder() ChallengeResponder {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return nil, errors.New("stdin is not a terminal, cannot answer keyboard-interactive prompts")
+		}
+		readSecret := func() (string, error) {
+			b, err := term.ReadPassword(fd)
+			return string(b), err
+		}
+		terminalPromptMu.Lock()
+		defer terminalPromptMu.Unlock()
+		return promptChallenge(os.Stdin, os.Stderr, readSecret, name, instruction, questions, echos)
+	}
+}
+
+// promptChallenge writes the challenge to w and reads the answers: visible answers from r,
+// hidden ones with readSecret.
+func promptChallenge(r io.Reader, w io.Writer, readSecret func() (string, error),
+	name, instruction string, questions []string, echos []bool) ([]string, error) {
+	if name != "" {
+		fmt.Fprintln(w, name)
+	}
+	if instruction != "" {
+		fmt.Fprintln(w, instruction)
+	}
+
+	answers := make([]string, len(questions))
+	for i, q := range questions {
+		fmt.Fprint(w, q)
+		var err error
+		if echos[i] {
+			answers[i], err = readLine(r)
+		} else {
+			answers[i], err = readSecret()
+			fmt.Fprintln(w) // The newline typed by the user was not echoed
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read answer to %q: %w", q, err)
+		}
+	}
+	return answers, nil
+}
+
+// readLine reads a single line from r without buffering past the newline, so the rest
+// of the input is left for the remote session.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				break
+			}
+			line = append(line, buf[0])
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(line) > 0 {
+				break
+			}
+			return "", err
+		}
+	}
+	return strings.TrimRight(string(line), "\r"), nil
+}
+
+// challengeResponder returns the responder to use for keyboard-interactive auth, or nil if
+// keyboard-interactive should not be offered: cfg.ChallengeResponder or, without one, a
+// configured password answering password prompts only.
+func (cfg SSHConfig) challengeResponder() ChallengeResponder {
+	if cfg.ChallengeResponder != nil {
+		return cfg.ChallengeResponder
+	}
+	if cfg.Password == "" {
+		return nil
+	}
+	return PasswordResponder(cfg.Password, nil)
+}
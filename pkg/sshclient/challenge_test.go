@@ -0,0 +1,234 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestPasswordResponder(t *testing.T) {
+	codeResponder := func(_, _ string, questions []string, _ []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range questions {
+			answers[i] = "123456"
+		}
+		return answers, nil
+	}
+
+	tests := []struct {
+		name          string
+		fallback      ChallengeResponder
+		questions     []string
+		echos         []bool
+		expectAnswers []string
+		expectError   bool
+	}{
+		{
+			name:          "Password Prompt",
+			questions:     []string{"Password: "},
+			echos:         []bool{false},
+			expectAnswers: []string{"secret"},
+		},
+		{
+			name:          "Info Round Without Fallback",
+			questions:     []string{},
+			echos:         []bool{},
+			expectAnswers: []string{},
+		},
+		{
+			name:        "Code Prompt Without Fallback",
+			questions:   []string{"Verification code: "},
+			echos:       []bool{false},
+			expectError: true,
+		},
+		{
+			name:          "Password And Code With Fallback",
+			fallback:      codeResponder,
+			questions:     []string{"Password: ", "Verification code: "},
+			echos:         []bool{false, false},
+			expectAnswers: []string{"secret", "123456"},
+		},
+		{
+			name: "Fallback Error",
+			fallback: func(string, string, []string, []bool) ([]string, error) {
+				return nil, errors.New("user aborted")
+			},
+			questions:   []string{"Duo passcode or option (1-3): "},
+			echos:       []bool{true},
+			expectError: true,
+		},
+		{
+			name: "Fallback Returns Wrong Number Of Answers",
+			fallback: func(string, string, []string, []bool) ([]string, error) {
+				return []string{}, nil
+			},
+			questions:   []string{"Token: "},
+			echos:       []bool{false},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answers, err := PasswordResponder("secret", tt.fallback)("", "", tt.questions, tt.echos)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected an error, but got answers %v", answers)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(answers, tt.expectAnswers) {
+				t.Errorf("Expected answers %v, got %v", tt.expectAnswers, answers)
+			}
+		})
+	}
+}
+
+func TestPromptChallenge(t *testing.T) {
+	var out strings.Builder
+	in := strings.NewReader("alice\r\nremaining input")
+	readSecret := func() (string, error) { return "654321", nil }
+
+	answers, err := promptChallenge(in, &out, readSecret, "Duo", "Enter your details",
+		[]string{"Username: ", "Code: "}, []bool{true, false})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := []string{"alice", "654321"}; !reflect.DeepEqual(answers, want) {
+		t.Errorf("Expected answers %v, got %v", want, answers)
+	}
+	for _, s := range []string{"Duo", "Enter your details", "Username: ", "Code: "} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("Expected output to contain %q, got %q", s, out.String())
+		}
+	}
+	// Only the first line may be consumed.
+	if rest, _ := io.ReadAll(in); string(rest) != "remaining input" {
+		t.Errorf("Expected remaining input to be untouched, got %q", rest)
+	}
+}
+
+// TestDial_KeyboardInteractiveMFA runs a PAM-like password + TOTP exchange.
+func TestDial_KeyboardInteractiveMFA(t *testing.T) {
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {},
+		ssh.KeyboardInteractiveAuth(func(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) bool {
+			answers, err := challenger("", "", []string{"Password: "}, []bool{false})
+			if err != nil || len(answers) != 1 || answers[0] != "secret" {
+				return false
+			}
+			answers, err = challenger("", "Two-factor authentication", []string{"Verification code: "}, []bool{false})
+			return err == nil && len(answers) == 1 && answers[0] == "123456"
+		}))
+	defer stopServer()
+
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	var asked []string
+	client, err := dial(context.Background(), SSHConfig{
+		Address:  addr,
+		User:     "mfauser",
+		Password: "secret",
+		ChallengeResponder: PasswordResponder("secret", func(_, instruction string, questions []string, _ []bool) ([]string, error) {
+			asked = append(asked, questions...)
+			return []string{"123456"}, nil
+		}),
+		KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+		HostKeyPolicy:   HostKeyAcceptNew,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	client.Close()
+
+	if !reflect.DeepEqual(asked, []string{"Verification code: "}) {
+		t.Errorf("Expected only the verification code to reach the callback, got %v", asked)
+	}
+}
+
+// TestDial_KeyThenChallenge logs in to a server requiring "publickey,keyboard-interactive"
+// (e.g. a key plus a TOTP or Duo prompt) with a key and no stored password.
+func TestDial_KeyThenChallenge(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	userKey := generateEd25519Signer(t)
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {},
+		// Any gliderlabs handler keeps the server from allowing logins without authentication
+		ssh.PasswordAuth(func(ctx ssh.Context, pass string) bool { return false }),
+		func(srv *ssh.Server) error {
+			srv.ServerConfigCallback = func(ctx ssh.Context) *gossh.ServerConfig {
+				return &gossh.ServerConfig{
+					PublicKeyCallback: func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+						if !ssh.KeysEqual(key, userKey.PublicKey()) {
+							return nil, errors.New("unknown key")
+						}
+						return nil, &gossh.PartialSuccessError{Next: gossh.ServerAuthCallbacks{
+							KeyboardInteractiveCallback: func(conn gossh.ConnMetadata, challenger gossh.KeyboardInteractiveChallenge) (*gossh.Permissions, error) {
+								answers, err := challenger("", "Duo two-factor login", []string{"Passcode: "}, []bool{false})
+								if err != nil || len(answers) != 1 || answers[0] != "123456" {
+									return nil, errors.New("wrong passcode")
+								}
+								return nil, nil
+							},
+						}}
+					},
+				}
+			}
+			return nil
+		})
+	defer stopServer()
+
+	cfg := SSHConfig{
+		Address:         addr,
+		User:            "keyuser",
+		Signer:          userKey,
+		KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+		HostKeyPolicy:   HostKeyAcceptNew,
+	}
+
+	// Without a responder or a Session to ask on, the challenge cannot be answered
+	if _, err := dial(context.Background(), cfg); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Expected ErrAuthFailed without a way to answer the challenge, got: %v", err)
+	}
+
+	// A Session asks on its streams
+	var out bytes.Buffer
+	session := &Session{Stdin: strings.NewReader("123456\n"), Stderr: &out}
+	client, err := dial(context.Background(), session.withPrompts(cfg))
+	if err != nil {
+		t.Fatalf("Unexpected error answering on the Session's streams: %v", err)
+	}
+	client.Close()
+	if out.String() != "Duo two-factor login\nPasscode: \n" {
+		t.Errorf("Expected the challenge on the Session's stderr, got %q", out.String())
+	}
+
+	var asked []string
+	cfg.ChallengeResponder = func(_, _ string, questions []string, _ []bool) ([]string, error) {
+		asked = append(asked, questions...)
+		return []string{"123456"}, nil
+	}
+	client, err = dial(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	client.Close()
+	if !reflect.DeepEqual(asked, []string{"Passcode: "}) {
+		t.Errorf("Expected the passcode to be asked for after the key, got %v", asked)
+	}
+}
@@ -0,0 +1,356 @@
+// pkg/sshclient/control.go
+
+package sshclient
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultControlPath returns the control socket path for user@address: a private
+// directory under $XDG_RUNTIME_DIR, or the temporary directory, with a file named after
+// a hash of the PoolKey so that long host names fit the socket path limit.
+func DefaultControlPath(user, address string) string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir != "" {
+		dir = filepath.Join(dir, "jet-access")
+	} else {
+		dir = filepath.Join(os.TempDir(), "jet-access-"+strconv.Itoa(os.Getuid()))
+	}
+	sum := sha256.Sum256([]byte(PoolKey(user, address)))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".sock")
+}
+
+// errUnsafeControlPath reports a control socket, or its directory, that another user could
+// have created or could connect to. Such a socket is never used.
+var errUnsafeControlPath = errors.New("unsafe control socket")
+
+// ControlOptions configures StartControlMaster.
+type ControlOptions struct {
+	IdleTimeout time.Duration // Optional: stop once no process has used the master for this long (default: never)
+}
+
+// ControlMaster shares an SSH connection with other processes through a unix socket, like
+// OpenSSH's ControlMaster. Connecting with the same SSHConfig.ControlPath reuses the
+// master's connection instead of dialing and authenticating again.
+//
+// The socket speaks SSH without authentication; only the owner of the socket (mode 0600,
+// in a directory of mode 0700) can connect, and clients refuse a socket or directory the
+// current user does not own. Channels (sessions, SFTP, local and dynamic forwards) are
+// relayed to the server; remote forwards are refused, and agent forwarding uses the
+// master's settings.
+type ControlMaster struct {
+	client   *ssh.Client
+	listener net.Listener
+	path     string
+	config   *ssh.ServerConfig
+	idleTime time.Duration
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{} // Connected processes
+	idle   *time.Timer
+	closed bool
+	done   chan struct{} // Closed once the master has stopped
+	err    error         // Why the master stopped, if not closed or idle
+}
+
+// StartControlMaster connects as described by cfg and shares the connection on the
+// control socket cfg.ControlPath, replacing a stale socket file. It fails if another
+// master is already serving the socket.
+func StartControlMaster(ctx context.Context, cfg SSHConfig, opts ControlOptions) (*ControlMaster, error) {
+	path := cfg.ControlPath
+	if path == "" {
+		return nil, errors.New("control master requested without a control path")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create control socket directory: %w", err)
+	}
+	if err := checkControlDir(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("refusing control socket directory: %w", err)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a control master is already running at %s", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale control socket: %w", err)
+	}
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate control socket host key: %w", err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate control socket host key: %w", err)
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	cfg.ControlPath = ""
+	client, err := dial(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := listenPrivate(path)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+
+	m := &ControlMaster{
+		client:   client,
+		listener: listener,
+		path:     path,
+		config:   serverConfig,
+		idleTime: opts.IdleTimeout,
+		conns:    make(map[net.Conn]struct{}),
+		done:     make(chan struct{}),
+	}
+	m.mu.Lock()
+	m.armIdle()
+	m.mu.Unlock()
+	go m.serve()
+	go func() {
+		_ = client.Wait()
+		if err := connectionLost(client); err != nil {
+			m.stop(err)
+		} else {
+			m.stop(errors.New("SSH connection closed"))
+		}
+	}()
+	return m, nil
+}
+
+// Path returns the control socket path.
+func (m *ControlMaster) Path() string {
+	return m.path
+}
+
+// Wait blocks until the master stops: nil after Close or the idle timeout, or the reason
+// the SSH connection ended.
+func (m *ControlMaster) Wait() error {
+	<-m.done
+	return m.err
+}
+
+// Close stops sharing and closes the SSH connection, disconnecting the processes using it.
+func (m *ControlMaster) Close() error {
+	m.stop(nil)
+	return nil
+}
+
+// stop shuts the master down once, recording err as the reason.
+func (m *ControlMaster) stop(err error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	m.err = err
+	if m.idle != nil {
+		m.idle.Stop()
+	}
+	for conn := range m.conns {
+		conn.Close()
+	}
+	m.mu.Unlock()
+
+	m.listener.Close() // Also removes the socket file
+	m.client.Close()
+	close(m.done)
+}
+
+// armIdle starts the idle timer if one is configured. m.mu must be held.
+func (m *ControlMaster) armIdle() {
+	if m.idleTime > 0 {
+		m.idle = time.AfterFunc(m.idleTime, func() {
+			log.Printf("Control master %s idle for %s, stopping", m.path, m.idleTime)
+			m.stop(nil)
+		})
+	}
+}
+
+// serve accepts processes until the listener is closed.
+func (m *ControlMaster) serve() {
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			return
+		}
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			conn.Close()
+			return
+		}
+		m.conns[conn] = struct{}{}
+		if m.idle != nil {
+			m.idle.Stop()
+			m.idle = nil
+		}
+		m.mu.Unlock()
+		go m.serveConn(conn)
+	}
+}
+
+// serveConn relays the channels a process opens on conn to the SSH server.
+func (m *ControlMaster) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.conns, conn)
+		if len(m.conns) == 0 && !m.closed {
+			m.armIdle()
+		}
+	}()
+
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, m.config)
+	if err != nil {
+		log.Printf("Warning: Control socket handshake failed: %v", err)
+		return
+	}
+	defer sconn.Close()
+	go func() {
+		for req := range reqs {
+			// Answer keepalives; forwarding requests cannot be relayed
+			if req.WantReply {
+				req.Reply(req.Type == keepaliveRequest, nil)
+			}
+		}
+	}()
+	for newChannel := range chans {
+		go m.relayChannel(newChannel)
+	}
+}
+
+// relayChannel opens the same channel on the SSH server and relays it.
+func (m *ControlMaster) relayChannel(newChannel ssh.NewChannel) {
+	upstream, upstreamReqs, err := m.client.OpenChannel(newChannel.ChannelType(), newChannel.ExtraData())
+	if err != nil {
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) {
+			newChannel.Reject(openErr.Reason, openErr.Message)
+		} else {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+	downstream, downstreamReqs, err := newChannel.Accept()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+
+	// A channel is only closed once the replies to its own requests have been sent on it:
+	// the server may answer "exec" and close its channel at once, and closing the process's
+	// channel before the relayed reply would fail the request.
+	var upstreamReplies, downstreamReplies sync.RWMutex
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		relayHalf(upstream, downstream, downstreamReqs, &upstreamReplies, &downstreamReplies)
+	}()
+	go func() {
+		defer wg.Done()
+		relayHalf(downstream, upstream, upstreamReqs, &downstreamReplies, &upstreamReplies)
+	}()
+	wg.Wait()
+}
+
+// relayHalf copies data, extended data (stderr) and requests from src to dst until src
+// is closed by its peer, then closes dst. Replying to a request of src holds srcReplies for
+// reading; dst is closed holding dstReplies, so that no reply to dst's requests is lost.
+func relayHalf(dst, src ssh.Channel, srcReqs <-chan *ssh.Request, dstReplies, srcReplies *sync.RWMutex) {
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = io.Copy(dst.Stderr(), src.Stderr())
+		}()
+		_, _ = io.Copy(dst, src)
+		wg.Wait()
+		// EOF covers both streams, so it is only sent once both are done
+		_ = dst.CloseWrite()
+	}()
+	for req := range srcReqs {
+		srcReplies.RLock()
+		ok, err := dst.SendRequest(req.Type, req.WantReply, req.Payload)
+		if req.WantReply {
+			req.Reply(ok && err == nil, nil)
+		}
+		srcReplies.RUnlock()
+	}
+	<-copied
+	dstReplies.Lock()
+	dst.Close()
+	dstReplies.Unlock()
+}
+
+// dialControl connects to the control master at cfg.ControlPath. The socket and its
+// directory must belong to the current user and be private, as the master is trusted
+// without authentication.
+func dialControl(ctx context.Context, cfg SSHConfig) (*ssh.Client, error) {
+	if err := checkControlDir(filepath.Dir(cfg.ControlPath)); err != nil {
+		return nil, err
+	}
+	if err := checkControlSocket(cfg.ControlPath); err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", cfg.ControlPath)
+	if err != nil {
+		return nil, err
+	}
+	config := &ssh.ClientConfig{
+		User: cfg.User,
+		// The master is trusted through the socket's file permissions, as with OpenSSH
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(), //nolint:gosec // Local socket, see above
+	}
+	c, chans, reqs, err := handshake(ctx, conn, cfg.ControlPath, config, cfg.HandshakeTimeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// dialShared reuses the connection of a control master at cfg.ControlPath if one is
+// running, and dials otherwise.
+func dialShared(ctx context.Context, cfg SSHConfig) (*ssh.Client, error) {
+	if cfg.ControlPath != "" {
+		client, err := dialControl(ctx, cfg)
+		if err == nil {
+			log.Printf("Using the shared connection to %s@%s.", cfg.User, cfg.Address)
+			return client, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, errUnsafeControlPath) {
+			log.Printf("Warning: Not using the shared connection: %v", err)
+		}
+	}
+	return dial(ctx, cfg)
+}
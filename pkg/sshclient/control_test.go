@@ -0,0 +1,278 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+func TestControlMaster(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	var logins atomic.Int32
+	addr, stopServer := startMockSSHServer(t, commandHandler, countingPassword("runner", "pw", &logins), bastionOption())
+	defer stopServer()
+
+	cfg := runTestConfig(t, addr)
+	cfg.ControlPath = filepath.Join(t.TempDir(), "control", "master.sock")
+	master, err := StartControlMaster(context.Background(), cfg, ControlOptions{})
+	if err != nil {
+		t.Fatalf("StartControlMaster() unexpected error: %v", err)
+	}
+	defer master.Close()
+
+	info, err := os.Stat(master.Path())
+	if err != nil {
+		t.Fatalf("Control socket missing: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Expected control socket mode 0600, got %o", perm)
+	}
+	if _, err := StartControlMaster(context.Background(), cfg, ControlOptions{}); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Errorf("Expected a second master to fail, got: %v", err)
+	}
+
+	// Processes using the socket need no credentials of their own
+	shared := cfg
+	shared.Password = ""
+	t.Run("Commands", func(t *testing.T) {
+		tests := []struct {
+			cmd          string
+			stdin        string
+			expectStdout string
+			expectStderr string
+			expectCode   int
+		}{
+			{cmd: "echo via master", expectStdout: "via master\n"},
+			{cmd: "fail", expectStdout: "partial output\n", expectStderr: "something broke\n", expectCode: 3},
+			{cmd: "cat", stdin: "relayed input", expectStdout: "relayed input"},
+		}
+		// Short commands end right after the server answers "exec", so repeat them to catch
+		// the reply being lost when the relayed channel is closed
+		for i := 0; i < 10*len(tests); i++ {
+			tt := tests[i%len(tests)]
+			session := &Session{}
+			if tt.stdin != "" {
+				session.Stdin = strings.NewReader(tt.stdin)
+			}
+			result, err := session.Run(context.Background(), shared, tt.cmd)
+			if err != nil {
+				t.Fatalf("Run(%q) unexpected error: %v", tt.cmd, err)
+			}
+			if string(result.Stdout) != tt.expectStdout || string(result.Stderr) != tt.expectStderr || result.ExitCode != tt.expectCode {
+				t.Errorf("Run(%q): expected %q, %q, exit %d; got %q, %q, exit %d", tt.cmd,
+					tt.expectStdout, tt.expectStderr, tt.expectCode, result.Stdout, result.Stderr, result.ExitCode)
+			}
+		}
+	})
+
+	t.Run("Local Forward", func(t *testing.T) {
+		f, err := ConnectForwarder(shared)
+		if err != nil {
+			t.Fatalf("ConnectForwarder() unexpected error: %v", err)
+		}
+		defer f.Close()
+		tunnel, err := f.ForwardLocal(Forward{LocalAddr: "127.0.0.1:0", RemoteAddr: startEchoServer(t, "db:")})
+		if err != nil {
+			t.Fatalf("ForwardLocal() unexpected error: %v", err)
+		}
+		if got, err := tryRoundTrip(tunnel.Addr().String(), "ping"); err != nil || got != "db:ping" {
+			t.Errorf("Expected %q, got %q (%v)", "db:ping", got, err)
+		}
+	})
+
+	if got := logins.Load(); got != 1 {
+		t.Errorf("Expected only the master to log in, got %d logins", got)
+	}
+
+	master.Close()
+	if err := master.Wait(); err != nil {
+		t.Errorf("Expected Wait() to return nil after Close, got: %v", err)
+	}
+	if _, err := os.Stat(master.Path()); !os.IsNotExist(err) {
+		t.Errorf("Expected the control socket to be removed, got: %v", err)
+	}
+
+	// Without a master, connections are dialed directly
+	var stdout bytes.Buffer
+	if _, err := (&Session{Stdout: &stdout}).Run(context.Background(), cfg, "echo direct"); err != nil || stdout.String() != "direct\n" {
+		t.Errorf("Expected a direct connection to work, got %q (%v)", stdout.String(), err)
+	}
+}
+
+func TestControlMaster_IdleTimeout(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {
+		time.Sleep(200 * time.Millisecond)
+		s.Exit(0)
+	}, passwordFor("runner", "pw"))
+	defer stopServer()
+
+	cfg := runTestConfig(t, addr)
+	cfg.ControlPath = filepath.Join(t.TempDir(), "control", "master.sock")
+	master, err := StartControlMaster(context.Background(), cfg, ControlOptions{IdleTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("StartControlMaster() unexpected error: %v", err)
+	}
+	defer master.Close()
+
+	// A process using the master for longer than the idle timeout keeps it running
+	start := time.Now()
+	if _, err := Run(context.Background(), cfg, "sleep"); err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
+	}
+	select {
+	case <-master.done:
+		t.Fatal("Master stopped while in use")
+	default:
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- master.Wait() }()
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Errorf("Expected Wait() to return nil after the idle timeout, got: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+			t.Errorf("Master stopped after %s, before being idle for the timeout", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Idle master did not stop")
+	}
+}
+
+func TestControlMaster_UnsafePath(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	var logins atomic.Int32
+	addr, stopServer := startMockSSHServer(t, commandHandler, countingPassword("runner", "pw", &logins))
+	defer stopServer()
+
+	// plantSocket serves a socket like another user's fake master would, counting connections
+	plantSocket := func(t *testing.T, path string) *atomic.Int32 {
+		t.Helper()
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatalf("Failed to listen on %s: %v", path, err)
+		}
+		t.Cleanup(func() { listener.Close() })
+		var accepted atomic.Int32
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				accepted.Add(1)
+				conn.Close()
+			}
+		}()
+		return &accepted
+	}
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T) string // Returns the control path
+	}{
+		{
+			name: "Directory Accessible To Others",
+			setup: func(t *testing.T) string {
+				dir := filepath.Join(t.TempDir(), "shared")
+				if err := os.Mkdir(dir, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chmod(dir, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				return filepath.Join(dir, "master.sock")
+			},
+		},
+		{
+			name: "Directory Is A Symlink",
+			setup: func(t *testing.T) string {
+				base := t.TempDir()
+				if err := os.Symlink(t.TempDir(), filepath.Join(base, "link")); err != nil {
+					t.Fatal(err)
+				}
+				return filepath.Join(base, "link", "master.sock")
+			},
+		},
+		{
+			name: "Directory Owned By Another User",
+			setup: func(t *testing.T) string {
+				if os.Getuid() != 0 {
+					t.Skip("Changing the owner requires root")
+				}
+				dir := filepath.Join(t.TempDir(), "other")
+				if err := os.Mkdir(dir, 0o700); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chown(dir, 12345, 12345); err != nil {
+					t.Fatal(err)
+				}
+				return filepath.Join(dir, "master.sock")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := runTestConfig(t, addr)
+			cfg.ControlPath = tt.setup(t)
+
+			if _, err := StartControlMaster(context.Background(), cfg, ControlOptions{}); err == nil || !strings.Contains(err.Error(), "refusing control socket directory") {
+				t.Errorf("Expected StartControlMaster to refuse the directory, got: %v", err)
+			}
+
+			// A socket planted there is never connected to; the host is dialed instead
+			accepted := plantSocket(t, cfg.ControlPath)
+			before := logins.Load()
+			if _, err := Run(context.Background(), cfg, "echo direct"); err != nil {
+				t.Fatalf("Run() unexpected error: %v", err)
+			}
+			if accepted.Load() != 0 {
+				t.Error("Expected the planted socket not to be used")
+			}
+			if logins.Load() != before+1 {
+				t.Error("Expected a direct login")
+			}
+		})
+	}
+
+	t.Run("Socket Accessible To Others", func(t *testing.T) {
+		cfg := runTestConfig(t, addr)
+		dir := filepath.Join(t.TempDir(), "control")
+		if err := os.Mkdir(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+		cfg.ControlPath = filepath.Join(dir, "master.sock")
+		accepted := plantSocket(t, cfg.ControlPath)
+		if err := os.Chmod(cfg.ControlPath, 0o666); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Run(context.Background(), cfg, "echo direct"); err != nil {
+			t.Fatalf("Run() unexpected error: %v", err)
+		}
+		if accepted.Load() != 0 {
+			t.Error("Expected the socket accessible to others not to be used")
+		}
+	})
+}
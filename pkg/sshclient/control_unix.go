@@ -0,0 +1,77 @@
+//go:build !windows
+
+// pkg/sshclient/control_unix.go
+
+package sshclient
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// umaskMu serializes the umask changes of listenPrivate, as the umask is process-wide.
+var umaskMu sync.Mutex
+
+// listenPrivate listens on the unix socket path, which is created with mode 0600 so that
+// other users can never connect to it, not even before a chmod.
+func listenPrivate(path string) (net.Listener, error) {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+	old := syscall.Umask(0o177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
+
+// checkControlDir refuses a control socket directory that is a symlink, is not owned by
+// the current user or is not mode 0700: in a shared temporary directory, another user
+// could have created it to plant a socket of their own.
+func checkControlDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%w: %s is not a directory", errUnsafeControlPath, dir)
+	}
+	if err := checkOwner(dir, info); err != nil {
+		return err
+	}
+	if perm := info.Mode().Perm(); perm != 0o700 {
+		return fmt.Errorf("%w: directory %s has mode %04o, not 0700", errUnsafeControlPath, dir, perm)
+	}
+	return nil
+}
+
+// checkControlSocket refuses a control socket that is not a socket owned by the current
+// user and inaccessible to others.
+func checkControlSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%w: %s is not a socket", errUnsafeControlPath, path)
+	}
+	if err := checkOwner(path, info); err != nil {
+		return err
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		return fmt.Errorf("%w: %s has mode %04o, accessible to other users", errUnsafeControlPath, path, perm)
+	}
+	return nil
+}
+
+// checkOwner refuses a file not owned by the current user.
+func checkOwner(path string, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("%w: cannot determine the owner of %s", errUnsafeControlPath, path)
+	}
+	if uid := os.Getuid(); int(stat.Uid) != uid {
+		return fmt.Errorf("%w: %s is owned by uid %d, not %d", errUnsafeControlPath, path, stat.Uid, uid)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build windows
+
+// pkg/sshclient/control_windows.go
+
+package sshclient
+
+import (
+	"net"
+	"os"
+)
+
+// listenPrivate listens on the unix socket path. Windows has no umask; the socket is
+// protected by the ACL of the user's temporary directory.
+func listenPrivate(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
+
+// checkControlDir only checks that dir exists: Windows file modes do not reflect its ACL,
+// and the default directory is in the user's own profile.
+func checkControlDir(dir string) error {
+	_, err := os.Lstat(dir)
+	return err
+}
+
+// checkControlSocket only checks that the socket exists, see checkControlDir.
+func checkControlSocket(path string) error {
+	_, err := os.Lstat(path)
+	return err
+}
@@ -0,0 +1,183 @@
+// pkg/sshclient/fanout.go
+
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultFanOutConcurrency is how many hosts RunAll runs at once by default.
+	defaultFanOutConcurrency = 10
+	// maxPrefixedLine bounds how much of an unterminated output line is buffered.
+	maxPrefixedLine = 64 * 1024
+)
+
+// Target is a host for RunAll.
+type Target struct {
+	Name string // Shown in output prefixes and results, e.g. "dev/web-1"
+
+	// Config returns the connection settings. It is called when the host's turn comes, so
+	// short-lived credentials (e.g. signed by Vault) are fresh; an error marks the host
+	// unreachable.
+	Config func(ctx context.Context) (SSHConfig, error)
+}
+
+// HostStatus classifies the outcome of a command on one host.
+type HostStatus string
+
+const (
+	HostSucceeded   HostStatus = "succeeded"   // Exited with status 0
+	HostFailed      HostStatus = "failed"      // Non-zero exit, killed by a signal, or timed out
+	HostUnreachable HostStatus = "unreachable" // No configuration, connection or authentication
+)
+
+// HostResult is the outcome of RunAll on one host.
+type HostResult struct {
+	Host     string     `json:"host"`
+	Status   HostStatus `json:"status"`
+	ExitCode int        `json:"exit_code"`
+	Signal   string     `json:"signal,omitempty"`
+	Stdout   string     `json:"stdout,omitempty"` // Empty when streamed to FanOutOptions.Stdout
+	Stderr   string     `json:"stderr,omitempty"` // Empty when streamed to FanOutOptions.Stderr
+	Error    string     `json:"error,omitempty"`
+}
+
+// FanOutOptions configures RunAll.
+type FanOutOptions struct {
+	Concurrency int           // Optional: hosts to run at once (default: 10)
+	Timeout     time.Duration // Optional: per-host limit covering connect and command (default: none)
+
+	// Optional: stream output here as it arrives, each line prefixed with "<host>: ".
+	// Streams without a writer are captured in the results instead.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// RunAll runs cmd on every target, at most opts.Concurrency at a time, and returns the
+// results in the order of targets. Failures are reported per host, never as an error.
+func RunAll(ctx context.Context, targets []Target, cmd string, opts FanOutOptions) []HostResult {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultFanOutConcurrency
+	}
+
+	results := make([]HostResult, len(targets))
+	var outputMu sync.Mutex // Keeps lines from different hosts whole
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = runOnHost(ctx, target, cmd, opts, &outputMu)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// runOnHost connects to one target and runs cmd.
+func runOnHost(ctx context.Context, target Target, cmd string, opts FanOutOptions, outputMu *sync.Mutex) HostResult {
+	result := HostResult{Host: target.Name}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	describe := func(err error) string {
+		if errors.Is(err, context.DeadlineExceeded) && opts.Timeout > 0 {
+			return fmt.Sprintf("timed out after %s", opts.Timeout)
+		}
+		return err.Error()
+	}
+
+	cfg, err := target.Config(ctx)
+	if err != nil {
+		result.Status, result.Error = HostUnreachable, describe(err)
+		return result
+	}
+	session := &Session{}
+	if opts.Stdout != nil {
+		stdout := &linePrefixWriter{w: opts.Stdout, mu: outputMu, prefix: target.Name + ": "}
+		defer stdout.Flush()
+		session.Stdout = stdout
+	}
+	if opts.Stderr != nil {
+		stderr := &linePrefixWriter{w: opts.Stderr, mu: outputMu, prefix: target.Name + ": "}
+		defer stderr.Flush()
+		session.Stderr = stderr
+	}
+
+	client, release, err := acquire(ctx, cfg)
+	if err != nil {
+		result.Status, result.Error = HostUnreachable, describe(err)
+		return result
+	}
+	defer release()
+
+	run, err := session.runCommand(ctx, client, cfg, cmd)
+	if err != nil {
+		result.Status, result.Error = HostFailed, describe(err)
+		return result
+	}
+	result.ExitCode, result.Signal = run.ExitCode, run.Signal
+	result.Stdout, result.Stderr = string(run.Stdout), string(run.Stderr)
+	result.Status = HostSucceeded
+	if run.ExitCode != 0 || run.Signal != "" {
+		result.Status = HostFailed
+	}
+	return result
+}
+
+// linePrefixWriter writes whole lines to w, each starting with prefix. Writers sharing
+// w share mu.
+type linePrefixWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix string
+	buf    []byte
+}
+
+func (p *linePrefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := p.emit(p.buf[:i+1]); err != nil {
+			return len(b), err
+		}
+		p.buf = p.buf[i+1:]
+	}
+	if len(p.buf) > maxPrefixedLine {
+		return len(b), p.Flush()
+	}
+	return len(b), nil
+}
+
+// Flush writes any unterminated final line.
+func (p *linePrefixWriter) Flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	line := append(p.buf, '\n')
+	p.buf = nil
+	return p.emit(line)
+}
+
+func (p *linePrefixWriter) emit(line []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.w.Write(append([]byte(p.prefix), line...))
+	return err
+}
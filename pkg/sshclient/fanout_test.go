@@ -0,0 +1,162 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// staticTarget returns a Target with a fixed configuration.
+func staticTarget(name string, cfg SSHConfig) Target {
+	return Target{Name: name, Config: func(ctx context.Context) (SSHConfig, error) { return cfg, nil }}
+}
+
+func TestRunAll(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	okAddr, stopOK := startMockSSHServer(t, func(s ssh.Session) {
+		fmt.Fprintf(s, "up 3 days (%s)\n", s.RawCommand())
+		s.Exit(0)
+	}, passwordFor("runner", "pw"))
+	defer stopOK()
+	failAddr, stopFail := startMockSSHServer(t, func(s ssh.Session) {
+		fmt.Fprintln(s.Stderr(), "disk full")
+		s.Exit(2)
+	}, passwordFor("runner", "pw"))
+	defer stopFail()
+	hangAddr, stopHang := startMockSSHServer(t, func(s ssh.Session) { <-s.Context().Done() }, passwordFor("runner", "pw"))
+	defer stopHang()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	closedAddr := l.Addr().String()
+	l.Close()
+
+	targets := []Target{
+		staticTarget("dev/web-1", runTestConfig(t, okAddr)),
+		staticTarget("dev/web-2", runTestConfig(t, failAddr)),
+		staticTarget("dev/web-3", runTestConfig(t, hangAddr)),
+		staticTarget("dev/db-1", runTestConfig(t, closedAddr)),
+		{Name: "dev/broken", Config: func(ctx context.Context) (SSHConfig, error) {
+			return SSHConfig{}, errors.New("host secret has no address")
+		}},
+	}
+	results := RunAll(context.Background(), targets, "uptime", FanOutOptions{Timeout: 500 * time.Millisecond})
+
+	tests := []struct {
+		host          string
+		expectStatus  HostStatus
+		expectCode    int
+		expectStdout  string
+		expectStderr  string
+		errorContains string
+	}{
+		{host: "dev/web-1", expectStatus: HostSucceeded, expectStdout: "up 3 days (uptime)\n"},
+		{host: "dev/web-2", expectStatus: HostFailed, expectCode: 2, expectStderr: "disk full\n"},
+		{host: "dev/web-3", expectStatus: HostFailed, errorContains: "timed out after 500ms"},
+		{host: "dev/db-1", expectStatus: HostUnreachable, errorContains: "connection refused"},
+		{host: "dev/broken", expectStatus: HostUnreachable, errorContains: "no address"},
+	}
+	if len(results) != len(tests) {
+		t.Fatalf("Expected %d results, got %d", len(tests), len(results))
+	}
+	for i, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got := results[i]
+			if got.Host != tt.host || got.Status != tt.expectStatus || got.ExitCode != tt.expectCode {
+				t.Errorf("Expected %s %s (exit %d), got %+v", tt.host, tt.expectStatus, tt.expectCode, got)
+			}
+			if got.Stdout != tt.expectStdout || got.Stderr != tt.expectStderr {
+				t.Errorf("Expected stdout %q and stderr %q, got %q and %q", tt.expectStdout, tt.expectStderr, got.Stdout, got.Stderr)
+			}
+			if tt.errorContains != "" && !strings.Contains(got.Error, tt.errorContains) {
+				t.Errorf("Expected error containing '%s', but got: %q", tt.errorContains, got.Error)
+			}
+		})
+	}
+}
+
+func TestRunAll_PrefixedOutput(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {
+		io.WriteString(s, "first line\nsecond ")
+		io.WriteString(s, "line\nno newline")
+		io.WriteString(s.Stderr(), "warning\n")
+		s.Exit(0)
+	}, passwordFor("runner", "pw"))
+	defer stopServer()
+
+	var stdout, stderr bytes.Buffer
+	targets := []Target{staticTarget("a", runTestConfig(t, addr)), staticTarget("b", runTestConfig(t, addr))}
+	results := RunAll(context.Background(), targets, "report", FanOutOptions{Stdout: &stdout, Stderr: &stderr})
+
+	for _, r := range results {
+		if r.Status != HostSucceeded || r.Stdout != "" || r.Stderr != "" {
+			t.Errorf("Expected success with streamed output only, got %+v", r)
+		}
+	}
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	sort.Strings(lines)
+	expected := []string{"a: first line", "a: no newline", "a: second line", "b: first line", "b: no newline", "b: second line"}
+	if strings.Join(lines, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected prefixed lines %q, got %q", expected, lines)
+	}
+	if !strings.Contains(stderr.String(), "a: warning\n") || !strings.Contains(stderr.String(), "b: warning\n") {
+		t.Errorf("Expected prefixed stderr, got %q", stderr.String())
+	}
+}
+
+func TestRunAll_Concurrency(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	var mu sync.Mutex
+	running, peak := 0, 0
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		s.Exit(0)
+	}, passwordFor("runner", "pw"))
+	defer stopServer()
+
+	var targets []Target
+	for i := range 6 {
+		targets = append(targets, staticTarget(fmt.Sprintf("host-%d", i), runTestConfig(t, addr)))
+	}
+	results := RunAll(context.Background(), targets, "true", FanOutOptions{Concurrency: 2})
+	for _, r := range results {
+		if r.Status != HostSucceeded {
+			t.Errorf("Expected %s to succeed, got %+v", r.Host, r)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if peak != 2 {
+		t.Errorf("Expected at most 2 (and at some point 2) concurrent commands, got peak %d", peak)
+	}
+}
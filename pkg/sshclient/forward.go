@@ -0,0 +1,481 @@
+// pkg/sshclient/forward.go
+
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Forward describes a port forward. By default it is a local forward, the equivalent
+// of ssh -L: connections accepted on LocalAddr are forwarded through the SSH server to
+// RemoteAddr. A Reverse forward is the equivalent of ssh -R: the SSH server listens on
+// RemoteAddr and connections to it are forwarded to LocalAddr. A Dynamic forward is the
+// equivalent of ssh -D: a SOCKS5 proxy on LocalAddr (see ForwardDynamic).
+type Forward struct {
+	LocalAddr  string // Local address: listen address (port 0 picks a free port), or target if Reverse
+	RemoteAddr string // Address on the server side: target, or listen address on the server if Reverse
+	Reverse    bool   // Optional: listen on the server instead of locally
+	Dynamic    bool   // Optional: SOCKS5 proxy; the destination is chosen per connection
+}
+
+func (f Forward) String() string {
+	if f.Dynamic {
+		return "socks5 " + f.LocalAddr
+	}
+	if f.Reverse {
+		return "remote " + f.RemoteAddr + " -> " + f.LocalAddr
+	}
+	return f.LocalAddr + " -> " + f.RemoteAddr
+}
+
+// ParseForward parses an ssh -L style specification, [bind_address:]port:host:hostport.
+// Without a bind address the forward listens on localhost only. IPv6 addresses must be
+// enclosed in square brackets.
+func ParseForward(spec string) (Forward, error) {
+	parts, err := splitForwardSpec(spec)
+	if err != nil {
+		return Forward{}, fmt.Errorf("invalid forward %q: %w", spec, err)
+	}
+
+	var bind string
+	switch len(parts) {
+	case 3:
+		bind = "localhost"
+	case 4:
+		bind, parts = parts[0], parts[1:]
+	default:
+		return Forward{}, fmt.Errorf("invalid forward %q: expected [bind_address:]port:host:hostport", spec)
+	}
+	for _, port := range []string{parts[0], parts[2]} {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return Forward{}, fmt.Errorf("invalid forward %q: bad port %q", spec, port)
+		}
+	}
+	if parts[1] == "" {
+		return Forward{}, fmt.Errorf("invalid forward %q: missing host", spec)
+	}
+	return Forward{
+		LocalAddr:  net.JoinHostPort(bind, parts[0]),
+		RemoteAddr: net.JoinHostPort(parts[1], parts[2]),
+	}, nil
+}
+
+// ParseRemoteForward parses an ssh -R style specification, [bind_address:]port:host:hostport,
+// where bind_address and port are on the SSH server and host:hostport is reachable locally.
+// Without a bind address the server listens on its loopback interface only.
+func ParseRemoteForward(spec string) (Forward, error) {
+	fw, err := ParseForward(spec)
+	if err != nil {
+		return Forward{}, err
+	}
+	return Forward{LocalAddr: fw.RemoteAddr, RemoteAddr: fw.LocalAddr, Reverse: true}, nil
+}
+
+// splitForwardSpec splits spec on colons, keeping bracketed IPv6 addresses together.
+func splitForwardSpec(spec string) ([]string, error) {
+	var parts []string
+	for spec != "" {
+		var part string
+		if strings.HasPrefix(spec, "[") {
+			end := strings.Index(spec, "]")
+			if end < 0 {
+				return nil, errors.New("missing ']'")
+			}
+			part, spec = spec[1:end], spec[end+1:]
+			if spec != "" && !strings.HasPrefix(spec, ":") {
+				return nil, errors.New("expected ':' after ']'")
+			}
+			spec = strings.TrimPrefix(spec, ":")
+		} else {
+			var found bool
+			part, spec, found = strings.Cut(spec, ":")
+			if found && spec == "" {
+				return nil, errors.New("trailing ':'")
+			}
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// TunnelStats is a snapshot of a tunnel's counters.
+type TunnelStats struct {
+	BytesSent     int64 // Bytes forwarded from local clients to the remote end
+	BytesReceived int64 // Bytes forwarded from the remote end back to local clients
+	Connections   int64 // Connections accepted so far
+	Active        int64 // Connections currently open
+}
+
+// Tunnel is a running port forward.
+type Tunnel struct {
+	Forward
+
+	listener net.Listener
+	// dial connects to the target for an accepted connection. It may talk to the
+	// accepted connection first, e.g. for a SOCKS handshake.
+	dial func(accepted net.Conn) (net.Conn, error)
+	// listen opens the listener of a Reverse forward on a new SSH connection.
+	listen func(client *ssh.Client) (net.Listener, error)
+
+	sent, received, connections, open atomic.Int64
+
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{} // Open connections (both ends), for forced shutdown
+	closing bool                  // Set once open connections are being force-closed
+	active  sync.WaitGroup        // Accepted connections not yet finished
+	done    chan struct{}         // Closed when the accept loop exits
+}
+
+// Addr returns the address the tunnel listens on (on the SSH server for a Reverse forward).
+func (t *Tunnel) Addr() net.Addr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.listener.Addr()
+}
+
+// Stats returns the tunnel's current counters.
+func (t *Tunnel) Stats() TunnelStats {
+	return TunnelStats{
+		BytesSent:     t.sent.Load(),
+		BytesReceived: t.received.Load(),
+		Connections:   t.connections.Load(),
+		Active:        t.open.Load(),
+	}
+}
+
+// serve accepts connections until the listener is closed.
+func (t *Tunnel) serve() {
+	defer close(t.done)
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			// Remote (SSH) listeners report io.EOF once closed.
+			if !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) {
+				log.Printf("Warning: Tunnel %s stopped accepting connections: %v", t.Forward, err)
+			}
+			return
+		}
+		t.connections.Add(1)
+		t.open.Add(1)
+		t.active.Add(1)
+		t.track(conn)
+		go t.handle(conn)
+	}
+}
+
+// handle connects an accepted connection to its target and copies data until both
+// directions are done.
+func (t *Tunnel) handle(conn net.Conn) {
+	defer t.active.Done()
+	defer t.open.Add(-1)
+	defer t.untrack(conn)
+	defer conn.Close()
+
+	target, err := t.dial(conn)
+	if err != nil {
+		log.Printf("Warning: Tunnel %s: %v", t.Forward, err)
+		return
+	}
+	defer target.Close()
+	if !t.track(target) {
+		return
+	}
+	defer t.untrack(target)
+
+	if t.Reverse {
+		t.pipe(target, conn)
+	} else {
+		t.pipe(conn, target)
+	}
+}
+
+// track registers an open connection for forced shutdown. It returns false (and closes
+// conn) if the tunnel is already force-closing its connections.
+func (t *Tunnel) track(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closing {
+		conn.Close()
+		return false
+	}
+	t.conns[conn] = struct{}{}
+	return true
+}
+
+func (t *Tunnel) untrack(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, conn)
+}
+
+// pipe copies data between the local and the remote end of a forwarded connection in
+// both directions until both are done.
+func (t *Tunnel) pipe(local, remote net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		copyHalf(remote, local, &t.sent)
+	}()
+	go func() {
+		defer wg.Done()
+		copyHalf(local, remote, &t.received)
+	}()
+	wg.Wait()
+}
+
+// copyHalf copies src to dst, counting bytes in n, then half-closes dst so the peer sees EOF.
+func copyHalf(dst, src net.Conn, n *atomic.Int64) {
+	_, _ = io.Copy(dst, countingReader{src, n})
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
+
+// countingReader adds the number of bytes read from Reader to n.
+type countingReader struct {
+	io.Reader
+	n *atomic.Int64
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	read, err := r.Reader.Read(p)
+	r.n.Add(int64(read))
+	return read, err
+}
+
+// closeListener stops accepting connections and waits for the accept loop to exit.
+func (t *Tunnel) closeListener() {
+	t.listener.Close()
+	<-t.done
+}
+
+// restart moves a Reverse forward to a new SSH connection after the old one was lost.
+func (t *Tunnel) restart(client *ssh.Client) {
+	t.closeListener()
+	listener, err := t.listen(client)
+	if err != nil {
+		log.Printf("Warning: Tunnel %s could not be restored: %v", t.Forward, err)
+		return
+	}
+	t.mu.Lock()
+	t.listener = listener
+	t.done = make(chan struct{})
+	t.mu.Unlock()
+	go t.serve()
+	log.Printf("Forwarding remote %s -> %s", listener.Addr(), t.LocalAddr)
+}
+
+// closeConns force-closes all open connections.
+func (t *Tunnel) closeConns() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closing = true
+	for conn := range t.conns {
+		conn.Close()
+	}
+}
+
+// PortForwarder runs port forwards over a single SSH connection.
+type PortForwarder struct {
+	mu      sync.Mutex
+	client  *ssh.Client // Replaced on reconnect
+	tunnels []*Tunnel
+	closed  bool
+
+	release func() error // Closes (or returns to its pool) client; nil when reconnecting
+
+	// Set by ConnectForwarderWithReconnect
+	cancel context.CancelFunc // Stops reconnecting
+	done   chan struct{}      // Closed when reconnecting stops
+	err    error              // Why reconnecting gave up, readable once done is closed
+}
+
+// ConnectForwarder establishes an SSH connection using cfg for port forwarding. Add
+// forwards with ForwardLocal, ForwardRemote or ForwardDynamic and stop them with Shutdown or Close.
+func ConnectForwarder(cfg SSHConfig) (*PortForwarder, error) {
+	client, release, err := acquire(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("SSH connection established.")
+	return &PortForwarder{client: client, release: release}, nil
+}
+
+// ForwardLocal starts listening on fw.LocalAddr and forwards every accepted connection
+// through the SSH server to fw.RemoteAddr.
+func (f *PortForwarder) ForwardLocal(fw Forward) (*Tunnel, error) {
+	fw.Reverse, fw.Dynamic = false, false
+	listener, err := net.Listen("tcp", fw.LocalAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", fw.LocalAddr, err)
+	}
+	return f.start(fw, listener, func(net.Conn) (net.Conn, error) {
+		conn, err := f.sshClient().Dial("tcp", fw.RemoteAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", fw.RemoteAddr, err)
+		}
+		return conn, nil
+	})
+}
+
+// ForwardRemote asks the SSH server to listen on fw.RemoteAddr (tcpip-forward) and
+// forwards every connection it accepts to fw.LocalAddr. It fails if the server refuses
+// the forward, e.g. because remote forwarding is disabled or the port is in use.
+func (f *PortForwarder) ForwardRemote(fw Forward) (*Tunnel, error) {
+	fw.Reverse, fw.Dynamic = true, false
+	listen := func(client *ssh.Client) (net.Listener, error) {
+		listener, err := client.Listen("tcp", fw.RemoteAddr)
+		if err != nil {
+			return nil, fmt.Errorf("SSH server refused remote forward on %s: %w", fw.RemoteAddr, err)
+		}
+		return listener, nil
+	}
+	listener, err := listen(f.sshClient())
+	if err != nil {
+		return nil, err
+	}
+	t, err := f.start(fw, listener, func(net.Conn) (net.Conn, error) {
+		conn, err := net.Dial("tcp", fw.LocalAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", fw.LocalAddr, err)
+		}
+		return conn, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.listen = listen
+	return t, nil
+}
+
+// start registers a tunnel for fw accepting on listener and connecting with dial.
+func (f *PortForwarder) start(fw Forward, listener net.Listener, dial func(net.Conn) (net.Conn, error)) (*Tunnel, error) {
+	t := &Tunnel{
+		Forward:  fw,
+		listener: listener,
+		dial:     dial,
+		conns:    make(map[net.Conn]struct{}),
+		done:     make(chan struct{}),
+	}
+
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		listener.Close()
+		return nil, errors.New("port forwarder is closed")
+	}
+	f.tunnels = append(f.tunnels, t)
+	f.mu.Unlock()
+
+	go t.serve()
+	switch {
+	case fw.Dynamic:
+		log.Printf("SOCKS5 proxy listening on %s", listener.Addr())
+	case fw.Reverse:
+		log.Printf("Forwarding remote %s -> %s", listener.Addr(), fw.LocalAddr)
+	default:
+		log.Printf("Forwarding %s -> %s", listener.Addr(), fw.RemoteAddr)
+	}
+	return t, nil
+}
+
+// Tunnels returns the forwards started so far.
+func (f *PortForwarder) Tunnels() []*Tunnel {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*Tunnel(nil), f.tunnels...)
+}
+
+// sshClient returns the current SSH connection.
+func (f *PortForwarder) sshClient() *ssh.Client {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.client
+}
+
+// Wait blocks until the SSH connection ends, or for a forwarder from
+// ConnectForwarderWithReconnect until it stops reconnecting.
+func (f *PortForwarder) Wait() error {
+	if f.done != nil {
+		<-f.done
+		return f.err
+	}
+	client := f.sshClient()
+	err := client.Wait()
+	if lostErr := connectionLost(client); lostErr != nil {
+		return lostErr
+	}
+	return err
+}
+
+// Shutdown gracefully stops the forwarder: it stops accepting connections, waits for
+// open connections to finish until ctx is done, then closes the SSH connection. Any
+// connections still open when ctx is done are closed and ctx.Err() is returned.
+func (f *PortForwarder) Shutdown(ctx context.Context) error {
+	tunnels := f.stop()
+
+	drained := make(chan struct{})
+	go func() {
+		for _, t := range tunnels {
+			t.active.Wait()
+		}
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		for _, t := range tunnels {
+			t.closeConns()
+		}
+		<-drained
+	}
+	if f.release != nil {
+		f.release()
+	} else {
+		f.sshClient().Close()
+	}
+	return err
+}
+
+// Close immediately stops all forwards, closing open connections and the SSH connection.
+func (f *PortForwarder) Close() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := f.Shutdown(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// stop marks the forwarder closed and closes all tunnel listeners.
+func (f *PortForwarder) stop() []*Tunnel {
+	f.mu.Lock()
+	f.closed = true
+	if f.cancel != nil {
+		f.cancel()
+	}
+	tunnels := append([]*Tunnel(nil), f.tunnels...)
+	f.mu.Unlock()
+
+	for _, t := range tunnels {
+		t.closeListener()
+	}
+	return tunnels
+}
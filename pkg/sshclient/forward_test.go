@@ -0,0 +1,338 @@
+package sshclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+func TestParseForward(t *testing.T) {
+	tests := []struct {
+		name          string
+		spec          string
+		expect        Forward
+		expectError   bool
+		errorContains string
+	}{
+		{name: "Port Host Port", spec: "5432:db.internal:5432", expect: Forward{LocalAddr: "localhost:5432", RemoteAddr: "db.internal:5432"}},
+		{name: "Bind Address", spec: "0.0.0.0:8080:dashboard:80", expect: Forward{LocalAddr: "0.0.0.0:8080", RemoteAddr: "dashboard:80"}},
+		{name: "Empty Bind Address", spec: ":8080:dashboard:80", expect: Forward{LocalAddr: ":8080", RemoteAddr: "dashboard:80"}},
+		{name: "IPv6", spec: "[::1]:8080:[fd00::5]:80", expect: Forward{LocalAddr: "[::1]:8080", RemoteAddr: "[fd00::5]:80"}},
+		{name: "Too Few Parts", spec: "8080:dashboard", expectError: true, errorContains: "expected [bind_address:]port:host:hostport"},
+		{name: "Bad Port", spec: "http:dashboard:80", expectError: true, errorContains: `bad port "http"`},
+		{name: "Port Out Of Range", spec: "8080:dashboard:70000", expectError: true, errorContains: `bad port "70000"`},
+		{name: "Missing Host", spec: "8080::80", expectError: true, errorContains: "missing host"},
+		{name: "Unclosed Bracket", spec: "8080:[fd00::5:80", expectError: true, errorContains: "missing ']'"},
+		{name: "Trailing Colon", spec: "8080:dashboard:80:", expectError: true, errorContains: "trailing ':'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fw, err := ParseForward(tt.spec)
+			if tt.expectError {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if fw != tt.expect {
+				t.Errorf("Expected %+v, got %+v", tt.expect, fw)
+			}
+		})
+	}
+}
+
+// startEchoServer starts a TCP server that echoes each line back prefixed with prefix.
+func startEchoServer(t *testing.T, prefix string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					if _, err := io.WriteString(conn, prefix+scanner.Text()+"\n"); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// startForwarder connects a PortForwarder to a mock bastion that allows direct-tcpip.
+func startForwarder(t *testing.T) *PortForwarder {
+	t.Helper()
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {}, passwordFor("tunnel", "pw"), bastionOption())
+	t.Cleanup(stopServer)
+
+	f, err := ConnectForwarder(SSHConfig{
+		Address:         addr,
+		User:            "tunnel",
+		Password:        "pw",
+		KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+		HostKeyPolicy:   HostKeyAcceptNew,
+	})
+	if err != nil {
+		t.Fatalf("ConnectForwarder() unexpected error: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// roundTrip writes line to conn and returns the reply line.
+func roundTrip(t *testing.T, conn net.Conn, r *bufio.Reader, line string) string {
+	t.Helper()
+	if _, err := io.WriteString(conn, line+"\n"); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	return strings.TrimSuffix(reply, "\n")
+}
+
+func TestPortForwarder_ForwardLocal(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	f := startForwarder(t)
+	db := startEchoServer(t, "db:")
+	web := startEchoServer(t, "web:")
+
+	dbTunnel, err := f.ForwardLocal(Forward{LocalAddr: "127.0.0.1:0", RemoteAddr: db})
+	if err != nil {
+		t.Fatalf("ForwardLocal() unexpected error: %v", err)
+	}
+	webTunnel, err := f.ForwardLocal(Forward{LocalAddr: "127.0.0.1:0", RemoteAddr: web})
+	if err != nil {
+		t.Fatalf("ForwardLocal() unexpected error: %v", err)
+	}
+	if got := len(f.Tunnels()); got != 2 {
+		t.Errorf("Expected 2 tunnels, got %d", got)
+	}
+
+	for _, tc := range []struct {
+		tunnel *Tunnel
+		prefix string
+	}{{dbTunnel, "db:"}, {webTunnel, "web:"}} {
+		conn, err := net.Dial("tcp", tc.tunnel.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect to tunnel: %v", err)
+		}
+		r := bufio.NewReader(conn)
+		if got := roundTrip(t, conn, r, "ping"); got != tc.prefix+"ping" {
+			t.Errorf("Expected %q, got %q", tc.prefix+"ping", got)
+		}
+		conn.Close()
+	}
+
+	// Wait for the closed connection to be accounted for.
+	deadline := time.Now().Add(5 * time.Second)
+	for dbTunnel.Stats().Active != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := TunnelStats{BytesSent: int64(len("ping\n")), BytesReceived: int64(len("db:ping\n")), Connections: 1}
+	if got := dbTunnel.Stats(); got != want {
+		t.Errorf("Expected stats %+v, got %+v", want, got)
+	}
+}
+
+func TestPortForwarder_UnreachableRemote(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	f := startForwarder(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	closedAddr := l.Addr().String()
+	l.Close()
+
+	tunnel, err := f.ForwardLocal(Forward{LocalAddr: "127.0.0.1:0", RemoteAddr: closedAddr})
+	if err != nil {
+		t.Fatalf("ForwardLocal() unexpected error: %v", err)
+	}
+	conn, err := net.Dial("tcp", tunnel.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to tunnel: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected the local connection to be closed, got: %v", err)
+	}
+	if got := tunnel.Stats(); got.Connections != 1 || got.Active != 0 {
+		t.Errorf("Expected 1 connection and none active, got %+v", got)
+	}
+}
+
+func TestPortForwarder_Shutdown(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	tests := []struct {
+		name        string
+		closeClient bool // Whether the client finishes its connection during shutdown
+		expectError error
+	}{
+		{name: "Drains Open Connections", closeClient: true},
+		{name: "Times Out", closeClient: false, expectError: context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := startForwarder(t)
+			tunnel, err := f.ForwardLocal(Forward{LocalAddr: "127.0.0.1:0", RemoteAddr: startEchoServer(t, "")})
+			if err != nil {
+				t.Fatalf("ForwardLocal() unexpected error: %v", err)
+			}
+			conn, err := net.Dial("tcp", tunnel.Addr().String())
+			if err != nil {
+				t.Fatalf("Failed to connect to tunnel: %v", err)
+			}
+			defer conn.Close()
+			roundTrip(t, conn, bufio.NewReader(conn), "hello")
+
+			if tt.closeClient {
+				time.AfterFunc(100*time.Millisecond, func() { conn.Close() })
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			err = f.Shutdown(ctx)
+			if !errors.Is(err, tt.expectError) {
+				t.Errorf("Expected error %v, got: %v", tt.expectError, err)
+			}
+
+			if got := tunnel.Stats().Active; got != 0 {
+				t.Errorf("Expected no active connections after shutdown, got %d", got)
+			}
+			if _, err := net.DialTimeout("tcp", tunnel.Addr().String(), time.Second); err == nil {
+				t.Errorf("Expected the listener to be closed after shutdown")
+			}
+			if _, err := f.ForwardLocal(Forward{LocalAddr: "127.0.0.1:0", RemoteAddr: "127.0.0.1:1"}); err == nil {
+				t.Errorf("Expected ForwardLocal to fail after shutdown")
+			}
+		})
+	}
+}
+
+func TestParseRemoteForward(t *testing.T) {
+	fw, err := ParseRemoteForward("8080:localhost:3000")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := Forward{LocalAddr: "localhost:3000", RemoteAddr: "localhost:8080", Reverse: true}
+	if fw != want {
+		t.Errorf("Expected %+v, got %+v", want, fw)
+	}
+	if _, err := ParseRemoteForward("8080"); err == nil {
+		t.Errorf("Expected an error for an incomplete forward")
+	}
+}
+
+// reverseForwardingOption enables tcpip-forward on a mock server, allowing binds that
+// allow approves.
+func reverseForwardingOption(allow func(host string, port uint32) bool) ssh.Option {
+	return func(srv *ssh.Server) error {
+		handler := &ssh.ForwardedTCPHandler{}
+		srv.ReversePortForwardingCallback = func(ctx ssh.Context, host string, port uint32) bool {
+			return allow(host, port)
+		}
+		srv.RequestHandlers = map[string]ssh.RequestHandler{
+			"tcpip-forward":        handler.HandleSSHRequest,
+			"cancel-tcpip-forward": handler.HandleSSHRequest,
+		}
+		return nil
+	}
+}
+
+func TestPortForwarder_ForwardRemote(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {}, passwordFor("tunnel", "pw"),
+		reverseForwardingOption(func(host string, port uint32) bool { return host == "127.0.0.1" }))
+	defer stopServer()
+
+	f, err := ConnectForwarder(SSHConfig{
+		Address:         addr,
+		User:            "tunnel",
+		Password:        "pw",
+		KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+		HostKeyPolicy:   HostKeyAcceptNew,
+	})
+	if err != nil {
+		t.Fatalf("ConnectForwarder() unexpected error: %v", err)
+	}
+	defer f.Close()
+
+	webhook := startEchoServer(t, "laptop:")
+
+	t.Run("Refused By Server", func(t *testing.T) {
+		_, err := f.ForwardRemote(Forward{RemoteAddr: "0.0.0.0:0", LocalAddr: webhook})
+		if err == nil || !strings.Contains(err.Error(), "refused remote forward on 0.0.0.0:0") {
+			t.Errorf("Expected a refused forward error, got: %v", err)
+		}
+	})
+
+	t.Run("Forwards To Local Service", func(t *testing.T) {
+		tunnel, err := f.ForwardRemote(Forward{RemoteAddr: "127.0.0.1:0", LocalAddr: webhook})
+		if err != nil {
+			t.Fatalf("ForwardRemote() unexpected error: %v", err)
+		}
+		if !tunnel.Reverse {
+			t.Errorf("Expected a reverse tunnel")
+		}
+
+		// The mock server listens on this machine, so connect to it like a remote caller would.
+		conn, err := net.Dial("tcp", tunnel.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect to remote listener %s: %v", tunnel.Addr(), err)
+		}
+		r := bufio.NewReader(conn)
+		if got := roundTrip(t, conn, r, "event"); got != "laptop:event" {
+			t.Errorf("Expected %q, got %q", "laptop:event", got)
+		}
+		conn.Close()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for tunnel.Stats().Active != 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		// Sent is what the local service sent back to the remote caller.
+		want := TunnelStats{BytesSent: int64(len("laptop:event\n")), BytesReceived: int64(len("event\n")), Connections: 1}
+		if got := tunnel.Stats(); got != want {
+			t.Errorf("Expected stats %+v, got %+v", want, got)
+		}
+	})
+}
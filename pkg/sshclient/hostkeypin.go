@@ -0,0 +1,172 @@
+// pkg/sshclient/hostkeypin.go
+
+package sshclient
+
+import (
+	"crypto/md5" //nolint:gosec // MD5 is only used to match legacy OpenSSH fingerprints
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HostKeyMismatchError is returned when a server presents a host key that does not
+// match the expected (pinned or known_hosts) keys. Connections fail closed on it.
+type HostKeyMismatchError struct {
+	Hostname string   // Host as dialed (host:port)
+	Expected []string // Fingerprints of the keys that would have been accepted
+	Received string   // Fingerprint of the key the server presented
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key mismatch for %s: expected %s, received %s",
+		e.Hostname, strings.Join(e.Expected, " or "), e.Received)
+}
+
+// hostKeyPin is the parsed form of SSHConfig.HostKeys and SSHConfig.HostKeyFingerprints.
+type hostKeyPin struct {
+	keys         []ssh.PublicKey
+	fingerprints []string
+}
+
+// pinned reports whether the configuration pins the host key.
+func (cfg SSHConfig) pinned() bool {
+	return len(cfg.HostKeys) > 0 || len(cfg.HostKeyFingerprints) > 0
+}
+
+// parseHostKeyPin parses the pinned host keys and fingerprints from cfg.
+func (cfg SSHConfig) parseHostKeyPin() (*hostKeyPin, error) {
+	pin := &hostKeyPin{}
+	for _, line := range cfg.HostKeys {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		key, err := parseHostKeyLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse pinned host key %q: %w", line, err)
+		}
+		pin.keys = append(pin.keys, key)
+	}
+	for _, fp := range cfg.HostKeyFingerprints {
+		fp = strings.TrimSpace(fp)
+		if fp == "" {
+			continue
+		}
+		normalized, err := normalizeFingerprint(fp)
+		if err != nil {
+			return nil, err
+		}
+		pin.fingerprints = append(pin.fingerprints, normalized)
+	}
+	if len(pin.keys) == 0 && len(pin.fingerprints) == 0 {
+		return nil, fmt.Errorf("host key pinning is configured but no usable keys or fingerprints were given")
+	}
+	return pin, nil
+}
+
+// parseHostKeyLine accepts a public key in authorized_keys format ("ssh-ed25519 AAAA... comment")
+// or a known_hosts line ("host ssh-ed25519 AAAA...").
+func parseHostKeyLine(line string) (ssh.PublicKey, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err == nil {
+		return key, nil
+	}
+	_, _, key, _, _, khErr := ssh.ParseKnownHosts([]byte(line))
+	if khErr == nil {
+		return key, nil
+	}
+	return nil, err
+}
+
+// normalizeFingerprint converts a fingerprint to the canonical "SHA256:..." or "MD5:aa:bb:..."
+// form. Bare colon-separated hex is treated as a legacy MD5 fingerprint.
+func normalizeFingerprint(fp string) (string, error) {
+	switch {
+	case strings.HasPrefix(fp, "SHA256:"):
+		// OpenSSH omits base64 padding; tolerate it if present.
+		return strings.TrimRight(fp, "="), nil
+	case strings.HasPrefix(strings.ToUpper(fp), "MD5:"):
+		return "MD5:" + strings.ToLower(fp[len("MD5:"):]), nil
+	case len(fp) == 47 && strings.Count(fp, ":") == 15:
+		return "MD5:" + strings.ToLower(fp), nil
+	default:
+		return "", fmt.Errorf("unsupported host key fingerprint %q (expected SHA256:... or MD5:...)", fp)
+	}
+}
+
+// fingerprintMD5 returns the legacy MD5 fingerprint of key in "MD5:aa:bb:..." form.
+func fingerprintMD5(key ssh.PublicKey) string {
+	sum := md5.Sum(key.Marshal()) //nolint:gosec // Legacy fingerprint format
+	hexParts := make([]string, len(sum))
+	for i, b := range sum {
+		hexParts[i] = hex.EncodeToString([]byte{b})
+	}
+	return "MD5:" + strings.Join(hexParts, ":")
+}
+
+// expected returns the fingerprints accepted by the pin, for error reporting.
+func (p *hostKeyPin) expected() []string {
+	expected := make([]string, 0, len(p.keys)+len(p.fingerprints))
+	for _, k := range p.keys {
+		expected = append(expected, ssh.FingerprintSHA256(k))
+	}
+	return append(expected, p.fingerprints...)
+}
+
+// matches reports whether key is one of the pinned keys or fingerprints.
+func (p *hostKeyPin) matches(key ssh.PublicKey) bool {
+	for _, k := range p.keys {
+		if keysEqual(k, key) {
+			return true
+		}
+	}
+	sha := ssh.FingerprintSHA256(key)
+	md := fingerprintMD5(key)
+	for _, fp := range p.fingerprints {
+		if fp == sha || fp == md {
+			return true
+		}
+	}
+	return false
+}
+
+// algorithms returns the host key algorithms for the pinned keys, or nil if only
+// fingerprints are pinned (the key type cannot be derived from a fingerprint).
+func (p *hostKeyPin) algorithms() []string {
+	if len(p.fingerprints) > 0 {
+		return nil
+	}
+	var algos []string
+	seen := map[string]bool{}
+	for _, k := range p.keys {
+		for _, algo := range algorithmsForKeyType(k.Type()) {
+			if !seen[algo] {
+				seen[algo] = true
+				algos = append(algos, algo)
+			}
+		}
+	}
+	return algos
+}
+
+// callback returns a HostKeyCallback that only accepts the pinned keys.
+func (p *hostKeyPin) callback() ssh.HostKeyCallback {
+	return func(hostname string, _ net.Addr, key ssh.PublicKey) error {
+		if p.matches(key) {
+			return nil
+		}
+		return &HostKeyMismatchError{
+			Hostname: hostname,
+			Expected: p.expected(),
+			Received: ssh.FingerprintSHA256(key),
+		}
+	}
+}
+
+// keysEqual compares two public keys by their wire encoding.
+func keysEqual(a, b ssh.PublicKey) bool {
+	return string(a.Marshal()) == string(b.Marshal())
+}
@@ -0,0 +1,139 @@
+package sshclient
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestHostKeyPinning(t *testing.T) {
+	hostSigner, err := generateSigner(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	otherSigner, err := generateSigner(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate other key: %v", err)
+	}
+	hostKey := hostSigner.PublicKey()
+	authorizedLine := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(hostKey)))
+	otherLine := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(otherSigner.PublicKey())))
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 22}
+
+	tests := []struct {
+		name           string
+		cfg            SSHConfig
+		expectError    bool
+		errorContains  string
+		expectMismatch bool
+	}{
+		{
+			name: "Pinned Key (authorized_keys Format)",
+			cfg:  SSHConfig{HostKeys: []string{authorizedLine + " host@example"}},
+		},
+		{
+			name: "Pinned Key (known_hosts Format)",
+			cfg:  SSHConfig{HostKeys: []string{"example.com " + authorizedLine}},
+		},
+		{
+			name: "Pinned SHA256 Fingerprint",
+			cfg:  SSHConfig{HostKeyFingerprints: []string{gossh.FingerprintSHA256(hostKey)}},
+		},
+		{
+			name: "Pinned MD5 Fingerprint (Legacy Hex)",
+			cfg:  SSHConfig{HostKeyFingerprints: []string{strings.TrimPrefix(fingerprintMD5(hostKey), "MD5:")}},
+		},
+		{
+			name: "One Of Several Pinned Keys",
+			cfg:  SSHConfig{HostKeys: []string{otherLine, authorizedLine}},
+		},
+		{
+			name:           "Mismatched Pinned Key",
+			cfg:            SSHConfig{HostKeys: []string{otherLine}},
+			expectError:    true,
+			expectMismatch: true,
+			errorContains:  gossh.FingerprintSHA256(otherSigner.PublicKey()),
+		},
+		{
+			name:           "Mismatched Pinned Fingerprint",
+			cfg:            SSHConfig{HostKeyFingerprints: []string{"SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}},
+			expectError:    true,
+			expectMismatch: true,
+			errorContains:  gossh.FingerprintSHA256(hostKey),
+		},
+		{
+			name:          "Unparseable Pinned Key",
+			cfg:           SSHConfig{HostKeys: []string{"not-a-key"}},
+			expectError:   true,
+			errorContains: "failed to parse pinned host key",
+		},
+		{
+			name:          "Unsupported Fingerprint Format",
+			cfg:           SSHConfig{HostKeyFingerprints: []string{"deadbeef"}},
+			expectError:   true,
+			errorContains: "unsupported host key fingerprint",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb, _, err := tt.cfg.hostKeyCallback()
+			if err == nil {
+				err = cb("example.com:22", remote, hostKey)
+			}
+
+			if !tt.expectError {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Expected an error, but got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errorContains) {
+				t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+			}
+			var mismatch *HostKeyMismatchError
+			if errors.As(err, &mismatch) != tt.expectMismatch {
+				t.Errorf("Expected HostKeyMismatchError=%v, got: %v", tt.expectMismatch, err)
+			}
+			if tt.expectMismatch && mismatch.Received != gossh.FingerprintSHA256(hostKey) {
+				t.Errorf("Expected received fingerprint %s, got %s", gossh.FingerprintSHA256(hostKey), mismatch.Received)
+			}
+		})
+	}
+}
+
+// TestConnectAndShell_PinnedHostKeyMismatch checks that a pinned connection fails closed.
+func TestConnectAndShell_PinnedHostKeyMismatch(t *testing.T) {
+	otherSigner, err := generateSigner(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {},
+		ssh.PasswordAuth(func(ssh.Context, string) bool { return true }))
+	defer stopServer()
+
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	err = ConnectAndShell(SSHConfig{
+		Address:  addr,
+		User:     "test",
+		Password: "secret",
+		HostKeys: []string{string(gossh.MarshalAuthorizedKey(otherSigner.PublicKey()))},
+	})
+	var mismatch *HostKeyMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Expected HostKeyMismatchError, got: %v", err)
+	}
+}
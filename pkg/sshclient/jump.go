@@ -0,0 +1,66 @@
+// pkg/sshclient/jump.go
+
+package sshclient
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// jumpHops flattens hosts into the ordered list of hops to dial. A jump host's own
+// JumpHosts are dialed before it, like a nested ProxyJump.
+func jumpHops(hosts []SSHConfig) []SSHConfig {
+	var hops []SSHConfig
+	for _, host := range hosts {
+		hops = append(hops, jumpHops(host.JumpHosts)...)
+		host.JumpHosts = nil
+		hops = append(hops, host)
+	}
+	return hops
+}
+
+// dialJumpHosts connects through hosts in order and returns the client for the last
+// hop, or nil if there are no jump hosts.
+func dialJumpHosts(ctx context.Context, hosts []SSHConfig) (*ssh.Client, error) {
+	var via *ssh.Client
+	for _, hop := range jumpHops(hosts) {
+		client, err := dialHop(ctx, via, hop)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to jump host %s: %w", hop.Address, err)
+		}
+		via = client
+	}
+	return via, nil
+}
+
+// connect opens an SSH connection to cfg.Address, directly or through the via client
+// (a direct-tcpip channel wrapped with ssh.NewClientConn) if via is not nil. Connecting
+// and the handshake are limited by cfg.DialTimeout and cfg.HandshakeTimeout, and the
+// connection is kept alive per cfg.KeepaliveInterval.
+func connect(ctx context.Context, via *ssh.Client, cfg SSHConfig, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := dialTCP(ctx, via, cfg.Address, cfg.dialTimeout())
+	if err != nil {
+		if via != nil {
+			return nil, fmt.Errorf("jump host could not reach %s: %w", cfg.Address, err)
+		}
+		return nil, err
+	}
+	c, chans, reqs, err := handshake(ctx, conn, cfg.Address, config, cfg.HandshakeTimeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if cfg.KeepaliveInterval > 0 {
+		c = startKeepalive(c, cfg.KeepaliveInterval, cfg.keepaliveMaxMissed())
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// closeClient closes client if it is not nil.
+func closeClient(client *ssh.Client) {
+	if client != nil {
+		client.Close()
+	}
+}
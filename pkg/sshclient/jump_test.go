@@ -0,0 +1,173 @@
+package sshclient
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// bastionOption turns a mock server into a jump host that allows direct-tcpip forwarding.
+func bastionOption() ssh.Option {
+	return func(srv *ssh.Server) error {
+		srv.LocalPortForwardingCallback = func(ctx ssh.Context, host string, port uint32) bool { return true }
+		srv.ChannelHandlers = map[string]ssh.ChannelHandler{
+			"session":      ssh.DefaultSessionHandler,
+			"direct-tcpip": ssh.DirectTCPIPHandler,
+		}
+		return nil
+	}
+}
+
+// passwordFor returns a server option accepting only user with password.
+func passwordFor(user, password string) ssh.Option {
+	return ssh.PasswordAuth(func(ctx ssh.Context, pass string) bool {
+		return ctx.User() == user && pass == password
+	})
+}
+
+func TestJumpHops(t *testing.T) {
+	first := SSHConfig{Address: "first:22"}
+	second := SSHConfig{Address: "second:22", JumpHosts: []SSHConfig{first}}
+	third := SSHConfig{Address: "third:22"}
+
+	var got []string
+	for _, hop := range jumpHops([]SSHConfig{second, third}) {
+		if len(hop.JumpHosts) != 0 {
+			t.Errorf("Expected flattened hop %s to have no jump hosts", hop.Address)
+		}
+		got = append(got, hop.Address)
+	}
+	if want := []string{"first:22", "second:22", "third:22"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected hops %v, got %v", want, got)
+	}
+}
+
+func TestDial_JumpHosts(t *testing.T) {
+	bastion1, stopBastion1 := startMockSSHServer(t, func(s ssh.Session) {}, passwordFor("jump1", "pw1"), bastionOption())
+	defer stopBastion1()
+	bastion2, stopBastion2 := startMockSSHServer(t, func(s ssh.Session) {}, passwordFor("jump2", "pw2"), bastionOption())
+	defer stopBastion2()
+	target, stopTarget := startMockSSHServer(t, func(s ssh.Session) {
+		io.WriteString(s, "hello "+s.User())
+	}, passwordFor("app", "target-pw"))
+	defer stopTarget()
+
+	// An address nothing listens on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	closedAddr := l.Addr().String()
+	l.Close()
+
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	// hop returns a config trusting the (fresh) host key of addr on first use.
+	hop := func(addr, user, password string, jumps ...SSHConfig) SSHConfig {
+		return SSHConfig{
+			Address:         addr,
+			User:            user,
+			Password:        password,
+			JumpHosts:       jumps,
+			KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+			HostKeyPolicy:   HostKeyAcceptNew,
+		}
+	}
+	strictBastion := hop(bastion1, "jump1", "pw1")
+	strictBastion.HostKeyPolicy = HostKeyStrict
+
+	tests := []struct {
+		name          string
+		cfg           SSHConfig
+		expectError   bool
+		errorContains string
+	}{
+		{
+			name: "One Jump Host",
+			cfg:  hop(target, "app", "target-pw", hop(bastion1, "jump1", "pw1")),
+		},
+		{
+			name: "Two Jump Hosts",
+			cfg:  hop(target, "app", "target-pw", hop(bastion1, "jump1", "pw1"), hop(bastion2, "jump2", "pw2")),
+		},
+		{
+			name: "Nested Jump Host",
+			cfg:  hop(target, "app", "target-pw", hop(bastion2, "jump2", "pw2", hop(bastion1, "jump1", "pw1"))),
+		},
+		{
+			name:          "Jump Host Auth Failure",
+			cfg:           hop(target, "app", "target-pw", hop(bastion1, "jump1", "wrong")),
+			expectError:   true,
+			errorContains: "failed to connect to jump host " + bastion1,
+		},
+		{
+			name:          "Jump Host Strict Host Key Checking",
+			cfg:           hop(target, "app", "target-pw", strictBastion),
+			expectError:   true,
+			errorContains: "strict host key checking",
+		},
+		{
+			name:          "Target Auth Failure",
+			cfg:           hop(target, "app", "wrong", hop(bastion1, "jump1", "pw1")),
+			expectError:   true,
+			errorContains: "failed to dial SSH server " + target,
+		},
+		{
+			name:          "Target Unreachable From Jump Host",
+			cfg:           hop(closedAddr, "app", "target-pw", hop(bastion1, "jump1", "pw1")),
+			expectError:   true,
+			errorContains: "could not reach",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := dial(context.Background(), tt.cfg)
+			if tt.expectError {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+				}
+				if client != nil {
+					client.Close()
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			defer client.Close()
+
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatalf("Failed to create session: %v", err)
+			}
+			defer session.Close()
+			out, err := session.Output("")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(out) != "hello app" {
+				t.Errorf("Expected output %q, got %q", "hello app", out)
+			}
+
+			// The tunneled connection has no real remote address; only the host name is recorded.
+			knownHosts, err := os.ReadFile(tt.cfg.KnownHostsFiles[0])
+			if err != nil {
+				t.Fatalf("Failed to read known_hosts: %v", err)
+			}
+			if strings.Contains(string(knownHosts), "0.0.0.0") {
+				t.Errorf("Expected no zero address in known_hosts, got %q", knownHosts)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// pkg/sshclient/keepalive.go
+
+package sshclient
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// keepaliveRequest is the global request OpenSSH clients send as ServerAliveInterval.
+// Servers answer it (usually with a failure, as it is unknown to them), which is all
+// that matters.
+const keepaliveRequest = "keepalive@openssh.com"
+
+// defaultKeepaliveMaxMissed is how many keepalives may go unanswered by default, like
+// OpenSSH's ServerAliveCountMax.
+const defaultKeepaliveMaxMissed = 3
+
+// keepaliveMaxMissed returns the number of unanswered keepalives after which cfg's
+// connection is considered lost.
+func (cfg SSHConfig) keepaliveMaxMissed() int {
+	if cfg.KeepaliveMaxMissed > 0 {
+		return cfg.KeepaliveMaxMissed
+	}
+	return defaultKeepaliveMaxMissed
+}
+
+// keepaliveConn is an ssh.Conn that sends keepalives and closes itself when the server
+// stops answering them.
+type keepaliveConn struct {
+	ssh.Conn
+	interval  time.Duration
+	maxMissed int
+	lost      atomic.Bool
+}
+
+// startKeepalive wraps conn to send a keepalive every interval.
+func startKeepalive(conn ssh.Conn, interval time.Duration, maxMissed int) *keepaliveConn {
+	c := &keepaliveConn{Conn: conn, interval: interval, maxMissed: maxMissed}
+	go c.run()
+	return c
+}
+
+// run sends a keepalive every interval while none is outstanding, and closes the
+// connection after maxMissed intervals pass without an answer.
+func (c *keepaliveConn) run() {
+	closed := make(chan struct{})
+	go func() {
+		_ = c.Wait()
+		close(closed)
+	}()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	var pending atomic.Bool
+	missed := 0
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+		if pending.Load() {
+			missed++
+			if missed >= c.maxMissed {
+				log.Printf("Warning: SSH server %s did not answer %d keepalives, closing the connection", c.RemoteAddr(), missed)
+				c.lost.Store(true)
+				c.Close()
+				return
+			}
+			continue
+		}
+		missed = 0
+		pending.Store(true)
+		go func() {
+			if _, _, err := c.SendRequest(keepaliveRequest, true, nil); err == nil {
+				pending.Store(false)
+			}
+		}()
+	}
+}
+
+// err returns ErrConnectionLost if the connection was closed for missing keepalives.
+func (c *keepaliveConn) err() error {
+	if !c.lost.Load() {
+		return nil
+	}
+	return fmt.Errorf("%w: no answer to %d keepalives sent every %s", ErrConnectionLost, c.maxMissed, c.interval)
+}
+
+// connectionLost returns ErrConnectionLost (wrapped) if client's connection was closed
+// because the server stopped answering keepalives, and nil otherwise.
+func connectionLost(client *ssh.Client) error {
+	if c, ok := client.Conn.(*keepaliveConn); ok {
+		return c.err()
+	}
+	return nil
+}
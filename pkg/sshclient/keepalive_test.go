@@ -0,0 +1,125 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// freezableProxy forwards TCP connections to a target until it is frozen, after which it
+// silently drops all traffic but keeps the connections open, like a dead network link.
+type freezableProxy struct {
+	addr   string
+	frozen atomic.Bool
+}
+
+func startFreezableProxy(t *testing.T, target string) *freezableProxy {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	p := &freezableProxy{addr: l.Addr().String()}
+	forward := func(dst, src net.Conn) {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			if err != nil {
+				dst.Close()
+				return
+			}
+			if !p.frozen.Load() {
+				dst.Write(buf[:n])
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", target)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			t.Cleanup(func() { conn.Close(); upstream.Close() })
+			go forward(upstream, conn)
+			go forward(conn, upstream)
+		}
+	}()
+	return p
+}
+
+// freeze makes the proxied connections go silent.
+func (p *freezableProxy) freeze() {
+	p.frozen.Store(true)
+}
+
+func TestKeepalive(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	started := make(chan struct{}, 1)
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {
+		started <- struct{}{}
+		if s.RawCommand() == "hang" {
+			<-s.Context().Done()
+			return
+		}
+		time.Sleep(300 * time.Millisecond) // Several keepalive intervals
+		fmt.Fprintln(s, "done")
+		s.Exit(0)
+	}, passwordFor("runner", "pw"))
+	defer stopServer()
+
+	t.Run("Answered Keepalives", func(t *testing.T) {
+		cfg := runTestConfig(t, addr)
+		cfg.KeepaliveInterval = 20 * time.Millisecond
+		cfg.KeepaliveMaxMissed = 2
+		result, err := Run(context.Background(), cfg, "sleep")
+		if err != nil {
+			t.Fatalf("Run() unexpected error: %v", err)
+		}
+		if string(result.Stdout) != "done\n" {
+			t.Errorf("Expected output %q, got %q", "done\n", result.Stdout)
+		}
+		<-started
+	})
+
+	t.Run("Dead Link", func(t *testing.T) {
+		proxy := startFreezableProxy(t, addr)
+		cfg := runTestConfig(t, proxy.addr)
+		cfg.KeepaliveInterval = 50 * time.Millisecond
+		cfg.KeepaliveMaxMissed = 2
+		go func() {
+			<-started
+			proxy.freeze()
+		}()
+
+		errChan := make(chan error, 1)
+		go func() {
+			_, err := Run(context.Background(), cfg, "hang")
+			errChan <- err
+		}()
+		select {
+		case err := <-errChan:
+			if !errors.Is(err, ErrConnectionLost) {
+				t.Errorf("Expected error matching %v, got: %v", ErrConnectionLost, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Dead connection was not detected")
+		}
+	})
+}
@@ -0,0 +1,195 @@
+// pkg/sshclient/keys.go
+
+package sshclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+)
+
+// maxPassphraseAttempts is how often the passphrase of a key is asked for, as with OpenSSH.
+const maxPassphraseAttempts = 3
+
+// openSSHKeyMagic starts the contents of an OpenSSH format ("BEGIN OPENSSH PRIVATE KEY") key.
+const openSSHKeyMagic = "openssh-key-v1\x00"
+
+// KeyCache keeps the private keys decrypted with a prompted passphrase, by the SHA-256 of
+// the key file. Set the same KeyCache on the configurations that should share it, so that
+// the passphrase is asked for once: jump hosts sharing a key, reconnects and connections
+// to many hosts all reuse the decrypted key. The zero value is an empty cache.
+type KeyCache struct {
+	// mu is held while prompting, so that concurrent connections wait for the first answer
+	mu   sync.Mutex
+	keys map[[sha256.Size]byte]interface{}
+}
+
+// Clear forgets the decrypted keys, e.g. when the user locks the application, so that
+// their passphrases are asked for again.
+func (c *KeyCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.keys)
+}
+
+// PassphrasePromptFunc asks for the passphrase of an encrypted private key. publicKey
+// identifies the key when its format stores the public key unencrypted (OpenSSH format
+// keys do), and is nil otherwise.
+type PassphrasePromptFunc func(publicKey ssh.PublicKey) (string, error)
+
+// privateKey parses cfg.Key: RSA, ECDSA, Ed25519 or DSA, in PKCS#1, PKCS#8, SEC 1 or
+// OpenSSH format. Encrypted keys (legacy PEM encryption, or bcrypt for OpenSSH format) are
+// decrypted with cfg.Passphrase or, if that is empty, with the passphrase asked for with
+// cfg.PassphrasePrompt, up to three times. A key decrypted after prompting is kept in
+// cfg.KeyCache, if set.
+func (cfg SSHConfig) privateKey() (interface{}, error) {
+	rawKey, err := ssh.ParseRawPrivateKey(cfg.Key)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		return rawKey, nil
+	}
+
+	if cfg.Passphrase != "" {
+		rawKey, err = ssh.ParseRawPrivateKeyWithPassphrase(cfg.Key, []byte(cfg.Passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key with passphrase: %w", err)
+		}
+		return rawKey, nil
+	}
+
+	cache, sum := cfg.KeyCache, sha256.Sum256(cfg.Key)
+	if cache != nil {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		if rawKey, ok := cache.keys[sum]; ok {
+			return rawKey, nil
+		}
+	}
+
+	if cfg.PassphrasePrompt == nil {
+		return nil, errors.New("failed to parse private key: it is passphrase protected and there is no PassphrasePrompt to ask for it")
+	}
+	for attempt := 1; ; attempt++ {
+		passphrase, err := cfg.PassphrasePrompt(missing.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		rawKey, err = ssh.ParseRawPrivateKeyWithPassphrase(cfg.Key, []byte(passphrase))
+		if err == nil {
+			if cache != nil {
+				if cache.keys == nil {
+					cache.keys = make(map[[sha256.Size]byte]interface{})
+				}
+				cache.keys[sum] = rawKey
+			}
+			return rawKey, nil
+		}
+		if !errors.Is(err, x509.IncorrectPasswordError) || attempt == maxPassphraseAttempts {
+			return nil, fmt.Errorf("failed to parse private key with passphrase: %w", err)
+		}
+		log.Printf("Warning: Incorrect passphrase for the private key, try again.")
+	}
+}
+
+// TerminalPassphrasePrompt is a PassphrasePromptFunc asking on the process's terminal
+// without echoing the passphrase. It refuses when stdin is not a terminal.
+func TerminalPassphrasePrompt(publicKey ssh.PublicKey) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("the key is passphrase protected and stdin is not a terminal to ask for it")
+	}
+	readSecret := func() (string, error) {
+		b, err := term.ReadPassword(fd)
+		return string(b), err
+	}
+	terminalPromptMu.Lock()
+	defer terminalPromptMu.Unlock()
+	return askPassphrase(os.Stderr, readSecret, publicKey)
+}
+
+// askPassphrase writes the passphrase question to w and reads the answer with readSecret.
+func askPassphrase(w io.Writer, readSecret func() (string, error), publicKey ssh.PublicKey) (string, error) {
+	if publicKey != nil {
+		fmt.Fprintf(w, "Enter passphrase for %s key %s: ", publicKey.Type(), ssh.FingerprintSHA256(publicKey))
+	} else {
+		fmt.Fprint(w, "Enter passphrase for key: ")
+	}
+	passphrase, err := readSecret()
+	fmt.Fprintln(w) // The newline typed by the user was not echoed
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return passphrase, nil
+}
+
+// openSSHPublicKey returns the public key an OpenSSH format private key stores unencrypted,
+// or nil if key is in another format.
+func openSSHPublicKey(key []byte) ssh.PublicKey {
+	block, _ := pem.Decode(key)
+	if block == nil || block.Type != "OPENSSH PRIVATE KEY" {
+		return nil
+	}
+	rest, ok := bytes.CutPrefix(block.Bytes, []byte(openSSHKeyMagic))
+	if !ok {
+		return nil
+	}
+	var header struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}
+	if err := ssh.Unmarshal(rest, &header); err != nil || header.NumKeys != 1 {
+		return nil
+	}
+	pub, err := ssh.ParsePublicKey(header.PubKey)
+	if err != nil {
+		return nil
+	}
+	return pub
+}
+
+// isSecurityKey reports whether key is the private key file of a FIDO/U2F security key
+// (sk-ssh-ed25519@openssh.com or sk-ecdsa-sha2-nistp256@openssh.com). Such a file only
+// holds a handle to the key, which stays on the authenticator.
+func isSecurityKey(key []byte) bool {
+	pub := openSSHPublicKey(key)
+	return pub != nil && strings.HasPrefix(pub.Type(), "sk-")
+}
+
+// securityKeySigner returns the signer for the FIDO/U2F security key whose private key file
+// is key. Signing needs the authenticator, which only ssh-agent (with ssh-sk-helper) can
+// talk to, so the key must have been added to ag with ssh-add.
+func securityKeySigner(key []byte, ag agent.Agent) (ssh.Signer, error) {
+	pub := openSSHPublicKey(key)
+	if ag == nil {
+		return nil, fmt.Errorf("security key %s can only be used through ssh-agent", ssh.FingerprintSHA256(pub))
+	}
+	signers, err := ag.Signers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ssh-agent keys: %w", err)
+	}
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), pub.Marshal()) {
+			return signer, nil
+		}
+	}
+	return nil, fmt.Errorf("security key %s is not loaded in ssh-agent, add it with ssh-add", ssh.FingerprintSHA256(pub))
+}
@@ -0,0 +1,409 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// testKey is a private key in a given file format, with its public key.
+type testKey struct {
+	pem []byte
+	pub gossh.PublicKey
+}
+
+// newTestKey generates a key with generate and encodes it with encode.
+func newTestKey(t *testing.T, generate func() (crypto.Signer, error), encode func(crypto.Signer) (*pem.Block, error)) testKey {
+	t.Helper()
+	key, err := generate()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	block, err := encode(key)
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+	pub, err := gossh.NewPublicKey(key.Public())
+	if err != nil {
+		t.Fatalf("Failed to create public key: %v", err)
+	}
+	return testKey{pem: pem.EncodeToMemory(block), pub: pub}
+}
+
+func generateRSA() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 2048) }
+
+func generateECDSA(curve elliptic.Curve) func() (crypto.Signer, error) {
+	return func() (crypto.Signer, error) { return ecdsa.GenerateKey(curve, rand.Reader) }
+}
+
+func generateEd25519() (crypto.Signer, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	return priv, err
+}
+
+func encodePKCS1(key crypto.Signer) (*pem.Block, error) {
+	return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key.(*rsa.PrivateKey))}, nil
+}
+
+func encodePKCS8(key crypto.Signer) (*pem.Block, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, err
+}
+
+func encodeSEC1(key crypto.Signer) (*pem.Block, error) {
+	der, err := x509.MarshalECPrivateKey(key.(*ecdsa.PrivateKey))
+	return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, err
+}
+
+func encodeOpenSSH(key crypto.Signer) (*pem.Block, error) {
+	return gossh.MarshalPrivateKey(key, "test@jet-access")
+}
+
+func encodeOpenSSHWithPassphrase(passphrase string) func(crypto.Signer) (*pem.Block, error) {
+	return func(key crypto.Signer) (*pem.Block, error) {
+		return gossh.MarshalPrivateKeyWithPassphrase(key, "test@jet-access", []byte(passphrase))
+	}
+}
+
+// fakeSecurityKey is a FIDO sk-ssh-ed25519@openssh.com key, with the signing done by the
+// authenticator emulated in software.
+type fakeSecurityKey struct {
+	priv ed25519.PrivateKey
+	pub  gossh.PublicKey
+}
+
+const skApplication = "ssh:"
+
+func newFakeSecurityKey(t *testing.T) *fakeSecurityKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	skPub, err := gossh.ParsePublicKey(gossh.Marshal(struct {
+		Type        string
+		PubKey      []byte
+		Application string
+	}{gossh.KeyAlgoSKED25519, pub, skApplication}))
+	if err != nil {
+		t.Fatalf("Failed to create security key public key: %v", err)
+	}
+	return &fakeSecurityKey{priv: priv, pub: skPub}
+}
+
+func (k *fakeSecurityKey) PublicKey() gossh.PublicKey { return k.pub }
+
+// Sign signs like an authenticator: over the application and data digests, a "user
+// present" flag and a signature counter.
+func (k *fakeSecurityKey) Sign(_ io.Reader, data []byte) (*gossh.Signature, error) {
+	appDigest := sha256Sum([]byte(skApplication))
+	dataDigest := sha256Sum(data)
+	const flags, counter = 0x01, 1
+	signed := gossh.Marshal(struct {
+		ApplicationDigest []byte `ssh:"rest"`
+		Flags             byte
+		Counter           uint32
+		MessageDigest     []byte `ssh:"rest"`
+	}{appDigest, flags, counter, dataDigest})
+	return &gossh.Signature{
+		Format: gossh.KeyAlgoSKED25519,
+		Blob:   ed25519.Sign(k.priv, signed),
+		Rest: gossh.Marshal(struct {
+			Flags   byte
+			Counter uint32
+		}{flags, counter}),
+	}, nil
+}
+
+func sha256Sum(b []byte) []byte {
+	h := crypto.SHA256.New()
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+// privateKeyFile returns the OpenSSH format private key file of the security key, which
+// holds the public key and a handle to the key on the authenticator.
+func (k *fakeSecurityKey) privateKeyFile() []byte {
+	private := gossh.Marshal(struct {
+		Check1, Check2 uint32
+		Keytype        string
+		Rest           []byte `ssh:"rest"`
+	}{Check1: 7, Check2: 7, Keytype: gossh.KeyAlgoSKED25519, Rest: []byte("key handle")})
+	body := gossh.Marshal(struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{"none", "none", "", 1, k.pub.Marshal(), private})
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: append([]byte(openSSHKeyMagic), body...)})
+}
+
+// securityKeyAgent is an ssh-agent holding security keys.
+type securityKeyAgent struct {
+	agent.Agent
+	keys []gossh.Signer
+}
+
+func (a securityKeyAgent) Signers() ([]gossh.Signer, error) { return a.keys, nil }
+
+func TestDial_KeyTypes(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	legacyEncrypted, legacyPub, err := generateTestKey(2048, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Failed to generate encrypted test key: %v", err)
+	}
+	legacyPubKey, _, _, _, err := gossh.ParseAuthorizedKey(legacyPub)
+	if err != nil {
+		t.Fatalf("Failed to parse public key: %v", err)
+	}
+	securityKey := newFakeSecurityKey(t)
+
+	tests := []struct {
+		name          string
+		key           testKey
+		passphrase    string
+		answers       []string // Passphrases typed at the prompt, in order
+		agent         agent.Agent
+		expectPrompts int
+		errorContains string
+	}{
+		{name: "RSA PKCS#1", key: newTestKey(t, generateRSA, encodePKCS1)},
+		{name: "RSA PKCS#8", key: newTestKey(t, generateRSA, encodePKCS8)},
+		{name: "RSA OpenSSH", key: newTestKey(t, generateRSA, encodeOpenSSH)},
+		{name: "ECDSA P-256 SEC 1", key: newTestKey(t, generateECDSA(elliptic.P256()), encodeSEC1)},
+		{name: "ECDSA P-384 PKCS#8", key: newTestKey(t, generateECDSA(elliptic.P384()), encodePKCS8)},
+		{name: "ECDSA P-521 OpenSSH", key: newTestKey(t, generateECDSA(elliptic.P521()), encodeOpenSSH)},
+		{name: "Ed25519 PKCS#8", key: newTestKey(t, generateEd25519, encodePKCS8)},
+		{name: "Ed25519 OpenSSH", key: newTestKey(t, generateEd25519, encodeOpenSSH)},
+		{
+			name:       "Ed25519 OpenSSH Encrypted With Passphrase",
+			key:        newTestKey(t, generateEd25519, encodeOpenSSHWithPassphrase("secret")),
+			passphrase: "secret",
+		},
+		{
+			name:          "ECDSA OpenSSH Encrypted Prompted",
+			key:           newTestKey(t, generateECDSA(elliptic.P256()), encodeOpenSSHWithPassphrase("secret")),
+			answers:       []string{"typo", "secret"},
+			expectPrompts: 2,
+		},
+		{
+			name:          "RSA Legacy PEM Encrypted Prompted",
+			key:           testKey{pem: legacyEncrypted, pub: legacyPubKey},
+			answers:       []string{"secret"},
+			expectPrompts: 1,
+		},
+		{
+			name:          "Wrong Passphrase Three Times",
+			key:           newTestKey(t, generateEd25519, encodeOpenSSHWithPassphrase("secret")),
+			answers:       []string{"a", "b", "c", "secret"},
+			expectPrompts: 3,
+			errorContains: "failed to parse private key with passphrase",
+		},
+		{
+			name:          "Wrong Configured Passphrase Not Prompted",
+			key:           newTestKey(t, generateEd25519, encodeOpenSSHWithPassphrase("secret")),
+			passphrase:    "wrong",
+			answers:       []string{"secret"},
+			errorContains: "failed to parse private key with passphrase",
+		},
+		{
+			name:  "FIDO Security Key",
+			key:   testKey{pem: securityKey.privateKeyFile(), pub: securityKey.pub},
+			agent: securityKeyAgent{Agent: agent.NewKeyring(), keys: []gossh.Signer{securityKey}},
+		},
+		{
+			name:          "FIDO Security Key Not In Agent",
+			key:           testKey{pem: securityKey.privateKeyFile(), pub: securityKey.pub},
+			agent:         agent.NewKeyring(),
+			errorContains: "is not loaded in ssh-agent",
+		},
+		{
+			name:          "FIDO Security Key Without Agent",
+			key:           testKey{pem: securityKey.privateKeyFile(), pub: securityKey.pub},
+			errorContains: "can only be used through ssh-agent",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, stopServer := startMockSSHServer(t, commandHandler, ssh.PublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
+				return ssh.KeysEqual(key, tt.key.pub)
+			}))
+			defer stopServer()
+
+			prompts := 0
+			cfg := runTestConfig(t, addr)
+			cfg.Password = ""
+			cfg.Key = tt.key.pem
+			cfg.Passphrase = tt.passphrase
+			cfg.Agent = tt.agent
+			cfg.PassphrasePrompt = func(publicKey gossh.PublicKey) (string, error) {
+				if prompts == len(tt.answers) {
+					return "", errors.New("no more answers")
+				}
+				prompts++
+				return tt.answers[prompts-1], nil
+			}
+
+			result, err := Run(context.Background(), cfg, "echo authenticated")
+			if tt.errorContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+				}
+			} else if err != nil {
+				t.Fatalf("Run() unexpected error: %v", err)
+			} else if string(result.Stdout) != "authenticated\n" {
+				t.Errorf("Expected output %q, got %q", "authenticated\n", result.Stdout)
+			}
+			if prompts != tt.expectPrompts {
+				t.Errorf("Expected %d passphrase prompts, got %d", tt.expectPrompts, prompts)
+			}
+		})
+	}
+}
+
+func TestPassphrasePrompt(t *testing.T) {
+	key := newTestKey(t, generateEd25519, encodeOpenSSHWithPassphrase("secret"))
+
+	// The prompt is told which key is meant, as OpenSSH format keys store it unencrypted
+	var promptedFor gossh.PublicKey
+	cfg := SSHConfig{Key: key.pem, PassphrasePrompt: func(publicKey gossh.PublicKey) (string, error) {
+		promptedFor = publicKey
+		return "secret", nil
+	}}
+	if _, err := cfg.privateKey(); err != nil {
+		t.Fatalf("privateKey() unexpected error: %v", err)
+	}
+	if promptedFor == nil || !bytes.Equal(promptedFor.Marshal(), key.pub.Marshal()) {
+		t.Errorf("Expected the prompt to get the key's public key, got %v", promptedFor)
+	}
+
+	var out bytes.Buffer
+	passphrase, err := askPassphrase(&out, func() (string, error) { return "secret", nil }, key.pub)
+	if err != nil || passphrase != "secret" {
+		t.Fatalf("askPassphrase() = %q, %v", passphrase, err)
+	}
+	expected := "Enter passphrase for ssh-ed25519 key " + gossh.FingerprintSHA256(key.pub) + ": \n"
+	if out.String() != expected {
+		t.Errorf("Expected prompt %q, got %q", expected, out.String())
+	}
+	out.Reset()
+	if _, err := askPassphrase(&out, func() (string, error) { return "", io.EOF }, nil); err == nil {
+		t.Error("Expected an error when the passphrase cannot be read")
+	}
+	if out.String() != "Enter passphrase for key: \n" {
+		t.Errorf("Unexpected prompt for a key without public key: %q", out.String())
+	}
+
+	// Without a prompt, and with the terminal prompt but no terminal, it fails instead of blocking
+	if _, err := (SSHConfig{Key: key.pem}).privateKey(); err == nil || !strings.Contains(err.Error(), "no PassphrasePrompt") {
+		t.Errorf("Expected an error without a prompt, got: %v", err)
+	}
+	cfg = SSHConfig{Key: key.pem, PassphrasePrompt: TerminalPassphrasePrompt}
+	if _, err := cfg.privateKey(); err == nil || !strings.Contains(err.Error(), "not a terminal") {
+		t.Errorf("Expected the terminal prompt to fail without a terminal, got: %v", err)
+	}
+}
+
+func TestPassphrasePrompt_AskedOnce(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	key := newTestKey(t, generateEd25519, encodeOpenSSHWithPassphrase("secret"))
+	acceptKey := ssh.PublicKeyAuth(func(ctx ssh.Context, k ssh.PublicKey) bool { return ssh.KeysEqual(k, key.pub) })
+	bastion, stopBastion := startMockSSHServer(t, func(s ssh.Session) {}, acceptKey, bastionOption())
+	defer stopBastion()
+	target, stopTarget := startMockSSHServer(t, commandHandler, acceptKey)
+	defer stopTarget()
+
+	var prompts atomic.Int32
+	prompt := func(publicKey gossh.PublicKey) (string, error) {
+		prompts.Add(1)
+		time.Sleep(50 * time.Millisecond) // Give concurrent connections time to ask too
+		return "secret", nil
+	}
+	// Both hops use the same encrypted key, as with a ProxyJump from ~/.ssh/config
+	cache := &KeyCache{}
+	hop := func(addr string, cache *KeyCache) SSHConfig {
+		cfg := runTestConfig(t, addr)
+		cfg.Password = ""
+		cfg.Key = key.pem
+		cfg.PassphrasePrompt = prompt
+		cfg.KeyCache = cache
+		return cfg
+	}
+	cfg := hop(target, cache)
+	cfg.JumpHosts = []SSHConfig{hop(bastion, cache)}
+
+	// Connections through the jump host, in parallel and one after another (like reconnects)
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := Run(context.Background(), cfg, "echo authenticated")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Run() unexpected error: %v", err)
+		}
+	}
+	if _, err := Run(context.Background(), cfg, "echo authenticated"); err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
+	}
+	if got := prompts.Load(); got != 1 {
+		t.Errorf("Expected the passphrase to be asked for once, got %d prompts", got)
+	}
+
+	// A cleared cache asks again
+	cache.Clear()
+	if _, err := Run(context.Background(), cfg, "echo authenticated"); err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
+	}
+	if got := prompts.Load(); got != 2 {
+		t.Errorf("Expected the passphrase to be asked for again after Clear, got %d prompts", got)
+	}
+
+	// Without a cache, every hop asks
+	prompts.Store(0)
+	uncached := hop(target, nil)
+	uncached.JumpHosts = []SSHConfig{hop(bastion, nil)}
+	if _, err := Run(context.Background(), uncached, "echo authenticated"); err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
+	}
+	if got := prompts.Load(); got != 2 {
+		t.Errorf("Expected a prompt per hop without a cache, got %d prompts", got)
+	}
+}
@@ -0,0 +1,283 @@
+// pkg/sshclient/knownhosts.go
+
+package sshclient
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
+)
+
+// HostKeyPolicy controls how host keys that are missing from known_hosts are handled.
+// Mismatched and revoked keys are always rejected, whatever the policy.
+type HostKeyPolicy int
+
+const (
+	// HostKeyStrict rejects any host whose key is not already in known_hosts (default).
+	HostKeyStrict HostKeyPolicy = iota
+	// HostKeyAcceptNew trusts unknown hosts on first use and records their key.
+	HostKeyAcceptNew
+	// HostKeyAsk asks the user (via HostKeyPrompt) before trusting an unknown host.
+	HostKeyAsk
+)
+
+// String returns the OpenSSH StrictHostKeyChecking name of the policy.
+func (p HostKeyPolicy) String() string {
+	switch p {
+	case HostKeyStrict:
+		return "yes"
+	case HostKeyAcceptNew:
+		return "accept-new"
+	case HostKeyAsk:
+		return "ask"
+	default:
+		return fmt.Sprintf("HostKeyPolicy(%d)", int(p))
+	}
+}
+
+// HostKeyPromptFunc asks whether an unknown host key should be trusted.
+// It returns true to accept (and record) the key.
+type HostKeyPromptFunc func(hostname string, remote net.Addr, key ssh.PublicKey) (bool, error)
+
+// knownHostsMu serialises writes to known_hosts files from concurrent connections.
+var knownHostsMu sync.Mutex
+
+// getKnownHostsFile returns the path to the user's default known_hosts file
+// (~/.ssh/known_hosts), or an empty string if the home directory is unknown.
+func getKnownHostsFile() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		log.Printf("Warning: Could not determine user home directory: %v", err)
+		return ""
+	}
+	return filepath.Join(homeDir, ".ssh", "known_hosts")
+}
+
+// knownHostsFiles returns the configured known_hosts files, falling back to the default.
+func (cfg SSHConfig) knownHostsFiles() []string {
+	if len(cfg.KnownHostsFiles) > 0 {
+		return cfg.KnownHostsFiles
+	}
+	if f := getKnownHostsFile(); f != "" {
+		return []string{f}
+	}
+	return nil
+}
+
+// newKnownHostsCallback builds an ssh.HostKeyCallback from the given known_hosts files.
+// Files that do not exist yet are skipped, so a fresh machine behaves as if every host is unknown.
+func newKnownHostsCallback(files []string) (ssh.HostKeyCallback, error) {
+	existing := make([]string, 0, len(files))
+	for _, f := range files {
+		if _, err := os.Stat(f); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to access known_hosts file %s: %w", f, err)
+		}
+		existing = append(existing, f)
+	}
+	cb, err := knownhosts.New(existing...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts: %w", err)
+	}
+	return cb, nil
+}
+
+// knownHostKeyAlgorithms returns the host key algorithms of the keys recorded for
+// address, so the server is asked for a key we can actually verify. It returns nil
+// when the host is unknown, leaving the library defaults in place.
+func knownHostKeyAlgorithms(verify ssh.HostKeyCallback, address string) []string {
+	// Probe with a key that can never match to learn which keys are on file.
+	probe, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if err := verify(address, &net.TCPAddr{IP: net.IPv4zero}, probe); !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	var algos []string
+	seen := map[string]bool{}
+	for _, known := range keyErr.Want {
+		for _, algo := range algorithmsForKeyType(known.Key.Type()) {
+			if !seen[algo] {
+				seen[algo] = true
+				algos = append(algos, algo)
+			}
+		}
+	}
+	return algos
+}
+
+// algorithmsForKeyType returns the host key algorithms that can produce a key of the given type.
+func algorithmsForKeyType(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+// hostKeyCallback builds the HostKeyCallback for cfg, verifying against known_hosts
+// and applying cfg.HostKeyPolicy to hosts that are not yet known. It also returns the
+// host key algorithms to request from the server (nil means library defaults).
+// Pinned host keys (cfg.HostKeys, cfg.HostKeyFingerprints) take precedence over known_hosts.
+func (cfg SSHConfig) hostKeyCallback() (ssh.HostKeyCallback, []string, error) {
+	if cfg.pinned() {
+		pin, err := cfg.parseHostKeyPin()
+		if err != nil {
+			return nil, nil, err
+		}
+		return pin.callback(), pin.algorithms(), nil
+	}
+
+	files := cfg.knownHostsFiles()
+	verify, err := newKnownHostsCallback(files)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := verify(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		var revokedErr *knownhosts.RevokedError
+		if errors.As(err, &revokedErr) {
+			return fmt.Errorf("%w: host key for %s has been revoked: %w", ErrHostKeyMismatch, hostname, err)
+		}
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			// The host is known but presented a different key: possible MITM.
+			expected := make([]string, 0, len(keyErr.Want))
+			for _, known := range keyErr.Want {
+				expected = append(expected, fmt.Sprintf("%s (%s:%d)", ssh.FingerprintSHA256(known.Key), known.Filename, known.Line))
+			}
+			return &HostKeyMismatchError{
+				Hostname: hostname,
+				Expected: expected,
+				Received: ssh.FingerprintSHA256(key),
+			}
+		}
+
+		// The host is not in known_hosts at all.
+		remote = realRemoteAddr(remote)
+		switch cfg.HostKeyPolicy {
+		case HostKeyAcceptNew:
+			log.Printf("Permanently adding %s (%s %s) to the list of known hosts.",
+				hostname, key.Type(), ssh.FingerprintSHA256(key))
+		case HostKeyAsk:
+			if cfg.HostKeyPrompt == nil {
+				return fmt.Errorf("no host key is known for %s (%s %s) and there is no HostKeyPrompt to ask whether to trust it",
+					hostname, key.Type(), ssh.FingerprintSHA256(key))
+			}
+			ok, err := cfg.HostKeyPrompt(hostname, remote, key)
+			if err != nil {
+				return fmt.Errorf("failed to confirm host key for %s: %w", hostname, err)
+			}
+			if !ok {
+				return fmt.Errorf("host key for %s was not accepted", hostname)
+			}
+		default:
+			return fmt.Errorf("no host key is known for %s (%s %s) and strict host key checking is enabled: %w",
+				hostname, key.Type(), ssh.FingerprintSHA256(key), err)
+		}
+
+		if len(files) == 0 {
+			log.Printf("Warning: No known_hosts file available; host key for %s will not be remembered.", hostname)
+			return nil
+		}
+		return appendKnownHost(files[0], hostname, remote, key)
+	}, knownHostKeyAlgorithms(verify, cfg.Address), nil
+}
+
+// realRemoteAddr returns remote, or nil for the zero address that connections tunneled
+// through a jump host report, so it is neither shown nor recorded.
+func realRemoteAddr(remote net.Addr) net.Addr {
+	if tcpAddr, ok := remote.(*net.TCPAddr); ok && tcpAddr.IP.IsUnspecified() {
+		return nil
+	}
+	return remote
+}
+
+// appendKnownHost records key for hostname (and its IP, if different) in the given known_hosts file.
+func appendKnownHost(file, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	addresses := []string{knownhosts.Normalize(hostname)}
+	if remote != nil {
+		if ip := knownhosts.Normalize(remote.String()); ip != addresses[0] {
+			addresses = append(addresses, ip)
+		}
+	}
+
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory for known_hosts file %s: %w", file, err)
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts file %s: %w", file, err)
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintln(f, knownhosts.Line(addresses, key)); err != nil {
+		return fmt.Errorf("failed to write known_hosts file %s: %w", file, err)
+	}
+	return nil
+}
+
+// TerminalHostKeyPrompt is a HostKeyPromptFunc asking on the process's terminal,
+// mimicking OpenSSH. It refuses when stdin is not a terminal.
+func TerminalHostKeyPrompt(hostname string, remote net.Addr, key ssh.PublicKey) (bool, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false, errors.New("stdin is not a terminal, cannot ask for host key confirmation")
+	}
+	terminalPromptMu.Lock()
+	defer terminalPromptMu.Unlock()
+	return askHostKey(os.Stdin, os.Stderr, hostname, remote, key)
+}
+
+// askHostKey writes the OpenSSH-style confirmation question to w and reads the answer from r.
+func askHostKey(r io.Reader, w io.Writer, hostname string, remote net.Addr, key ssh.PublicKey) (bool, error) {
+	remoteStr := ""
+	if remote != nil {
+		remoteStr = " (" + remote.String() + ")"
+	}
+	fmt.Fprintf(w, "The authenticity of host '%s%s' can't be established.\n", hostname, remoteStr)
+	fmt.Fprintf(w, "%s key fingerprint is %s.\n", key.Type(), ssh.FingerprintSHA256(key))
+
+	fmt.Fprint(w, "Are you sure you want to continue connecting (yes/no)? ")
+
+	for {
+		// Read without buffering, so input after the answer is left for the remote session
+		answer, err := readLine(r)
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "yes":
+			return true, nil
+		case "no":
+			return false, nil
+		}
+		fmt.Fprint(w, "Please type 'yes' or 'no': ")
+	}
+}
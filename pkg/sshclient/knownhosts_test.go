@@ -0,0 +1,243 @@
+package sshclient
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// writeKnownHosts writes the given lines to a temporary known_hosts file and returns its path.
+func writeKnownHosts(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write known_hosts: %v", err)
+	}
+	return path
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	hostSigner, err := generateSigner(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	otherSigner, err := generateSigner(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate other key: %v", err)
+	}
+	hostKey := hostSigner.PublicKey()
+	otherKey := otherSigner.PublicKey()
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 2222}
+
+	tests := []struct {
+		name          string
+		lines         []string
+		hostname      string
+		policy        HostKeyPolicy
+		prompt        HostKeyPromptFunc
+		expectError   bool
+		errorContains string
+		expectAdded   bool // Whether the key should have been appended to known_hosts
+	}{
+		{
+			name:     "Known Host (Plain Entry)",
+			lines:    []string{knownhosts.Line([]string{"example.com"}, hostKey)},
+			hostname: "example.com:22",
+		},
+		{
+			name:     "Known Host (Non-Standard Port)",
+			lines:    []string{knownhosts.Line([]string{"[example.com]:2222"}, hostKey)},
+			hostname: "example.com:2222",
+		},
+		{
+			name:     "Known Host (Hashed Entry)",
+			lines:    []string{knownhosts.Line([]string{knownhosts.HashHostname("example.com")}, hostKey)},
+			hostname: "example.com:22",
+		},
+		{
+			name:          "Port Entry Does Not Match Default Port",
+			lines:         []string{knownhosts.Line([]string{"[example.com]:2222"}, hostKey)},
+			hostname:      "example.com:22",
+			expectError:   true,
+			errorContains: "strict host key checking",
+		},
+		{
+			name:          "Mismatched Key Is Rejected Even In Accept-New",
+			lines:         []string{knownhosts.Line([]string{"example.com"}, otherKey)},
+			hostname:      "example.com:22",
+			policy:        HostKeyAcceptNew,
+			expectError:   true,
+			errorContains: "host key mismatch",
+		},
+		{
+			name: "Revoked Key Is Rejected",
+			lines: []string{
+				"@revoked * " + strings.TrimSpace(string(gossh.MarshalAuthorizedKey(hostKey))),
+				knownhosts.Line([]string{"example.com"}, hostKey),
+			},
+			hostname:      "example.com:22",
+			policy:        HostKeyAcceptNew,
+			expectError:   true,
+			errorContains: "revoked",
+		},
+		{
+			name:          "Unknown Host (Strict)",
+			hostname:      "example.com:22",
+			policy:        HostKeyStrict,
+			expectError:   true,
+			errorContains: "strict host key checking",
+		},
+		{
+			name:        "Unknown Host (Accept-New)",
+			hostname:    "example.com:22",
+			policy:      HostKeyAcceptNew,
+			expectAdded: true,
+		},
+		{
+			name:     "Unknown Host (Ask, Accepted)",
+			hostname: "example.com:22",
+			policy:   HostKeyAsk,
+			prompt: func(string, net.Addr, gossh.PublicKey) (bool, error) {
+				return true, nil
+			},
+			expectAdded: true,
+		},
+		{
+			name:     "Unknown Host (Ask, Declined)",
+			hostname: "example.com:22",
+			policy:   HostKeyAsk,
+			prompt: func(string, net.Addr, gossh.PublicKey) (bool, error) {
+				return false, nil
+			},
+			expectError:   true,
+			errorContains: "was not accepted",
+		},
+		{
+			name:     "Unknown Host (Ask, Prompt Fails)",
+			hostname: "example.com:22",
+			policy:   HostKeyAsk,
+			prompt: func(string, net.Addr, gossh.PublicKey) (bool, error) {
+				return false, errors.New("no tty")
+			},
+			expectError:   true,
+			errorContains: "failed to confirm host key",
+		},
+	}
+
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "known_hosts")
+			if len(tt.lines) > 0 {
+				path = writeKnownHosts(t, tt.lines...)
+			}
+			cfg := SSHConfig{
+				KnownHostsFiles: []string{path},
+				HostKeyPolicy:   tt.policy,
+				HostKeyPrompt:   tt.prompt,
+			}
+
+			cb, _, err := cfg.hostKeyCallback()
+			if err != nil {
+				t.Fatalf("hostKeyCallback() unexpected error: %v", err)
+			}
+			err = cb(tt.hostname, remote, hostKey)
+
+			if tt.expectError {
+				if err == nil {
+					t.Fatalf("Expected an error, but got nil")
+				}
+				if !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+				}
+			} else if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if tt.expectAdded {
+				// The recorded key must now be trusted in strict mode.
+				strict := SSHConfig{KnownHostsFiles: []string{path}}
+				cb, _, err := strict.hostKeyCallback()
+				if err != nil {
+					t.Fatalf("Failed to reload known_hosts: %v", err)
+				}
+				if err := cb(tt.hostname, remote, hostKey); err != nil {
+					t.Errorf("Expected recorded key to be trusted, got: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestKnownHostKeyAlgorithms(t *testing.T) {
+	hostSigner, err := generateSigner(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	path := writeKnownHosts(t, knownhosts.Line([]string{"example.com"}, hostSigner.PublicKey()))
+
+	verify, err := newKnownHostsCallback([]string{path})
+	if err != nil {
+		t.Fatalf("newKnownHostsCallback() unexpected error: %v", err)
+	}
+
+	algos := knownHostKeyAlgorithms(verify, "example.com:22")
+	if len(algos) == 0 || algos[0] != gossh.KeyAlgoRSASHA512 {
+		t.Errorf("Expected RSA SHA-2 algorithms first, got %v", algos)
+	}
+	if algos := knownHostKeyAlgorithms(verify, "unknown.example.com:22"); algos != nil {
+		t.Errorf("Expected nil algorithms for unknown host, got %v", algos)
+	}
+}
+
+func TestAskHostKey(t *testing.T) {
+	signer, err := generateSigner(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expectOK bool
+		expectEr bool
+	}{
+		{name: "Yes", input: "yes\n", expectOK: true},
+		{name: "No", input: "no\n", expectOK: false},
+		{name: "Retry Then Yes", input: "maybe\nYES\n", expectOK: true},
+		{name: "EOF", input: "", expectEr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			ok, err := askHostKey(strings.NewReader(tt.input), &out, "example.com:22", nil, signer.PublicKey())
+			if tt.expectEr {
+				if err == nil {
+					t.Errorf("Expected an error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if ok != tt.expectOK {
+				t.Errorf("Expected %v, got %v", tt.expectOK, ok)
+			}
+			if !strings.Contains(out.String(), gossh.FingerprintSHA256(signer.PublicKey())) {
+				t.Errorf("Expected prompt to show the key fingerprint, got: %s", out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+// pkg/sshclient/pool.go
+
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultPoolIdleTimeout is how long a Pool keeps an unused connection open by default.
+const defaultPoolIdleTimeout = 5 * time.Minute
+
+// PoolKey returns the key a Pool shares connections under: user@host:port, with the
+// port defaulting to 22.
+func PoolKey(user, address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, "22"
+	}
+	return user + "@" + net.JoinHostPort(host, port)
+}
+
+// Pool shares SSH connections between the sessions, transfers and tunnels of a process, so
+// that each host is only connected to (and authenticated with) once. Connections are
+// reference counted and closed once they have been unused for the idle timeout.
+type Pool struct {
+	idleTimeout time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*poolEntry
+	closed  bool
+}
+
+// poolEntry is a pooled connection, or one being dialed.
+type poolEntry struct {
+	ready  chan struct{} // Closed once dialing has finished
+	client *ssh.Client   // Set when dialing succeeded
+	err    error         // Set when dialing failed
+	refs   int           // Borrowers, including those still waiting for ready
+	idle   *time.Timer   // Closes the connection once unused; nil while borrowed
+}
+
+// NewPool returns an empty pool that closes connections after they have been unused for
+// idleTimeout (default: 5 minutes).
+func NewPool(idleTimeout time.Duration) *Pool {
+	if idleTimeout <= 0 {
+		idleTimeout = defaultPoolIdleTimeout
+	}
+	return &Pool{idleTimeout: idleTimeout, entries: make(map[string]*poolEntry)}
+}
+
+// PooledClient is a connection borrowed from a Pool. Close returns it to the pool instead
+// of closing it, so sessions and channels must be closed by whoever opened them.
+type PooledClient struct {
+	*ssh.Client
+	once    sync.Once
+	release func()
+}
+
+// Close returns the connection to the pool.
+func (c *PooledClient) Close() error {
+	c.once.Do(c.release)
+	return nil
+}
+
+// Get returns a connection to cfg.Address as cfg.User, shared with the other borrowers of
+// the same user@host:port, dialing it if there is none.
+func (p *Pool) Get(ctx context.Context, cfg SSHConfig) (*PooledClient, error) {
+	return p.GetFunc(ctx, PoolKey(cfg.User, cfg.Address), func(context.Context) (SSHConfig, error) {
+		return cfg, nil
+	})
+}
+
+// GetFunc is Get for configurations that are expensive to build, e.g. read from Vault:
+// config is only called when no connection is open for key (see PoolKey).
+func (p *Pool) GetFunc(ctx context.Context, key string, config ConfigFunc) (*PooledClient, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errors.New("connection pool is closed")
+	}
+	e, ok := p.entries[key]
+	if !ok {
+		e = &poolEntry{ready: make(chan struct{})}
+		p.entries[key] = e
+		// Dial independently of ctx, so that other borrowers waiting for the connection
+		// are not affected if ctx is cancelled; the connect timeouts still apply.
+		go p.open(context.WithoutCancel(ctx), key, e, config)
+	}
+	e.refs++
+	if e.idle != nil {
+		e.idle.Stop()
+		e.idle = nil
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		p.release(key, e)
+		return nil, ctx.Err()
+	}
+	if e.err != nil {
+		p.release(key, e)
+		return nil, e.err
+	}
+	return &PooledClient{Client: e.client, release: func() { p.release(key, e) }}, nil
+}
+
+// open dials the connection for e.
+func (p *Pool) open(ctx context.Context, key string, e *poolEntry, config ConfigFunc) {
+	cfg, err := config(ctx)
+	var client *ssh.Client
+	if err == nil {
+		client, err = dialShared(ctx, cfg)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer close(e.ready)
+	switch {
+	case err != nil:
+		e.err = err
+		delete(p.entries, key)
+		return
+	case p.closed:
+		client.Close()
+		e.err = errors.New("connection pool is closed")
+		return
+	}
+	e.client = client
+	if e.refs == 0 {
+		e.idle = time.AfterFunc(p.idleTimeout, func() { p.expire(key, e) })
+	}
+	// Forget the connection when it dies, so the next borrower gets a new one
+	go func() {
+		_ = client.Wait()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.entries[key] == e {
+			delete(p.entries, key)
+		}
+	}()
+}
+
+// release drops a reference to e, closing the connection once unused if it has left the
+// pool, or arming its idle timer otherwise.
+func (p *Pool) release(key string, e *poolEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e.refs--
+	if e.refs > 0 || e.client == nil {
+		return
+	}
+	if p.entries[key] != e {
+		e.client.Close()
+		return
+	}
+	e.idle = time.AfterFunc(p.idleTimeout, func() { p.expire(key, e) })
+}
+
+// expire closes e if it is still unused.
+func (p *Pool) expire(key string, e *poolEntry) {
+	p.mu.Lock()
+	if e.refs > 0 || p.entries[key] != e {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.entries, key)
+	p.mu.Unlock()
+	log.Printf("Closing idle SSH connection %s", key)
+	e.client.Close()
+}
+
+// Close closes all pooled connections, including borrowed ones.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	entries := p.entries
+	p.entries = make(map[string]*poolEntry)
+	p.mu.Unlock()
+
+	for _, e := range entries {
+		<-e.ready
+		if e.idle != nil {
+			e.idle.Stop()
+		}
+		if e.client != nil {
+			e.client.Close()
+		}
+	}
+	return nil
+}
+
+// acquire returns a connection for cfg: borrowed from cfg.Pool, reused from a control
+// master at cfg.ControlPath or dialed. release gives it back, closing it unless it is
+// pooled.
+func acquire(ctx context.Context, cfg SSHConfig) (client *ssh.Client, release func() error, err error) {
+	if cfg.Pool != nil {
+		pooled, err := cfg.Pool.Get(ctx, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return pooled.Client, pooled.Close, nil
+	}
+	client, err = dialShared(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, client.Close, nil
+}
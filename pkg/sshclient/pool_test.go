@@ -0,0 +1,169 @@
+package sshclient
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// countingPassword returns a server option accepting user with password and counting
+// the logins in n.
+func countingPassword(user, password string, n *atomic.Int32) ssh.Option {
+	return ssh.PasswordAuth(func(ctx ssh.Context, pass string) bool {
+		n.Add(1)
+		return ctx.User() == user && pass == password
+	})
+}
+
+func TestPoolKey(t *testing.T) {
+	tests := []struct {
+		user, address, expectKey string
+	}{
+		{"root", "db-1:22", "root@db-1:22"},
+		{"root", "db-1", "root@db-1:22"},
+		{"deploy", "10.0.0.5:2222", "deploy@10.0.0.5:2222"},
+		{"deploy", "[::1]:22", "deploy@[::1]:22"},
+		{"deploy", "::1", "deploy@[::1]:22"},
+	}
+	for _, tt := range tests {
+		if got := PoolKey(tt.user, tt.address); got != tt.expectKey {
+			t.Errorf("PoolKey(%q, %q) = %q, expected %q", tt.user, tt.address, got, tt.expectKey)
+		}
+	}
+}
+
+func TestPool_SharesConnection(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	var logins atomic.Int32
+	addr, stopServer := startMockSSHServer(t, commandHandler, countingPassword("runner", "pw", &logins))
+	defer stopServer()
+
+	pool := NewPool(time.Minute)
+	defer pool.Close()
+	cfg := runTestConfig(t, addr)
+	cfg.Pool = pool
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := Run(context.Background(), cfg, "echo shared")
+			if err != nil || string(result.Stdout) != "shared\n" {
+				t.Errorf("Expected output %q, got %+v (%v)", "shared\n", result, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := logins.Load(); got != 1 {
+		t.Errorf("Expected 1 login for 5 commands, got %d", got)
+	}
+	pool.mu.Lock()
+	e := pool.entries[PoolKey("runner", addr)]
+	pool.mu.Unlock()
+	if e == nil || e.refs != 0 {
+		t.Fatalf("Expected an unused pooled connection, got %+v", e)
+	}
+
+	// A connection that died is replaced
+	e.client.Close()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := Run(context.Background(), cfg, "echo again"); err != nil {
+		t.Fatalf("Run() unexpected error after the connection died: %v", err)
+	}
+	if got := logins.Load(); got != 2 {
+		t.Errorf("Expected a new login after the connection died, got %d logins", got)
+	}
+}
+
+func TestPool_IdleTimeout(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	addr, stopServer := startMockSSHServer(t, commandHandler, passwordFor("runner", "pw"))
+	defer stopServer()
+
+	pool := NewPool(100 * time.Millisecond)
+	defer pool.Close()
+	cfg := runTestConfig(t, addr)
+
+	first, err := pool.Get(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Get() unexpected error: %v", err)
+	}
+	second, err := pool.Get(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Get() unexpected error: %v", err)
+	}
+	if first.Client != second.Client {
+		t.Fatal("Expected both borrowers to share the connection")
+	}
+
+	// Borrowed connections are kept open however long they are used
+	first.Close()
+	first.Close() // Releasing twice counts once
+	time.Sleep(200 * time.Millisecond)
+	if _, _, err := second.SendRequest(keepaliveRequest, true, nil); err != nil {
+		t.Fatalf("Expected the borrowed connection to stay open, got: %v", err)
+	}
+
+	second.Close()
+	closed := make(chan struct{})
+	go func() {
+		second.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Idle connection was not closed")
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if len(pool.entries) != 0 {
+		t.Errorf("Expected the idle connection to leave the pool, got %d entries", len(pool.entries))
+	}
+}
+
+func TestPool_GetFunc(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	addr, stopServer := startMockSSHServer(t, commandHandler, passwordFor("runner", "pw"))
+	defer stopServer()
+
+	pool := NewPool(time.Minute)
+	defer pool.Close()
+	var configs atomic.Int32
+	config := func(ctx context.Context) (SSHConfig, error) {
+		configs.Add(1) // E.g. a Vault secret read
+		return runTestConfig(t, addr), nil
+	}
+	for range 3 {
+		client, err := pool.GetFunc(context.Background(), "dev/web-1", config)
+		if err != nil {
+			t.Fatalf("GetFunc() unexpected error: %v", err)
+		}
+		client.Close()
+	}
+	if got := configs.Load(); got != 1 {
+		t.Errorf("Expected the configuration to be built once, got %d", got)
+	}
+
+	pool.Close()
+	if _, err := pool.GetFunc(context.Background(), "dev/web-1", config); err == nil {
+		t.Error("Expected an error from a closed pool")
+	}
+}
@@ -0,0 +1,198 @@
+// pkg/sshclient/reconnect.go
+
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// defaultReconnectDelay is the wait before the second connection attempt by default.
+	defaultReconnectDelay = time.Second
+	// defaultMaxReconnectDelay caps the wait between attempts by default.
+	defaultMaxReconnectDelay = time.Minute
+)
+
+// ConfigFunc returns the settings for a connection. It is called before every connection
+// attempt, so short-lived credentials (e.g. certificates signed by Vault) are fresh.
+type ConfigFunc func(ctx context.Context) (SSHConfig, error)
+
+// ReconnectPolicy configures reconnecting with exponential backoff. Authentication
+// failures and host key mismatches are not retried.
+type ReconnectPolicy struct {
+	InitialDelay  time.Duration // Optional: wait before the second attempt, doubled for each further one (default: 1s)
+	MaxDelay      time.Duration // Optional: cap on the wait between attempts (default: 1m)
+	MaxAttempts   int           // Optional: give up after this many failed attempts in a row (default: never)
+	RepeatCommand bool          // Optional: let RunWithReconnect run the command again when the connection is lost (default: false)
+}
+
+// delay returns the randomized wait after the given failed attempt (counting from 1).
+func (p ReconnectPolicy) delay(attempt int) time.Duration {
+	d, maxDelay := p.InitialDelay, p.MaxDelay
+	if d <= 0 {
+		d = defaultReconnectDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxReconnectDelay
+	}
+	for i := 1; i < attempt && d < maxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, maxDelay)
+	// Spread the wait by ±20% so that clients cut off together do not return in lockstep
+	return d - d/5 + rand.N(2*(d/5)+1)
+}
+
+// exhausted reports whether no attempts are left after the given failed attempt.
+func (p ReconnectPolicy) exhausted(attempt int) bool {
+	return p.MaxAttempts > 0 && attempt >= p.MaxAttempts
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// dialWithRetry connects with a fresh configuration from config, retrying per policy.
+func dialWithRetry(ctx context.Context, config ConfigFunc, policy ReconnectPolicy) (*ssh.Client, SSHConfig, error) {
+	for attempt := 1; ; attempt++ {
+		cfg, err := config(ctx)
+		if err == nil {
+			var client *ssh.Client
+			client, err = dial(ctx, cfg)
+			if err == nil {
+				return client, cfg, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, SSHConfig{}, ctx.Err()
+		}
+		if errors.Is(err, ErrAuthFailed) || errors.Is(err, ErrHostKeyMismatch) || errors.Is(err, ErrNoCommonAlgorithm) {
+			return nil, SSHConfig{}, err
+		}
+		if policy.exhausted(attempt) {
+			return nil, SSHConfig{}, fmt.Errorf("giving up after %d connection attempts: %w", attempt, err)
+		}
+		delay := policy.delay(attempt)
+		log.Printf("Warning: Connection attempt %d failed: %v; retrying in %s", attempt, err, delay.Round(time.Millisecond))
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, SSHConfig{}, err
+		}
+	}
+}
+
+// RunWithReconnect is Run for flaky links. Connecting is retried per policy. If the
+// connection is lost while cmd runs (detected with keepalives, see
+// SSHConfig.KeepaliveInterval), an error matching ErrConnectionLost is returned, as cmd
+// may or may not have completed. Only with policy.RepeatCommand does it reconnect and run
+// cmd again, so cmd must then be safe to repeat, and streamed output may be repeated too.
+func RunWithReconnect(ctx context.Context, config ConfigFunc, cmd string, policy ReconnectPolicy) (*RunResult, error) {
+	return (&Session{}).RunWithReconnect(ctx, config, cmd, policy)
+}
+
+// RunWithReconnect is the package-level RunWithReconnect with the streams of s, as in
+// Session.Run. Input already read from s.Stdin is not sent again when cmd is repeated.
+func (s *Session) RunWithReconnect(ctx context.Context, config ConfigFunc, cmd string, policy ReconnectPolicy) (*RunResult, error) {
+	withPrompts := func(ctx context.Context) (SSHConfig, error) {
+		cfg, err := config(ctx)
+		return s.withPrompts(cfg), err
+	}
+	for losses := 1; ; losses++ {
+		client, cfg, err := dialWithRetry(ctx, withPrompts, policy)
+		if err != nil {
+			return nil, err
+		}
+		result, err := s.runCommand(ctx, client, cfg, cmd)
+		client.Close()
+		if !errors.Is(err, ErrConnectionLost) || !policy.RepeatCommand {
+			return result, err
+		}
+		if policy.exhausted(losses) {
+			return nil, fmt.Errorf("giving up after losing the connection %d times: %w", losses, err)
+		}
+		delay := policy.delay(losses)
+		log.Printf("Warning: %v; reconnecting in %s to run the command again", err, delay.Round(time.Millisecond))
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ConnectForwarderWithReconnect is ConnectForwarder for flaky links. Connecting is
+// retried per policy, and when the connection is lost the forwarder reconnects with a
+// fresh configuration from config. Local listeners stay open meanwhile (connections made
+// while disconnected fail); remote forwards are requested again on the new connection.
+// Wait returns once the forwarder is closed, ctx is done or reconnecting gives up.
+func ConnectForwarderWithReconnect(ctx context.Context, config ConfigFunc, policy ReconnectPolicy) (*PortForwarder, error) {
+	client, _, err := dialWithRetry(ctx, config, policy)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("SSH connection established.")
+
+	ctx, cancel := context.WithCancel(ctx)
+	f := &PortForwarder{client: client, cancel: cancel, done: make(chan struct{})}
+	go f.supervise(ctx, config, policy)
+	return f, nil
+}
+
+// supervise reconnects whenever the SSH connection ends until ctx is done or reconnecting
+// gives up, in which case the reason is left in f.err.
+func (f *PortForwarder) supervise(ctx context.Context, config ConfigFunc, policy ReconnectPolicy) {
+	defer close(f.done)
+	for {
+		client := f.sshClient()
+		err := client.Wait()
+		if lostErr := connectionLost(client); lostErr != nil {
+			err = lostErr
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Warning: SSH connection lost (%v), reconnecting...", err)
+
+		client, _, err = dialWithRetry(ctx, config, policy)
+		if err != nil {
+			if ctx.Err() == nil {
+				f.err = fmt.Errorf("failed to reconnect: %w", err)
+			}
+			return
+		}
+		if !f.reconnected(client) {
+			client.Close()
+			return
+		}
+		log.Println("SSH connection re-established.")
+	}
+}
+
+// reconnected switches the forwarder to client and requests the remote forwards again.
+// It returns false if the forwarder has been closed in the meantime.
+func (f *PortForwarder) reconnected(client *ssh.Client) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return false
+	}
+	f.client = client
+	for _, t := range f.tunnels {
+		if t.listen != nil {
+			t.restart(client)
+		}
+	}
+	return true
+}
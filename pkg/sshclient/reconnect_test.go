@@ -0,0 +1,306 @@
+package sshclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+func TestReconnectPolicy_Delay(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      ReconnectPolicy
+		attempt     int
+		expectDelay time.Duration
+	}{
+		{name: "Default First", attempt: 1, expectDelay: time.Second},
+		{name: "Doubles", policy: ReconnectPolicy{InitialDelay: 100 * time.Millisecond}, attempt: 4, expectDelay: 800 * time.Millisecond},
+		{name: "Capped", policy: ReconnectPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}, attempt: 10, expectDelay: 5 * time.Second},
+		{name: "Default Cap", attempt: 1000, expectDelay: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 20 {
+				got := tt.policy.delay(tt.attempt)
+				if low, high := tt.expectDelay*4/5, tt.expectDelay*6/5; got < low || got > high {
+					t.Fatalf("Expected a delay between %s and %s, got %s", low, high, got)
+				}
+			}
+		})
+	}
+}
+
+func TestDialWithRetry(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {}, passwordFor("runner", "pw"))
+	defer stopServer()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	closedAddr := l.Addr().String()
+	l.Close()
+
+	policy := ReconnectPolicy{InitialDelay: time.Millisecond, MaxAttempts: 3}
+	tests := []struct {
+		name           string
+		config         func(attempt int) (SSHConfig, error)
+		expectAttempts int
+		expectError    error
+		errorContains  string
+	}{
+		{
+			name: "Transient Failure",
+			config: func(attempt int) (SSHConfig, error) {
+				if attempt == 1 {
+					return SSHConfig{}, errors.New("vault unreachable")
+				}
+				return runTestConfig(t, addr), nil
+			},
+			expectAttempts: 2,
+		},
+		{
+			name: "Auth Failure Not Retried",
+			config: func(int) (SSHConfig, error) {
+				cfg := runTestConfig(t, addr)
+				cfg.Password = "wrong"
+				return cfg, nil
+			},
+			expectAttempts: 1,
+			expectError:    ErrAuthFailed,
+		},
+		{
+			name:           "Gives Up",
+			config:         func(int) (SSHConfig, error) { return runTestConfig(t, closedAddr), nil },
+			expectAttempts: 3,
+			errorContains:  "giving up after 3 connection attempts",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			config := func(ctx context.Context) (SSHConfig, error) {
+				attempts++
+				return tt.config(attempts)
+			}
+			client, _, err := dialWithRetry(context.Background(), config, policy)
+			if client != nil {
+				client.Close()
+			}
+			switch {
+			case tt.expectError != nil:
+				if !errors.Is(err, tt.expectError) {
+					t.Errorf("Expected error matching %v, got: %v", tt.expectError, err)
+				}
+			case tt.errorContains != "":
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+				}
+			case err != nil:
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if attempts != tt.expectAttempts {
+				t.Errorf("Expected %d attempts, got %d", tt.expectAttempts, attempts)
+			}
+		})
+	}
+}
+
+func TestRunWithReconnect(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	tests := []struct {
+		name         string
+		repeat       bool
+		expectError  error
+		expectRuns   int32
+		expectStdout string
+	}{
+		{name: "Lost Connection Is Reported", expectError: ErrConnectionLost, expectRuns: 1},
+		{name: "Repeatable Command Runs Again", repeat: true, expectRuns: 2, expectStdout: "done\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sessions atomic.Int32
+			started := make(chan struct{}, 1)
+			addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {
+				if sessions.Add(1) == 1 {
+					started <- struct{}{}
+					<-s.Context().Done() // The link dies while the first run is in progress
+					return
+				}
+				fmt.Fprintln(s, "done")
+				s.Exit(0)
+			}, passwordFor("runner", "pw"))
+			defer stopServer()
+			proxy := startFreezableProxy(t, addr)
+			go func() {
+				<-started
+				proxy.freeze()
+			}()
+
+			var configs atomic.Int32
+			config := func(ctx context.Context) (SSHConfig, error) {
+				target := addr
+				if configs.Add(1) == 1 {
+					target = proxy.addr
+				}
+				cfg := runTestConfig(t, target)
+				cfg.KeepaliveInterval = 50 * time.Millisecond
+				cfg.KeepaliveMaxMissed = 2
+				return cfg, nil
+			}
+			policy := ReconnectPolicy{InitialDelay: 10 * time.Millisecond, RepeatCommand: tt.repeat}
+			result, err := RunWithReconnect(context.Background(), config, "deploy", policy)
+			if tt.expectError != nil {
+				if !errors.Is(err, tt.expectError) {
+					t.Errorf("Expected error matching %v, got: %v", tt.expectError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("RunWithReconnect() unexpected error: %v", err)
+			} else if string(result.Stdout) != tt.expectStdout {
+				t.Errorf("Expected output %q, got %q", tt.expectStdout, result.Stdout)
+			}
+			if configs.Load() != tt.expectRuns || sessions.Load() != tt.expectRuns {
+				t.Errorf("Expected %d configurations and runs, got %d and %d", tt.expectRuns, configs.Load(), sessions.Load())
+			}
+		})
+	}
+}
+
+// tryRoundTrip is roundTrip for tunnels that may not be up yet: it reports failures
+// instead of failing the test.
+func tryRoundTrip(addr, line string) (string, error) {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := io.WriteString(conn, line+"\n"); err != nil {
+		return "", err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	return strings.TrimSuffix(reply, "\n"), err
+}
+
+// eventually retries fn until it succeeds or 5 seconds have passed.
+func eventually(t *testing.T, what string, fn func() error) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := fn()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s: %v", what, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestPortForwarder_Reconnect(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {}, passwordFor("tunnel", "pw"), bastionOption(),
+		reverseForwardingOption(func(host string, port uint32) bool { return true }))
+	defer stopServer()
+	proxy := startFreezableProxy(t, addr)
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+
+	var configs atomic.Int32
+	config := func(ctx context.Context) (SSHConfig, error) {
+		target := addr
+		if configs.Add(1) == 1 {
+			target = proxy.addr
+		}
+		return SSHConfig{
+			Address:            target,
+			User:               "tunnel",
+			Password:           "pw",
+			KnownHostsFiles:    []string{knownHosts},
+			HostKeyPolicy:      HostKeyAcceptNew,
+			KeepaliveInterval:  50 * time.Millisecond,
+			KeepaliveMaxMissed: 2,
+		}, nil
+	}
+	f, err := ConnectForwarderWithReconnect(context.Background(), config, ReconnectPolicy{InitialDelay: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("ConnectForwarderWithReconnect() unexpected error: %v", err)
+	}
+	defer f.Close()
+
+	local, err := f.ForwardLocal(Forward{LocalAddr: "127.0.0.1:0", RemoteAddr: startEchoServer(t, "db:")})
+	if err != nil {
+		t.Fatalf("ForwardLocal() unexpected error: %v", err)
+	}
+	remote, err := f.ForwardRemote(Forward{RemoteAddr: "127.0.0.1:0", LocalAddr: startEchoServer(t, "laptop:")})
+	if err != nil {
+		t.Fatalf("ForwardRemote() unexpected error: %v", err)
+	}
+	localAddr, oldRemoteAddr := local.Addr().String(), remote.Addr().String()
+	for addr, want := range map[string]string{localAddr: "db:ping", oldRemoteAddr: "laptop:ping"} {
+		if got, err := tryRoundTrip(addr, "ping"); err != nil || got != want {
+			t.Fatalf("Expected %q through %s, got %q (%v)", want, addr, got, err)
+		}
+	}
+
+	proxy.freeze()
+	eventually(t, "the local forward to reconnect", func() error {
+		if configs.Load() < 2 {
+			return errors.New("not reconnected yet")
+		}
+		got, err := tryRoundTrip(localAddr, "again")
+		if err == nil && got != "db:again" {
+			err = fmt.Errorf("unexpected reply %q", got)
+		}
+		return err
+	})
+	if local.Addr().String() != localAddr {
+		t.Errorf("Expected the local forward to keep listening on %s, got %s", localAddr, local.Addr())
+	}
+	eventually(t, "the remote forward to be restored", func() error {
+		addr := remote.Addr().String()
+		if addr == oldRemoteAddr {
+			return errors.New("remote forward not restored yet")
+		}
+		got, err := tryRoundTrip(addr, "again")
+		if err == nil && got != "laptop:again" {
+			err = fmt.Errorf("unexpected reply %q", got)
+		}
+		return err
+	})
+
+	f.Close()
+	waited := make(chan error, 1)
+	go func() { waited <- f.Wait() }()
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Errorf("Expected Wait() to return nil after Close, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait() did not return after Close")
+	}
+}
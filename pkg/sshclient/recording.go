@@ -0,0 +1,254 @@
+// pkg/sshclient/recording.go
+
+package sshclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// asciicastVersion is the asciicast file format version written and read
+// (https://docs.asciinema.org/manual/asciicast/v2/).
+const asciicastVersion = 2
+
+// asciicast v2 event types.
+const (
+	eventOutput = "o"
+	eventInput  = "i"
+	eventResize = "r"
+)
+
+// RecordingOptions configures recording of an interactive session (ConnectAndShell) in
+// asciicast v2 format, which can be played back with Play or asciinema.
+type RecordingOptions struct {
+	Writer        io.Writer // Destination of the recording, e.g. a file
+	RecordInput   bool      // Optional: also record keystrokes; they include anything typed at prompts, such as passwords
+	VaultIdentity string    // Optional: Vault identity that granted access, stored in the metadata
+}
+
+// RecordingMetadata identifies a recorded session. It is stored in the asciicast header
+// under "jet_access"; other players ignore it.
+type RecordingMetadata struct {
+	User          string    `json:"user"`
+	Host          string    `json:"host"`
+	VaultIdentity string    `json:"vault_identity,omitempty"`
+	StartTime     time.Time `json:"start_time"`
+}
+
+// asciicastHeader is the first line of an asciicast v2 file.
+type asciicastHeader struct {
+	Version       int                `json:"version"`
+	Width         int                `json:"width"`
+	Height        int                `json:"height"`
+	Timestamp     int64              `json:"timestamp,omitempty"`
+	IdleTimeLimit float64            `json:"idle_time_limit,omitempty"`
+	Title         string             `json:"title,omitempty"`
+	Env           map[string]string  `json:"env,omitempty"`
+	Metadata      *RecordingMetadata `json:"jet_access,omitempty"`
+}
+
+// recorder writes the events of a session as asciicast v2. Recording errors are logged
+// once and end the recording, not the session.
+type recorder struct {
+	mu     sync.Mutex
+	w      io.Writer
+	start  time.Time
+	failed bool
+}
+
+// newRecorder writes the recording header for a session of cfg with a width x height
+// terminal.
+func newRecorder(cfg SSHConfig, width, height int, termType string) (*recorder, error) {
+	opts := cfg.Recording
+	if opts.Writer == nil {
+		return nil, errors.New("session recording requested without a writer")
+	}
+	start := time.Now()
+	header := asciicastHeader{
+		Version:   asciicastVersion,
+		Width:     width,
+		Height:    height,
+		Timestamp: start.Unix(),
+		Title:     cfg.User + "@" + cfg.Address,
+		Env:       map[string]string{"TERM": termType},
+		Metadata: &RecordingMetadata{
+			User:          cfg.User,
+			Host:          cfg.Address,
+			VaultIdentity: opts.VaultIdentity,
+			StartTime:     start.UTC(),
+		},
+	}
+	line, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode recording header: %w", err)
+	}
+	if _, err := opts.Writer.Write(append(line, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to write recording: %w", err)
+	}
+	return &recorder{w: opts.Writer, start: start}, nil
+}
+
+// event records data as an event of the given type at the current time.
+func (r *recorder) event(kind, data string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failed {
+		return
+	}
+	elapsed := math.Round(time.Since(r.start).Seconds()*1e6) / 1e6
+	line, err := json.Marshal([]any{elapsed, kind, data})
+	if err == nil {
+		_, err = r.w.Write(append(line, '\n'))
+	}
+	if err != nil {
+		r.failed = true
+		log.Printf("Warning: Session recording stopped: %v", err)
+	}
+}
+
+// resize records a terminal size change.
+func (r *recorder) resize(width, height int) {
+	r.event(eventResize, fmt.Sprintf("%dx%d", width, height))
+}
+
+// stream returns a writer that records everything written to it as events of kind.
+func (r *recorder) stream(kind string) io.Writer {
+	return &recordStream{r: r, kind: kind}
+}
+
+// recordStream turns writes into events, holding back an incomplete UTF-8 sequence at
+// the end of a write until the rest arrives, since events are JSON strings.
+type recordStream struct {
+	r       *recorder
+	kind    string
+	pending []byte
+}
+
+func (s *recordStream) Write(p []byte) (int, error) {
+	data := append(s.pending, p...)
+	complete, rest := splitIncompleteUTF8(data)
+	s.pending = append([]byte(nil), rest...)
+	if len(complete) > 0 {
+		s.r.event(s.kind, string(complete))
+	}
+	return len(p), nil
+}
+
+// splitIncompleteUTF8 splits b before a trailing incomplete UTF-8 sequence, if any.
+func splitIncompleteUTF8(b []byte) (complete, rest []byte) {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax+1; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i], b[i:]
+			}
+			break
+		}
+	}
+	return b, nil
+}
+
+// Recording is an asciicast v2 recording opened with ReadRecording.
+type Recording struct {
+	Width, Height int
+	Start         time.Time          // Zero if the recording has no timestamp
+	Metadata      *RecordingMetadata // Nil for recordings not made by jet-access
+
+	idleTimeLimit time.Duration
+	r             *bufio.Reader
+}
+
+// PlayOptions configures Recording.Play.
+type PlayOptions struct {
+	Speed   float64       // Optional: playback speed factor (default: 1)
+	MaxIdle time.Duration // Optional: cap on pauses between events (default: the recording's idle_time_limit, if any)
+}
+
+// ReadRecording reads the header of an asciicast v2 recording from r.
+func ReadRecording(r io.Reader) (*Recording, error) {
+	br := bufio.NewReader(r)
+	line, err := br.ReadBytes('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return nil, fmt.Errorf("failed to read recording header: %w", err)
+	}
+	var header asciicastHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil, fmt.Errorf("invalid recording header: %w", err)
+	}
+	if header.Version != asciicastVersion {
+		return nil, fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+	rec := &Recording{
+		Width:         header.Width,
+		Height:        header.Height,
+		Metadata:      header.Metadata,
+		idleTimeLimit: time.Duration(header.IdleTimeLimit * float64(time.Second)),
+		r:             br,
+	}
+	if header.Timestamp != 0 {
+		rec.Start = time.Unix(header.Timestamp, 0)
+	}
+	return rec, nil
+}
+
+// Play writes the recorded output to w with the original timing, adjusted by opts, until
+// the recording ends or ctx is done. Input and resize events are skipped.
+func (rec *Recording) Play(ctx context.Context, w io.Writer, opts PlayOptions) error {
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	maxIdle := opts.MaxIdle
+	if maxIdle <= 0 {
+		maxIdle = rec.idleTimeLimit
+	}
+
+	var last float64
+	for n := 2; ; n++ {
+		line, err := rec.r.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read recording: %w", err)
+		}
+
+		var event []json.RawMessage
+		var at float64
+		var kind, data string
+		if json.Unmarshal(line, &event) != nil || len(event) != 3 ||
+			json.Unmarshal(event[0], &at) != nil || json.Unmarshal(event[1], &kind) != nil ||
+			json.Unmarshal(event[2], &data) != nil {
+			return fmt.Errorf("invalid recording event on line %d", n)
+		}
+		if kind != eventOutput {
+			continue
+		}
+
+		delay := time.Duration((at - last) / speed * float64(time.Second))
+		last = at
+		if maxIdle > 0 && delay > maxIdle {
+			delay = maxIdle
+		}
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if _, err := io.WriteString(w, data); err != nil {
+			return err
+		}
+	}
+}
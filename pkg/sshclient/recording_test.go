@@ -0,0 +1,191 @@
+package sshclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+func TestSession_Recording(t *testing.T) {
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {
+		_, winCh, _ := s.Pty()
+		<-winCh // Initial size
+		fmt.Fprintln(s, "ready")
+		line, _ := bufio.NewReader(s).ReadString('\n')
+		fmt.Fprintf(s, "got %s", line)
+		<-winCh
+		fmt.Fprintln(s, "résumé")
+		s.Exit(0)
+	}, passwordFor("auditor", "pw"))
+	defer stopServer()
+
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	sizes := newFakeTerminalSize(100, 30)
+	stdinReader, stdinWriter := io.Pipe()
+	defer stdinWriter.Close()
+	var stdout syncBuffer
+	var recording bytes.Buffer
+	cfg := SSHConfig{
+		Address:         addr,
+		User:            "auditor",
+		Password:        "pw",
+		KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+		HostKeyPolicy:   HostKeyAcceptNew,
+		Recording:       &RecordingOptions{Writer: &recording, RecordInput: true, VaultIdentity: "entity/alice"},
+	}
+
+	session := &Session{Stdin: stdinReader, Stdout: &stdout, Stderr: io.Discard, TerminalSize: sizes}
+
+	errChan := make(chan error, 1)
+	go func() { errChan <- session.Shell(context.Background(), cfg) }()
+	waitForOutput(t, &stdout, "ready")
+	io.WriteString(stdinWriter, "hello\n")
+	waitForOutput(t, &stdout, "got hello")
+	sizes.resize(132, 43)
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shell() timed out")
+	}
+
+	lines := strings.Split(strings.TrimSuffix(recording.String(), "\n"), "\n")
+	var header asciicastHeader
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil {
+		t.Fatalf("Invalid header %q: %v", lines[0], err)
+	}
+	if header.Version != 2 || header.Width != 100 || header.Height != 30 || header.Timestamp == 0 {
+		t.Errorf("Unexpected header %+v", header)
+	}
+	if m := header.Metadata; m == nil || m.User != "auditor" || m.Host != addr || m.VaultIdentity != "entity/alice" || m.StartTime.IsZero() {
+		t.Errorf("Unexpected metadata %+v", header.Metadata)
+	}
+
+	var output, input, resizes strings.Builder
+	last := 0.0
+	for _, line := range lines[1:] {
+		var event []any
+		if err := json.Unmarshal([]byte(line), &event); err != nil || len(event) != 3 {
+			t.Fatalf("Invalid event %q: %v", line, err)
+		}
+		at, kind, data := event[0].(float64), event[1].(string), event[2].(string)
+		if at < last {
+			t.Errorf("Event times go backwards: %v after %v", at, last)
+		}
+		last = at
+		switch kind {
+		case "o":
+			output.WriteString(data)
+		case "i":
+			input.WriteString(data)
+		case "r":
+			resizes.WriteString(data + ";")
+		}
+	}
+	if got := strings.ReplaceAll(output.String(), "\r\n", "\n"); got != "ready\ngot hello\nrésumé\n" {
+		t.Errorf("Unexpected recorded output %q", got)
+	}
+	if input.String() != "hello\n" {
+		t.Errorf("Expected recorded input %q, got %q", "hello\n", input.String())
+	}
+	if resizes.String() != "132x43;" {
+		t.Errorf("Expected resize event 132x43, got %q", resizes.String())
+	}
+}
+
+func TestRecordStream_SplitsUTF8(t *testing.T) {
+	var buf bytes.Buffer
+	rec := &recorder{w: &buf, start: time.Now()}
+	s := rec.stream(eventOutput)
+	word := []byte("naïve €")
+	for _, chunk := range [][]byte{word[:3], word[3:7], word[7:]} { // Splits "ï" and "€"
+		s.Write(chunk)
+	}
+
+	var got strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var event []any
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("Invalid event %q: %v", line, err)
+		}
+		got.WriteString(event[2].(string))
+	}
+	if got.String() != "naïve €" {
+		t.Errorf("Expected %q, got %q", "naïve €", got.String())
+	}
+}
+
+func TestRecording_Play(t *testing.T) {
+	header := `{"version": 2, "width": 80, "height": 24, "timestamp": 1700000000, "jet_access": {"user": "root", "host": "db-1:22", "start_time": "2023-11-14T22:13:20Z"}}`
+	tests := []struct {
+		name          string
+		recording     string
+		opts          PlayOptions
+		expectOutput  string
+		errorContains string
+	}{
+		{
+			name:         "Output Only",
+			recording:    header + "\n[0.1, \"o\", \"$ \"]\n[0.2, \"i\", \"ls\\r\"]\n[0.3, \"r\", \"100x40\"]\n[0.4, \"o\", \"file.txt\\r\\n\"]\n",
+			opts:         PlayOptions{Speed: 100},
+			expectOutput: "$ file.txt\r\n",
+		},
+		{
+			name:         "Long Pause Capped",
+			recording:    header + "\n[0.0, \"o\", \"a\"]\n[3600.0, \"o\", \"b\"]",
+			opts:         PlayOptions{MaxIdle: 10 * time.Millisecond},
+			expectOutput: "ab",
+		},
+		{
+			name:         "Idle Limit From Header",
+			recording:    `{"version": 2, "width": 80, "height": 24, "idle_time_limit": 0.01}` + "\n[3600.0, \"o\", \"x\"]\n",
+			expectOutput: "x",
+		},
+		{name: "Unsupported Version", recording: `{"version": 1, "width": 80, "height": 24}`, errorContains: "unsupported asciicast version"},
+		{name: "Not A Recording", recording: "hello", errorContains: "invalid recording header"},
+		{name: "Invalid Event", recording: header + "\n[0.1, \"o\", \"ok\"]\n{}\n", opts: PlayOptions{Speed: 100}, expectOutput: "ok", errorContains: "line 3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			rec, err := ReadRecording(strings.NewReader(tt.recording))
+			if err == nil {
+				err = rec.Play(context.Background(), &out, tt.opts)
+			}
+			if tt.errorContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+				}
+			} else if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if out.String() != tt.expectOutput {
+				t.Errorf("Expected output %q, got %q", tt.expectOutput, out.String())
+			}
+		})
+	}
+
+	rec, err := ReadRecording(strings.NewReader(header + "\n"))
+	if err != nil {
+		t.Fatalf("ReadRecording() unexpected error: %v", err)
+	}
+	if rec.Metadata == nil || rec.Metadata.Host != "db-1:22" || rec.Start.Unix() != 1700000000 {
+		t.Errorf("Unexpected recording info: %+v", rec)
+	}
+}
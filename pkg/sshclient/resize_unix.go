@@ -0,0 +1,38 @@
+//go:build !windows
+
+// pkg/sshclient/resize_unix.go
+
+package sshclient
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyResize delivers a value on the returned channel for every SIGWINCH.
+func notifyResize() (<-chan struct{}, func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGWINCH)
+
+	resized := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-sigs:
+				select {
+				case resized <- struct{}{}:
+				default: // A resize is already pending
+				}
+			}
+		}
+	}()
+
+	return resized, func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+// pkg/sshclient/resize_windows.go
+
+package sshclient
+
+// notifyResize returns a channel that never fires: Windows consoles have no SIGWINCH,
+// so the PTY keeps the size it was opened with.
+func notifyResize() (<-chan struct{}, func()) {
+	return nil, func() {}
+}
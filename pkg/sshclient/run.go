@@ -0,0 +1,106 @@
+// pkg/sshclient/run.go
+
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// RunResult is the outcome of a remote command.
+type RunResult struct {
+	Stdout   []byte // Captured output (nil when streamed to Session.Stdout)
+	Stderr   []byte // Captured error output (nil when streamed to Session.Stderr)
+	ExitCode int    // Exit status; 128 plus the signal number if killed by a signal, as in shells
+	Signal   string // Signal that killed the command, without the "SIG" prefix (e.g. "KILL")
+}
+
+// Run executes cmd on the server described by cfg and waits for it to finish. A non-zero
+// exit status or a signal is reported in the result, not as an error. No input is sent and
+// the output is captured. Cancelling ctx kills the command. To stream input and output,
+// use Session.Run.
+func Run(ctx context.Context, cfg SSHConfig, cmd string) (*RunResult, error) {
+	return (&Session{}).Run(ctx, cfg, cmd)
+}
+
+// Run is the package-level Run with the streams of s: input is read from s.Stdin if set,
+// and output is captured unless s.Stdout or s.Stderr is set, in which case that stream is
+// written there as it arrives. No PTY is requested, so TerminalSize and RawMode are not used.
+func (s *Session) Run(ctx context.Context, cfg SSHConfig, cmd string) (*RunResult, error) {
+	client, release, err := acquire(ctx, s.withPrompts(cfg))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return s.runCommand(ctx, client, cfg, cmd)
+}
+
+// runCommand executes cmd in a new session on client.
+func (s *Session) runCommand(ctx context.Context, client *ssh.Client, cfg SSHConfig, cmd string) (*RunResult, error) {
+	session, err := openSession(client, cfg)
+	if err != nil {
+		if lostErr := connectionLost(client); lostErr != nil {
+			return nil, lostErr
+		}
+		return nil, err
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdin = s.Stdin
+	session.Stdout = &stdout
+	if s.Stdout != nil {
+		session.Stdout = s.Stdout
+	}
+	session.Stderr = &stderr
+	if s.Stderr != nil {
+		session.Stderr = s.Stderr
+	}
+
+	if err := session.Start(cmd); err != nil {
+		if lostErr := connectionLost(client); lostErr != nil {
+			return nil, lostErr
+		}
+		return nil, fmt.Errorf("failed to start remote command: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		// Not every server supports signals, so also close the session.
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+	})
+	defer stop()
+
+	err = session.Wait()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if lostErr := connectionLost(client); lostErr != nil {
+		return nil, lostErr
+	}
+
+	result := &RunResult{}
+	if s.Stdout == nil {
+		result.Stdout = stdout.Bytes()
+	}
+	if s.Stderr == nil {
+		result.Stderr = stderr.Bytes()
+	}
+
+	var exitErr *ssh.ExitError
+	var missingErr *ssh.ExitMissingError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitStatus()
+		result.Signal = exitErr.Signal()
+	case errors.As(err, &missingErr):
+		return result, errors.New("remote command ended without reporting an exit status")
+	default:
+		return result, fmt.Errorf("remote command failed: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,143 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// commandHandler is a mock server handler that interprets a few test commands.
+func commandHandler(s ssh.Session) {
+	switch cmd := s.RawCommand(); {
+	case strings.HasPrefix(cmd, "echo "):
+		fmt.Fprintln(s, strings.TrimPrefix(cmd, "echo "))
+		s.Exit(0)
+	case cmd == "fail":
+		fmt.Fprintln(s, "partial output")
+		fmt.Fprintln(s.Stderr(), "something broke")
+		s.Exit(3)
+	case cmd == "cat":
+		io.Copy(s, s)
+		s.Exit(0)
+	case cmd == "killed":
+		msg := struct {
+			Signal     string
+			CoreDumped bool
+			Error      string
+			Lang       string
+		}{Signal: "KILL"}
+		s.SendRequest("exit-signal", false, gossh.Marshal(&msg))
+		s.Close()
+	case cmd == "hang":
+		<-s.Context().Done()
+	default:
+		fmt.Fprintf(s.Stderr(), "%s: command not found\n", cmd)
+		s.Exit(127)
+	}
+}
+
+func runTestConfig(t *testing.T, addr string) SSHConfig {
+	return SSHConfig{
+		Address:         addr,
+		User:            "runner",
+		Password:        "pw",
+		KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+		HostKeyPolicy:   HostKeyAcceptNew,
+	}
+}
+
+func TestRun(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	addr, stopServer := startMockSSHServer(t, commandHandler, passwordFor("runner", "pw"))
+	defer stopServer()
+
+	tests := []struct {
+		name         string
+		cmd          string
+		stdin        string
+		expectStdout string
+		expectStderr string
+		expectCode   int
+		expectSignal string
+	}{
+		{name: "Success", cmd: "echo hello", expectStdout: "hello\n"},
+		{name: "Non-Zero Exit", cmd: "fail", expectStdout: "partial output\n", expectStderr: "something broke\n", expectCode: 3},
+		{name: "Stdin", cmd: "cat", stdin: "piped input", expectStdout: "piped input"},
+		{name: "Killed By Signal", cmd: "killed", expectCode: 128 + 9, expectSignal: "KILL"},
+		{name: "Unknown Command", cmd: "nope", expectStderr: "nope: command not found\n", expectCode: 127},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &Session{}
+			if tt.stdin != "" {
+				session.Stdin = strings.NewReader(tt.stdin)
+			}
+			result, err := session.Run(context.Background(), runTestConfig(t, addr), tt.cmd)
+			if err != nil {
+				t.Fatalf("Run() unexpected error: %v", err)
+			}
+			if string(result.Stdout) != tt.expectStdout || string(result.Stderr) != tt.expectStderr {
+				t.Errorf("Expected stdout %q and stderr %q, got %q and %q", tt.expectStdout, tt.expectStderr, result.Stdout, result.Stderr)
+			}
+			if result.ExitCode != tt.expectCode || result.Signal != tt.expectSignal {
+				t.Errorf("Expected exit code %d and signal %q, got %d and %q", tt.expectCode, tt.expectSignal, result.ExitCode, result.Signal)
+			}
+		})
+	}
+}
+
+func TestSession_Run(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	addr, stopServer := startMockSSHServer(t, commandHandler, passwordFor("runner", "pw"))
+	defer stopServer()
+
+	var stderr bytes.Buffer
+	session := &Session{Stderr: &stderr}
+	result, err := session.Run(context.Background(), runTestConfig(t, addr), "fail")
+	if err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
+	}
+	if stderr.String() != "something broke\n" || result.Stderr != nil {
+		t.Errorf("Expected stderr to be streamed only, got %q streamed and %q captured", stderr.String(), result.Stderr)
+	}
+	if string(result.Stdout) != "partial output\n" || result.ExitCode != 3 {
+		t.Errorf("Expected captured stdout and exit code 3, got %q and %d", result.Stdout, result.ExitCode)
+	}
+}
+
+func TestRun_ContextCanceled(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	addr, stopServer := startMockSSHServer(t, commandHandler, passwordFor("runner", "pw"))
+	defer stopServer()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := Run(ctx, runTestConfig(t, addr), "hang")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run() took %v to return after cancellation", elapsed)
+	}
+}
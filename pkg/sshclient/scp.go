@@ -0,0 +1,468 @@
+// pkg/sshclient/scp.go
+
+package sshclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SCPClient copies files with the legacy SCP protocol by running "scp -t" (sink) and
+// "scp -f" (source) on the server. It is meant for hosts without an SFTP subsystem, such
+// as dropbear on BusyBox systems.
+type SCPClient struct {
+	client  *ssh.Client
+	release func() error // Closes (or returns to its pool) client
+}
+
+// ConnectSCP establishes an SSH connection using cfg for SCP transfers. Closing the
+// returned client closes the SSH connection.
+func ConnectSCP(cfg SSHConfig) (*SCPClient, error) {
+	client, release, err := acquire(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &SCPClient{client: client, release: release}, nil
+}
+
+// Close closes the SSH connection.
+func (c *SCPClient) Close() error {
+	return c.release()
+}
+
+// scpConn is the stdin/stdout of a remote scp process.
+type scpConn struct {
+	w io.Writer
+	r *bufio.Reader
+}
+
+// ack sends a success response.
+func (s *scpConn) ack() error {
+	_, err := s.w.Write([]byte{0})
+	return err
+}
+
+// readAck reads a response: 0 for success, or 1 (warning) or 2 (fatal) followed by an
+// error message line.
+func (s *scpConn) readAck() error {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch b {
+	case 0:
+		return nil
+	case 1, 2:
+		msg, _ := s.r.ReadString('\n')
+		return errors.New(strings.TrimSpace(msg))
+	}
+	return fmt.Errorf("unexpected scp response %q", b)
+}
+
+// send writes a protocol line and waits for the response.
+func (s *scpConn) send(line string) error {
+	if _, err := io.WriteString(s.w, line); err != nil {
+		return err
+	}
+	return s.readAck()
+}
+
+// run starts command on the server and calls fn with its stdin/stdout. Cancelling ctx
+// closes the session, which aborts fn.
+func (c *SCPClient) run(ctx context.Context, command string, fn func(s *scpConn) error) error {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+	w, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to set up SCP session: %w", err)
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to set up SCP session: %w", err)
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	if err := session.Start(command); err != nil {
+		return fmt.Errorf("failed to start %q: %w", command, err)
+	}
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	defer stop()
+
+	err = fn(&scpConn{w: w, r: bufio.NewReader(r)})
+	if err != nil {
+		session.Close()
+	} else {
+		w.Close()
+		if waitErr := session.Wait(); waitErr != nil {
+			err = fmt.Errorf("remote scp failed: %w %s", waitErr, strings.TrimSpace(stderr.String()))
+		}
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// shellQuote quotes s for the remote POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// scpCommand returns the remote scp command line for mode "-t" or "-f".
+func scpCommand(mode, remotePath string, recursive bool) string {
+	flags := mode + " -p"
+	if recursive {
+		flags += " -r"
+	}
+	return "scp " + flags + " " + shellQuote(remotePath)
+}
+
+// Upload copies the local file or directory localPath to remotePath with "scp -t". If
+// remotePath is an existing directory, localPath is copied into it. File permissions and
+// modification times are preserved. Symbolic links and special files inside directories
+// are skipped.
+func (c *SCPClient) Upload(ctx context.Context, localPath, remotePath string, opts TransferOptions) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() && !opts.Recursive {
+		return fmt.Errorf("%s is a directory (recursive copy not requested)", localPath)
+	}
+	return c.run(ctx, scpCommand("-t", remotePath, opts.Recursive), func(s *scpConn) error {
+		if err := s.readAck(); err != nil {
+			return fmt.Errorf("remote scp failed: %w", err)
+		}
+		if info.IsDir() {
+			return scpSendDir(ctx, s, localPath, info, opts.Progress)
+		}
+		return scpSendFile(ctx, s, localPath, info, opts.Progress)
+	})
+}
+
+// scpSendTimes sends the modification time record that precedes a file or directory.
+func scpSendTimes(s *scpConn, info fs.FileInfo) error {
+	mtime := info.ModTime().Unix()
+	return s.send(fmt.Sprintf("T%d 0 %d 0\n", mtime, mtime))
+}
+
+// scpName returns the base name of localPath for a C or D record.
+func scpName(localPath string) (string, error) {
+	name := filepath.Base(localPath)
+	if strings.ContainsAny(name, "\n") {
+		return "", fmt.Errorf("cannot copy %q with SCP: name contains a newline", localPath)
+	}
+	return name, nil
+}
+
+// scpSendFile sends one regular file.
+func scpSendFile(ctx context.Context, s *scpConn, localPath string, info fs.FileInfo, progress ProgressFunc) error {
+	name, err := scpName(localPath)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := scpSendTimes(s, info); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", localPath, err)
+	}
+	if err := s.send(fmt.Sprintf("C%04o %d %s\n", info.Mode().Perm(), info.Size(), name)); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", localPath, err)
+	}
+	report := progressReporter(localPath, info.Size(), progress)
+	if err := copyChunks(ctx, s.w, io.LimitReader(f, info.Size()), info.Size(), report); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", localPath, err)
+	}
+	if err := s.ack(); err != nil {
+		return err
+	}
+	if err := s.readAck(); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", localPath, err)
+	}
+	return nil
+}
+
+// scpSendDir sends a directory tree.
+func scpSendDir(ctx context.Context, s *scpConn, localDir string, info fs.FileInfo, progress ProgressFunc) error {
+	name, err := scpName(localDir)
+	if err != nil {
+		return err
+	}
+	if err := scpSendTimes(s, info); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", localDir, err)
+	}
+	if err := s.send(fmt.Sprintf("D%04o 0 %s\n", info.Mode().Perm(), name)); err != nil {
+		return fmt.Errorf("failed to create remote directory for %s: %w", localDir, err)
+	}
+
+	entries, err := os.ReadDir(localDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		src := filepath.Join(localDir, entry.Name())
+		entryInfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entryInfo.IsDir():
+			err = scpSendDir(ctx, s, src, entryInfo, progress)
+		case entryInfo.Mode().IsRegular():
+			err = scpSendFile(ctx, s, src, entryInfo, progress)
+		default:
+			log.Printf("Warning: skipping %s: not a regular file or directory", src)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return s.send("E\n")
+}
+
+// Download copies the remote file or directory remotePath to localPath with "scp -f". If
+// localPath is an existing directory, remotePath is copied into it. File permissions and
+// modification times are preserved.
+func (c *SCPClient) Download(ctx context.Context, remotePath, localPath string, opts TransferOptions) error {
+	return c.run(ctx, scpCommand("-f", remotePath, opts.Recursive), func(s *scpConn) error {
+		sink := &scpSink{
+			s:         s,
+			remote:    remotePath,
+			local:     localPath,
+			recursive: opts.Recursive,
+			progress:  opts.Progress,
+		}
+		if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+			sink.intoDir = true
+		}
+		return sink.receive(ctx)
+	})
+}
+
+// scpSink receives the records sent by "scp -f".
+type scpSink struct {
+	s         *scpConn
+	remote    string // Requested remote path
+	local     string // Requested local path
+	intoDir   bool   // local is an existing directory
+	recursive bool
+	progress  ProgressFunc
+
+	dirs  []scpDir   // Directories being received
+	mtime *time.Time // Time from the last T record
+}
+
+// scpFileInfo is an fs.FileInfo built from C and D records.
+type scpFileInfo struct {
+	name  string
+	size  int64
+	mode  fs.FileMode
+	mtime time.Time
+}
+
+func (fi *scpFileInfo) Name() string       { return fi.name }
+func (fi *scpFileInfo) Size() int64        { return fi.size }
+func (fi *scpFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *scpFileInfo) ModTime() time.Time { return fi.mtime }
+func (fi *scpFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *scpFileInfo) Sys() any           { return nil }
+
+// scpDir is a directory being received.
+type scpDir struct {
+	local, remote string
+	info          fs.FileInfo
+}
+
+// receive processes records until the source is done.
+func (k *scpSink) receive(ctx context.Context) error {
+	if err := k.s.ack(); err != nil {
+		return err
+	}
+	received := false
+	for {
+		kind, err := k.s.r.ReadByte()
+		if err == io.EOF && len(k.dirs) == 0 && received {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read from remote scp: %w", err)
+		}
+		if kind == 1 || kind == 2 {
+			msg, _ := k.s.r.ReadString('\n')
+			return fmt.Errorf("failed to download %s: %s", k.remote, strings.TrimSpace(msg))
+		}
+		line, err := k.s.r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read from remote scp: %w", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch kind {
+		case 'T':
+			var mtime, mtimeUsec, atime, atimeUsec int64
+			if _, err := fmt.Sscanf(line, "%d %d %d %d", &mtime, &mtimeUsec, &atime, &atimeUsec); err != nil {
+				return fmt.Errorf("invalid scp time record %q", line)
+			}
+			t := time.Unix(mtime, 0)
+			k.mtime = &t
+			err = k.s.ack()
+		case 'C', 'D':
+			// Only the requested file or directory may be sent at the top level: a
+			// malicious server could otherwise write other files into the target directory.
+			if len(k.dirs) == 0 && received {
+				return fmt.Errorf("remote scp sent more than the requested %s", k.remote)
+			}
+			received = true
+			err = k.receiveEntry(ctx, kind, line)
+		case 'E':
+			err = k.endDir()
+		default:
+			return fmt.Errorf("unexpected scp record %q", string(kind)+line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// receiveEntry handles a C (file) or D (directory) record, "mode size name".
+func (k *scpSink) receiveEntry(ctx context.Context, kind byte, line string) error {
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 {
+		return fmt.Errorf("invalid scp record %q", string(kind)+line)
+	}
+	mode, err := strconv.ParseUint(fields[0], 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid scp record %q", string(kind)+line)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid scp record %q", string(kind)+line)
+	}
+	name := fields[2]
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("remote scp sent invalid file name %q", name)
+	}
+	if len(k.dirs) == 0 && name != path.Base(k.remote) {
+		return fmt.Errorf("remote scp sent %q instead of the requested %s", name, k.remote)
+	}
+
+	info := &scpFileInfo{name: name, size: size, mode: fs.FileMode(mode & 0o777)}
+	if k.mtime != nil {
+		info.mtime = *k.mtime
+		k.mtime = nil
+	}
+	local, remote := k.local, k.remote
+	if n := len(k.dirs); n > 0 {
+		local = filepath.Join(k.dirs[n-1].local, name)
+		remote = path.Join(k.dirs[n-1].remote, name)
+	} else if k.intoDir {
+		local = filepath.Join(k.local, name)
+	}
+
+	if kind == 'D' {
+		if !k.recursive {
+			return fmt.Errorf("%s is a directory (recursive copy not requested)", k.remote)
+		}
+		info.mode |= fs.ModeDir
+		if err := os.Mkdir(local, info.mode.Perm()|0o700); err != nil && !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("failed to create directory %s: %w", local, err)
+		}
+		k.dirs = append(k.dirs, scpDir{local: local, remote: remote, info: info})
+		return k.s.ack()
+	}
+	return k.receiveFile(ctx, local, remote, info)
+}
+
+// receiveFile writes the data of a C record to local. A partially written file is
+// removed if the transfer fails.
+func (k *scpSink) receiveFile(ctx context.Context, local, remote string, info fs.FileInfo) (err error) {
+	f, err := os.OpenFile(local, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm()|0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = preserveLocal(local, info)
+		}
+		if err != nil {
+			os.Remove(local)
+		}
+	}()
+
+	if err := k.s.ack(); err != nil {
+		return err
+	}
+	report := progressReporter(remote, info.Size(), k.progress)
+	if err := copyChunks(ctx, f, k.s.r, info.Size(), report); err != nil {
+		return fmt.Errorf("failed to download %s: %w", remote, err)
+	}
+	if err := k.s.readAck(); err != nil {
+		return fmt.Errorf("failed to download %s: %w", remote, err)
+	}
+	return k.s.ack()
+}
+
+// endDir handles an E record, finishing the innermost directory.
+func (k *scpSink) endDir() error {
+	n := len(k.dirs)
+	if n == 0 {
+		return errors.New("unexpected scp end of directory record")
+	}
+	dir := k.dirs[n-1]
+	k.dirs = k.dirs[:n-1]
+	if err := preserveLocal(dir.local, dir.info); err != nil {
+		return err
+	}
+	return k.s.ack()
+}
+
+// copyChunks copies exactly size bytes from r to w, checking ctx and calling progress
+// after every chunk.
+func copyChunks(ctx context.Context, w io.Writer, r io.Reader, size int64, progress func(n int64)) error {
+	buf := make([]byte, sftpChunkSize)
+	for size > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := io.ReadFull(r, buf[:min(int64(len(buf)), size)])
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			size -= int64(n)
+			progress(int64(n))
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return errors.New("file was truncated during the transfer")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// execHandler runs session commands with sh in dir, like a server without an SFTP
+// subsystem that still has scp installed.
+func execHandler(dir string) ssh.Handler {
+	return func(s ssh.Session) {
+		cmd := exec.Command("sh", "-c", s.RawCommand())
+		cmd.Dir = dir
+		cmd.Stdout = s
+		cmd.Stderr = s.Stderr()
+		// Like sshd, do not wait for the client to close stdin once the command exits.
+		stdin, err := cmd.StdinPipe()
+		if err == nil {
+			go func() {
+				io.Copy(stdin, s)
+				stdin.Close()
+			}()
+			err = cmd.Run()
+		}
+		var exitErr *exec.ExitError
+		switch {
+		case errors.As(err, &exitErr):
+			s.Exit(exitErr.ExitCode())
+		case err != nil:
+			s.Exit(255)
+		default:
+			s.Exit(0)
+		}
+	}
+}
+
+// startSCP starts a mock SSH server that only supports commands, connects with
+// ConnectFileTransfer and returns the client and the server's working directory.
+func startSCP(t *testing.T) (FileTransfer, string) {
+	t.Helper()
+	if _, err := exec.LookPath("scp"); err != nil {
+		t.Skip("scp is not installed")
+	}
+	root := t.TempDir()
+	addr, stopServer := startMockSSHServer(t, execHandler(root), passwordFor("files", "pw"))
+	t.Cleanup(stopServer)
+
+	c, err := ConnectFileTransfer(SSHConfig{
+		Address:         addr,
+		User:            "files",
+		Password:        "pw",
+		KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+		HostKeyPolicy:   HostKeyAcceptNew,
+	})
+	if err != nil {
+		t.Fatalf("ConnectFileTransfer() unexpected error: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, root
+}
+
+func TestConnectFileTransfer(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	tests := []struct {
+		name      string
+		options   []ssh.Option
+		expectSCP bool
+	}{
+		{name: "SFTP Available", options: []ssh.Option{sftpSubsystemOption(t.TempDir())}},
+		{name: "SFTP Unavailable", expectSCP: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {}, append(tt.options, passwordFor("files", "pw"))...)
+			defer stopServer()
+
+			c, err := ConnectFileTransfer(SSHConfig{
+				Address:         addr,
+				User:            "files",
+				Password:        "pw",
+				KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+				HostKeyPolicy:   HostKeyAcceptNew,
+			})
+			if err != nil {
+				t.Fatalf("ConnectFileTransfer() unexpected error: %v", err)
+			}
+			defer c.Close()
+			if _, isSCP := c.(*SCPClient); isSCP != tt.expectSCP {
+				t.Errorf("Expected SCP fallback %v, got %T", tt.expectSCP, c)
+			}
+		})
+	}
+}
+
+func TestSCPClient_UploadDownload(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	c, root := startSCP(t)
+	ctx := context.Background()
+	local := t.TempDir()
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	data := []byte(strings.Repeat("busybox ", 20000)) // Several chunks
+
+	src := filepath.Join(local, "it's here.txt")
+	writeTestFile(t, src, data, 0o640, mtime)
+
+	var last [2]int64
+	progress := func(path string, transferred, total int64) { last = [2]int64{transferred, total} }
+	if err := c.Upload(ctx, src, "upload.txt", TransferOptions{Progress: progress}); err != nil {
+		t.Fatalf("Upload() unexpected error: %v", err)
+	}
+	checkTestFile(t, filepath.Join(root, "upload.txt"), data, 0o640, mtime)
+	if last != [2]int64{int64(len(data)), int64(len(data))} {
+		t.Errorf("Expected progress to end at %d/%d, got %v", len(data), len(data), last)
+	}
+
+	dst := filepath.Join(local, "copy.txt")
+	if err := c.Download(ctx, "upload.txt", dst, TransferOptions{}); err != nil {
+		t.Fatalf("Download() unexpected error: %v", err)
+	}
+	checkTestFile(t, dst, data, 0o640, mtime)
+
+	// An existing directory as the destination receives the file under its own name.
+	if err := c.Download(ctx, "upload.txt", local, TransferOptions{}); err != nil {
+		t.Fatalf("Download() into directory unexpected error: %v", err)
+	}
+	checkTestFile(t, filepath.Join(local, "upload.txt"), data, 0o640, mtime)
+
+	err := c.Download(ctx, "missing.txt", dst, TransferOptions{})
+	if err == nil || !strings.Contains(err.Error(), "No such file") {
+		t.Errorf("Expected error containing 'No such file', but got: %v", err)
+	}
+}
+
+func TestSCPClient_Recursive(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	c, root := startSCP(t)
+	ctx := context.Background()
+	local := t.TempDir()
+	mtime := time.Date(2023, 11, 5, 8, 30, 0, 0, time.UTC)
+
+	tree := filepath.Join(local, "site")
+	if err := os.MkdirAll(filepath.Join(tree, "assets"), 0o755); err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	writeTestFile(t, filepath.Join(tree, "index.html"), []byte("<h1>hi</h1>"), 0o644, mtime)
+	writeTestFile(t, filepath.Join(tree, "assets", "run.sh"), []byte("#!/bin/sh\n"), 0o755, mtime)
+	writeTestFile(t, filepath.Join(tree, "assets", "empty"), nil, 0o600, mtime)
+	if err := os.Chtimes(filepath.Join(tree, "assets"), mtime, mtime); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+
+	if err := c.Upload(ctx, tree, "deploy", TransferOptions{}); err == nil || !strings.Contains(err.Error(), "recursive") {
+		t.Errorf("Expected error containing 'recursive', but got: %v", err)
+	}
+	if err := c.Upload(ctx, tree, "deploy", TransferOptions{Recursive: true}); err != nil {
+		t.Fatalf("Upload() unexpected error: %v", err)
+	}
+	checkTestFile(t, filepath.Join(root, "deploy", "index.html"), []byte("<h1>hi</h1>"), 0o644, mtime)
+	checkTestFile(t, filepath.Join(root, "deploy", "assets", "run.sh"), []byte("#!/bin/sh\n"), 0o755, mtime)
+	checkTestFile(t, filepath.Join(root, "deploy", "assets", "empty"), nil, 0o600, mtime)
+
+	// The remote scp refuses directories without -r.
+	if err := c.Download(ctx, "deploy", t.TempDir(), TransferOptions{}); err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Errorf("Expected error containing 'not a regular file', but got: %v", err)
+	}
+	back := filepath.Join(t.TempDir(), "restored")
+	if err := c.Download(ctx, "deploy", back, TransferOptions{Recursive: true}); err != nil {
+		t.Fatalf("Download() unexpected error: %v", err)
+	}
+	checkTestFile(t, filepath.Join(back, "index.html"), []byte("<h1>hi</h1>"), 0o644, mtime)
+	checkTestFile(t, filepath.Join(back, "assets", "run.sh"), []byte("#!/bin/sh\n"), 0o755, mtime)
+	checkTestFile(t, filepath.Join(back, "assets", "empty"), nil, 0o600, mtime)
+	if info, err := os.Stat(filepath.Join(back, "assets")); err != nil || !info.ModTime().Equal(mtime) {
+		t.Errorf("Expected directory mtime %v to be preserved (err: %v)", mtime, err)
+	}
+}
+
+func TestSCPClient_Cancel(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	c, root := startSCP(t)
+	writeTestFile(t, filepath.Join(root, "big.bin"), make([]byte, 10*sftpChunkSize), 0o644, time.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	progress := func(path string, transferred, total int64) {
+		if transferred > 0 {
+			cancel()
+		}
+	}
+	dst := filepath.Join(t.TempDir(), "big.bin")
+	err := c.Download(ctx, "big.bin", dst, TransferOptions{Progress: progress})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got: %v", err)
+	}
+	if _, err := os.Stat(dst); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected partial download to be removed, got: %v", err)
+	}
+}
+
+func TestSCPClient_RogueSource(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	tests := []struct {
+		name        string
+		remote      string
+		recursive   bool
+		records     string // What the malicious "scp -f" sends
+		expectError string
+		expectFiles []string
+	}{
+		{
+			name:        "Unrequested Name",
+			remote:      "notes.txt",
+			records:     "C0644 6 .bashrc\nowned\n\x00",
+			expectError: "instead of the requested",
+		},
+		{
+			name:        "Extra File",
+			remote:      "notes.txt",
+			records:     "C0644 3 notes.txt\nhi\n\x00C0644 6 .bashrc\nowned\n\x00",
+			expectError: "more than the requested",
+			expectFiles: []string{"notes.txt"},
+		},
+		{
+			name:        "Extra Directory",
+			remote:      "site/",
+			recursive:   true,
+			records:     "D0755 0 site\nE\nD0700 0 .ssh\nC0600 6 authorized_keys\nowned\n\x00E\n",
+			expectError: "more than the requested",
+			expectFiles: []string{"site"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {
+				if !strings.HasPrefix(s.RawCommand(), "scp -f") {
+					s.Exit(1)
+					return
+				}
+				// The client's acknowledgements are ignored, the records are sent regardless
+				io.WriteString(s, tt.records)
+				s.CloseWrite()
+				io.Copy(io.Discard, s)
+				s.Exit(0)
+			}, passwordFor("files", "pw"))
+			defer stopServer()
+
+			c, err := ConnectSCP(SSHConfig{
+				Address:         addr,
+				User:            "files",
+				Password:        "pw",
+				KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+				HostKeyPolicy:   HostKeyAcceptNew,
+			})
+			if err != nil {
+				t.Fatalf("ConnectSCP() unexpected error: %v", err)
+			}
+			defer c.Close()
+
+			local := t.TempDir()
+			err = c.Download(context.Background(), tt.remote, local, TransferOptions{Recursive: tt.recursive})
+			if err == nil || !strings.Contains(err.Error(), tt.expectError) {
+				t.Errorf("Expected error containing %q, but got: %v", tt.expectError, err)
+			}
+			entries, err := os.ReadDir(local)
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", local, err)
+			}
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			if strings.Join(names, ",") != strings.Join(tt.expectFiles, ",") {
+				t.Errorf("Expected only %v in the target directory, got %v", tt.expectFiles, names)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+// pkg/sshclient/session.go
+
+package sshclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Session is an interactive shell or a command wired to arbitrary streams instead of the
+// process's terminal, e.g. to embed jet-access in a web terminal, a TUI pane or a test.
+// ConnectAndShell runs a Session on the local terminal.
+//
+// Questions asked while connecting (unknown host keys, key passphrases, keyboard-interactive
+// prompts) go to the Session's streams too, unless SSHConfig has its own HostKeyPrompt,
+// PassphrasePrompt or ChallengeResponder: they are written to Stderr (or Stdout) and
+// answered on Stdin, without echo if Stdin is a terminal. Nothing is asked without Stdin or
+// when Stdin is a file that is not a terminal, such as input piped for a remote command.
+type Session struct {
+	Stdin        io.Reader    // Optional: input for the shell (default: none)
+	Stdout       io.Writer    // Optional: output of the shell (default: discarded)
+	Stderr       io.Writer    // Optional: error output of the shell (default: discarded)
+	TerminalSize TerminalSize // Optional: PTY size source; a PTY is requested when set
+	RawMode      RawMode      // Optional: local terminal to put in raw mode while the shell runs
+}
+
+// TerminalSession returns the Session for the process's terminal: os.Stdin, os.Stdout and
+// os.Stderr and, if stdin is a terminal, a PTY following its size with the terminal in raw mode.
+func TerminalSession() *Session {
+	s := &Session{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		s.RawMode = fdRawMode{fd: fd}
+		s.TerminalSize = fdTerminalSize{fd: fd}
+	}
+	return s
+}
+
+// Shell connects with cfg and runs an interactive shell on s until it exits or ctx is done.
+// Connection failures can be matched with
+// errors.Is against ErrDialTimeout, ErrHandshakeTimeout, ErrAuthFailed and ErrHostKeyMismatch,
+// a session ended by cfg.IdleTimeout against ErrIdleTimeout, and a dead connection detected
+// with keepalives against ErrConnectionLost.
+func (s *Session) Shell(ctx context.Context, cfg SSHConfig) error {
+	// --- 1-3. Authenticate and Establish the Connection ---
+	client, release, err := acquire(ctx, s.withPrompts(cfg))
+	if err != nil {
+		return err
+	}
+	defer release() // Ensure client connection is closed (or returned to the pool) when function exits
+
+	log.Println("SSH connection established.")
+
+	// --- 4. Create a Session ---
+	session, err := openSession(client, cfg)
+	if err != nil {
+		return err
+	}
+	defer session.Close() // Ensure session is closed when function exits
+	stopCancel := context.AfterFunc(ctx, func() { session.Close() })
+	defer stopCancel()
+
+	// --- 5. Set up Terminal (PTY) for Interactive Shell ---
+	stdin, stdout, stderr := s.stdio()
+	// Raw mode is only entered now, so that prompts while connecting still echo normally
+	if s.RawMode != nil {
+		restore, err := s.RawMode.MakeRaw()
+		if err != nil {
+			log.Printf("Warning: Could not set terminal to raw mode: %v. Interactive shell features may be limited.", err)
+			// Continue without raw mode, but warn the user
+		} else {
+			// Restore the terminal state when the function exits
+			defer func() {
+				if err := restore(); err != nil {
+					log.Printf("Warning: Failed to restore terminal: %v", err)
+				}
+			}()
+		}
+	}
+
+	sizes := s.TerminalSize
+	var width, height int
+	if sizes != nil {
+		// Get the terminal size to inform the remote session
+		width, height = terminalSizeOrDefault(sizes)
+
+		// Request a pseudo-terminal (PTY)
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          1,     // enable echoing
+			ssh.TTY_OP_ISPEED: 14400, // input speed, affects Ctrl+C
+			ssh.TTY_OP_OSPEED: 14400, // output speed
+		}
+
+		if err := session.RequestPty(termType, height, width, modes); err != nil {
+			return fmt.Errorf("failed to request PTY: %w", err)
+		}
+
+	} else {
+		log.Println("No terminal. Running in non-interactive mode.")
+		// No PTY requested for non-interactive input
+	}
+
+	// Tee the session into the recording, if requested
+	var onResize func(width, height int)
+	if cfg.Recording != nil {
+		recWidth, recHeight := width, height
+		if sizes == nil {
+			recWidth, recHeight = defaultTerminalWidth, defaultTerminalHeight
+		}
+		rec, err := newRecorder(cfg, recWidth, recHeight, termType)
+		if err != nil {
+			return err
+		}
+		stdout = io.MultiWriter(stdout, rec.stream(eventOutput))
+		stderr = io.MultiWriter(stderr, rec.stream(eventOutput))
+		if cfg.Recording.RecordInput {
+			stdin = io.TeeReader(stdin, rec.stream(eventInput))
+		}
+		onResize = rec.resize
+	}
+
+	// End the session when it has been idle for too long
+	var idle *idleTimer
+	if cfg.IdleTimeout > 0 {
+		idle = newIdleTimer(cfg.IdleTimeout, func() { session.Close() })
+		defer idle.Stop()
+		stdin, stdout, stderr = idle.reader(stdin), idle.writer(stdout), idle.writer(stderr)
+	}
+
+	// --- 6. Connect Standard I/O Streams ---
+	// Connect the input to the remote session's standard input
+	session.Stdin = stdin
+	// Connect remote session's standard output to the output
+	session.Stdout = stdout
+	// Connect remote session's standard error to the error output
+	session.Stderr = stderr
+
+	// --- 7. Start the Remote Shell ---
+	if err := session.Shell(); err != nil {
+		return fmt.Errorf("failed to start remote shell: %w", err)
+	}
+
+	// Keep the remote PTY in sync with terminal resizes
+	if sizes != nil {
+		stopWatching := watchWindowSize(session, sizes, width, height, onResize)
+		defer stopWatching()
+	}
+
+	log.Println("Interactive shell started. Type 'exit' to disconnect.")
+
+	// --- 8. Wait for the Session to End ---
+	// This blocks until the remote shell session is closed (e.g., user types 'exit', connection drops)
+	if err := session.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := connectionLost(client); err != nil {
+			return err
+		}
+		if idle != nil && idle.Fired() {
+			return fmt.Errorf("%w (no activity for %s)", ErrIdleTimeout, cfg.IdleTimeout)
+		}
+		// Check if the error is just a non-zero exit status from the remote command/shell
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			log.Printf("Session ended with non-zero exit status: %d", exitErr.ExitStatus())
+			// Depending on requirements, you might return this error or nil
+			return nil // Often, a non-zero shell exit isn't treated as a tool failure
+		}
+		return fmt.Errorf("SSH session ended with unexpected error: %w", err)
+	}
+
+	log.Println("SSH session disconnected gracefully.")
+
+	return nil // Indicate successful connection and session handling
+}
+
+// stdio returns the streams of s, with no input and discarded output for those not set.
+func (s *Session) stdio() (io.Reader, io.Writer, io.Writer) {
+	var (
+		stdin  io.Reader = strings.NewReader("")
+		stdout io.Writer = io.Discard
+		stderr io.Writer = io.Discard
+	)
+	if s.Stdin != nil {
+		stdin = s.Stdin
+	}
+	if s.Stdout != nil {
+		stdout = s.Stdout
+	}
+	if s.Stderr != nil {
+		stderr = s.Stderr
+	}
+	return stdin, stdout, stderr
+}
+
+// withPrompts returns cfg and its jump hosts with the prompts they lack asking on the
+// streams of s (see Session).
+func (s *Session) withPrompts(cfg SSHConfig) SSHConfig {
+	w := s.Stderr
+	if w == nil {
+		w = s.Stdout
+	}
+	if s.Stdin == nil || w == nil {
+		return cfg
+	}
+	p := &streamPrompter{mu: new(sync.Mutex), r: s.Stdin, w: w}
+	if f, ok := s.Stdin.(*os.File); ok {
+		fd := int(f.Fd())
+		if !term.IsTerminal(fd) {
+			return cfg
+		}
+		p.mu = &terminalPromptMu
+		p.readSecret = func() (string, error) {
+			b, err := term.ReadPassword(fd)
+			return string(b), err
+		}
+	}
+	return p.fill(cfg)
+}
+
+// streamPrompter asks the questions of a Session on its streams, one at a time.
+type streamPrompter struct {
+	mu         *sync.Mutex
+	r          io.Reader
+	w          io.Writer
+	readSecret func() (string, error) // Reads an answer without echo (default: a line from r)
+}
+
+// fill sets the prompts cfg and its jump hosts lack.
+func (p *streamPrompter) fill(cfg SSHConfig) SSHConfig {
+	if cfg.HostKeyPrompt == nil {
+		cfg.HostKeyPrompt = p.hostKey
+	}
+	if cfg.PassphrasePrompt == nil {
+		cfg.PassphrasePrompt = p.passphrase
+	}
+	if cfg.ChallengeResponder == nil {
+		// Password prompts are still answered with the configured password, if any
+		cfg.ChallengeResponder = p.challenge
+		if cfg.Password != "" {
+			cfg.ChallengeResponder = PasswordResponder(cfg.Password, p.challenge)
+		}
+	}
+	if len(cfg.JumpHosts) > 0 {
+		hops := make([]SSHConfig, len(cfg.JumpHosts))
+		for i, hop := range cfg.JumpHosts {
+			hops[i] = p.fill(hop)
+		}
+		cfg.JumpHosts = hops
+	}
+	return cfg
+}
+
+func (p *streamPrompter) secret() (string, error) {
+	if p.readSecret != nil {
+		return p.readSecret()
+	}
+	return readLine(p.r)
+}
+
+func (p *streamPrompter) hostKey(hostname string, remote net.Addr, key ssh.PublicKey) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return askHostKey(p.r, p.w, hostname, remote, key)
+}
+
+func (p *streamPrompter) passphrase(publicKey ssh.PublicKey) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return askPassphrase(p.w, p.secret, publicKey)
+}
+
+func (p *streamPrompter) challenge(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return promptChallenge(p.r, p.w, p.secret, name, instruction, questions, echos)
+}
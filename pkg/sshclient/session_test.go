@@ -0,0 +1,184 @@
+package sshclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// fakeRawMode is a RawMode that records when the terminal is made raw and restored.
+type fakeRawMode struct {
+	err    error
+	events []string
+}
+
+func (f *fakeRawMode) MakeRaw() (func() error, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.events = append(f.events, "raw")
+	return func() error {
+		f.events = append(f.events, "restore")
+		return nil
+	}, nil
+}
+
+func TestSession_Shell(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	// The mock shell reports its PTY and echoes one line of input
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {
+		if pty, _, isPty := s.Pty(); isPty {
+			fmt.Fprintf(s, "pty %s %dx%d\n", pty.Term, pty.Window.Width, pty.Window.Height)
+		} else {
+			fmt.Fprintln(s, "no pty")
+		}
+		fmt.Fprintln(s.Stderr(), "ready")
+		line, _ := bufio.NewReader(s).ReadString('\n')
+		fmt.Fprintf(s, "got %q\n", line)
+		s.Exit(0)
+	}, passwordFor("runner", "pw"))
+	defer stopServer()
+
+	tests := []struct {
+		name         string
+		stdin        io.Reader
+		sizes        TerminalSize
+		rawMode      *fakeRawMode
+		expectStdout string
+	}{
+		{
+			name:         "Terminal With Raw Mode",
+			stdin:        strings.NewReader("ls\n"),
+			sizes:        newFakeTerminalSize(120, 40),
+			rawMode:      &fakeRawMode{},
+			expectStdout: "pty xterm-256color 120x40\ngot \"ls\\n\"\n",
+		},
+		{
+			name:         "Raw Mode Failure Is Not Fatal",
+			stdin:        strings.NewReader("ls\n"),
+			sizes:        newFakeTerminalSize(0, 0),
+			rawMode:      &fakeRawMode{err: errors.New("not a terminal")},
+			expectStdout: "pty xterm-256color 80x24\ngot \"ls\\n\"\n",
+		},
+		{
+			name:         "Streams Without Terminal",
+			stdin:        strings.NewReader("ls\n"),
+			expectStdout: "no pty\ngot \"ls\\n\"\n",
+		},
+		{
+			name:         "No Input",
+			expectStdout: "no pty\ngot \"\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr syncBuffer
+			session := &Session{Stdin: tt.stdin, Stdout: &stdout, Stderr: &stderr, TerminalSize: tt.sizes}
+			if tt.rawMode != nil { // Avoid a non-nil interface holding a nil pointer
+				session.RawMode = tt.rawMode
+			}
+			if err := session.Shell(context.Background(), runTestConfig(t, addr)); err != nil {
+				t.Fatalf("Shell() unexpected error: %v", err)
+			}
+
+			// The mock server's PTY emulation turns "\n" into "\r\n".
+			got := strings.ReplaceAll(stdout.String(), "\r\n", "\n")
+			if got != tt.expectStdout {
+				t.Errorf("Expected stdout %q, got %q", tt.expectStdout, got)
+			}
+			if got := strings.ReplaceAll(stderr.String(), "\r\n", "\n"); got != "ready\n" {
+				t.Errorf("Expected stderr %q, got %q", "ready\n", got)
+			}
+			if tt.rawMode != nil && tt.rawMode.err == nil {
+				if strings.Join(tt.rawMode.events, ",") != "raw,restore" {
+					t.Errorf("Expected the terminal to be made raw and restored, got %v", tt.rawMode.events)
+				}
+			}
+		})
+	}
+}
+
+func TestTerminalSession(t *testing.T) {
+	// The tests do not run on a terminal, so there is no PTY or raw mode
+	session := TerminalSession()
+	if session.Stdin != os.Stdin || session.Stdout != os.Stdout || session.Stderr != os.Stderr {
+		t.Errorf("Expected the process's streams, got %+v", session)
+	}
+	if session.TerminalSize != nil || session.RawMode != nil {
+		t.Errorf("Expected no PTY or raw mode without a terminal, got %+v", session)
+	}
+}
+
+func TestSession_Prompts(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {
+		line, _ := bufio.NewReader(s).ReadString('\n')
+		fmt.Fprintf(s, "got %q\n", line)
+		s.Exit(0)
+	}, passwordFor("runner", "pw"))
+	defer stopServer()
+
+	pipe, err := os.Open(os.DevNull) // A file that is not a terminal, like piped input
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", os.DevNull, err)
+	}
+	defer pipe.Close()
+
+	tests := []struct {
+		name          string
+		stdin         io.Reader
+		expectStdout  string
+		expectStderr  string
+		errorContains string
+	}{
+		{
+			name:         "Asked On The Streams",
+			stdin:        strings.NewReader("maybe\nyes\nls\n"),
+			expectStdout: "got \"ls\\n\"\n",
+			expectStderr: "Are you sure you want to continue connecting (yes/no)? Please type 'yes' or 'no': ",
+		},
+		{name: "No Input", errorContains: "no HostKeyPrompt"},
+		{name: "Piped Input", stdin: pipe, errorContains: "no HostKeyPrompt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr syncBuffer
+			session := &Session{Stdin: tt.stdin, Stdout: &stdout, Stderr: &stderr}
+			cfg := runTestConfig(t, addr)
+			cfg.HostKeyPolicy = HostKeyAsk
+
+			err := session.Shell(context.Background(), cfg)
+			if tt.errorContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Shell() unexpected error: %v", err)
+			}
+			// The answer is not passed on to the shell, the rest of the input is
+			if stdout.String() != tt.expectStdout {
+				t.Errorf("Expected stdout %q, got %q", tt.expectStdout, stdout.String())
+			}
+			if !strings.HasSuffix(stderr.String(), tt.expectStderr) {
+				t.Errorf("Expected stderr ending with %q, got %q", tt.expectStderr, stderr.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+// pkg/sshclient/sftp.go
+
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// sftpChunkSize is the amount of data per READ/WRITE request; OpenSSH accepts up to
+	// 255 KiB but many servers only handle 32 KiB.
+	sftpChunkSize = 32 * 1024
+	// sftpMaxRequests is how many READ/WRITE requests a transfer keeps in flight, as
+	// OpenSSH does, so that throughput is not bound by the round-trip time.
+	sftpMaxRequests = 64
+)
+
+// ErrSFTPUnavailable is returned, wrapped, when the server does not provide the SFTP
+// subsystem.
+var ErrSFTPUnavailable = errors.New("SFTP is not available on the server")
+
+// SFTPClient is a client for the SFTP subsystem of an SSH connection, built on
+// github.com/pkg/sftp. Its single requests (Stat, ReadDir, ...) cannot be abandoned once
+// sent, so their context is only checked beforehand; transfers stop between chunks.
+type SFTPClient struct {
+	release func() error // Closes (or returns to its pool) the owned SSH connection (nil if not owned)
+	session *ssh.Session
+	client  *sftp.Client
+}
+
+// ConnectSFTP establishes an SSH connection using cfg and starts the SFTP subsystem.
+// Closing the returned client also closes the SSH connection (or returns it to cfg.Pool).
+func ConnectSFTP(cfg SSHConfig) (*SFTPClient, error) {
+	client, release, err := acquire(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newSFTPClient(client)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	c.release = release
+	return c, nil
+}
+
+// newSFTPClient starts the SFTP subsystem on client and negotiates the protocol version.
+func newSFTPClient(client *ssh.Client) (*SFTPClient, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to set up SFTP session: %w", err)
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to set up SFTP session: %w", err)
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to start SFTP subsystem: %w (%v)", ErrSFTPUnavailable, err)
+	}
+
+	sc, err := sftp.NewClientPipe(r, w,
+		sftp.MaxPacket(sftpChunkSize),
+		sftp.MaxConcurrentRequestsPerFile(sftpMaxRequests))
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		// Servers such as dropbear accept the request but exit if sftp-server is missing.
+		session.Close()
+		return nil, fmt.Errorf("failed to start SFTP subsystem: %w (server closed the session)", ErrSFTPUnavailable)
+	}
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to start SFTP subsystem: %w", err)
+	}
+	return &SFTPClient{session: session, client: sc}, nil
+}
+
+// Close ends the SFTP session and, if the client owns it, the SSH connection.
+func (c *SFTPClient) Close() error {
+	err := c.client.Close()
+	if sessionErr := c.session.Close(); err == nil {
+		err = sessionErr
+	}
+	if c.release != nil {
+		return c.release()
+	}
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// pathOp wraps err with the operation and path, like *fs.PathError.
+func pathOp(op, p string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &fs.PathError{Op: op, Path: p, Err: err}
+}
+
+// Stat returns information about the remote file p, following symbolic links.
+func (c *SFTPClient) Stat(ctx context.Context, p string) (fs.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, pathOp("stat", p, err)
+	}
+	info, err := c.client.Stat(p)
+	if err != nil {
+		return nil, pathOp("stat", p, err)
+	}
+	return info, nil
+}
+
+// Lstat returns information about the remote file p without following symbolic links.
+func (c *SFTPClient) Lstat(ctx context.Context, p string) (fs.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, pathOp("lstat", p, err)
+	}
+	info, err := c.client.Lstat(p)
+	if err != nil {
+		return nil, pathOp("lstat", p, err)
+	}
+	return info, nil
+}
+
+// ReadDir lists the remote directory p, excluding "." and "..".
+func (c *SFTPClient) ReadDir(ctx context.Context, p string) ([]fs.FileInfo, error) {
+	entries, err := c.client.ReadDirContext(ctx, p)
+	if err != nil {
+		return nil, pathOp("readdir", p, err)
+	}
+	return entries, nil
+}
+
+// Mkdir creates the remote directory p with the given permissions.
+func (c *SFTPClient) Mkdir(ctx context.Context, p string, perm fs.FileMode) error {
+	if err := ctx.Err(); err != nil {
+		return pathOp("mkdir", p, err)
+	}
+	if err := c.client.Mkdir(p); err != nil {
+		return pathOp("mkdir", p, err)
+	}
+	return pathOp("chmod", p, c.client.Chmod(p, perm.Perm()))
+}
+
+// RealPath returns the canonical absolute form of the remote path p ("." is the
+// remote working directory).
+func (c *SFTPClient) RealPath(ctx context.Context, p string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", pathOp("realpath", p, err)
+	}
+	resolved, err := c.client.RealPath(p)
+	return resolved, pathOp("realpath", p, err)
+}
+
+// setstat applies the permissions and modification time of info to the remote path p.
+func (c *SFTPClient) setstat(ctx context.Context, p string, info fs.FileInfo) error {
+	if err := ctx.Err(); err != nil {
+		return pathOp("setstat", p, err)
+	}
+	if err := c.client.Chmod(p, info.Mode().Perm()); err != nil {
+		return pathOp("setstat", p, err)
+	}
+	return pathOp("setstat", p, c.client.Chtimes(p, info.ModTime(), info.ModTime()))
+}
+
+// readFile copies the remote file p to w, calling progress after every chunk. Up to
+// sftpMaxRequests reads are in flight.
+func (c *SFTPClient) readFile(ctx context.Context, p string, w io.Writer, progress func(n int64)) error {
+	if err := ctx.Err(); err != nil {
+		return pathOp("open", p, err)
+	}
+	f, err := c.client.Open(p)
+	if err != nil {
+		return pathOp("open", p, err)
+	}
+	defer f.Close()
+
+	tw := &transferWriter{ctx: ctx, w: w, progress: progress}
+	if _, err := f.WriteTo(tw); err != nil {
+		if tw.err != nil {
+			return tw.err
+		}
+		return pathOp("read", p, err)
+	}
+	return nil
+}
+
+// writeFile creates or truncates the remote file p with permissions perm and copies r
+// into it, calling progress after every chunk. Up to sftpMaxRequests writes are in flight.
+func (c *SFTPClient) writeFile(ctx context.Context, p string, perm fs.FileMode, r io.Reader, progress func(n int64)) (err error) {
+	if err := ctx.Err(); err != nil {
+		return pathOp("open", p, err)
+	}
+	f, err := c.client.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return pathOp("open", p, err)
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = pathOp("close", p, closeErr)
+		}
+	}()
+	// The server creates the file with its default mode; restrict it before writing
+	if err := f.Chmod(perm.Perm()); err != nil {
+		return pathOp("chmod", p, err)
+	}
+
+	tr := &transferReader{ctx: ctx, r: r, progress: progress}
+	if _, err := f.ReadFromWithConcurrency(tr, sftpMaxRequests); err != nil {
+		if tr.err != nil {
+			return tr.err
+		}
+		return pathOp("write", p, err)
+	}
+	return nil
+}
+
+// transferWriter passes writes to w and reports them to progress until ctx is done.
+type transferWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	progress func(n int64)
+	err      error // A local error (or ctx's), as opposed to one of the server
+}
+
+func (t *transferWriter) Write(b []byte) (int, error) {
+	if t.err = t.ctx.Err(); t.err != nil {
+		return 0, t.err
+	}
+	n, err := t.w.Write(b)
+	if n > 0 {
+		t.progress(int64(n))
+	}
+	t.err = err
+	return n, err
+}
+
+// transferReader passes reads from r and reports them to progress until ctx is done.
+type transferReader struct {
+	ctx      context.Context
+	r        io.Reader
+	progress func(n int64)
+	err      error // A local error (or ctx's), as opposed to one of the server
+}
+
+func (t *transferReader) Read(b []byte) (int, error) {
+	if t.err = t.ctx.Err(); t.err != nil {
+		return 0, t.err
+	}
+	n, err := t.r.Read(b)
+	if n > 0 {
+		t.progress(int64(n))
+	}
+	if err != io.EOF {
+		t.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,419 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/pkg/sftp"
+)
+
+// sftpSubsystemOption registers github.com/pkg/sftp's server, with root as its working
+// directory, as the "sftp" subsystem.
+func sftpSubsystemOption(root string) ssh.Option {
+	return sftpSubsystemHandler(func(s ssh.Session) {
+		server, err := sftp.NewServer(s, sftp.WithServerWorkingDirectory(root))
+		if err != nil {
+			return
+		}
+		defer server.Close()
+		server.Serve()
+	})
+}
+
+// sftpSubsystemHandler registers handler as the "sftp" subsystem.
+func sftpSubsystemHandler(handler ssh.SubsystemHandler) ssh.Option {
+	return func(srv *ssh.Server) error {
+		srv.SubsystemHandlers = map[string]ssh.SubsystemHandler{"sftp": handler}
+		return nil
+	}
+}
+
+// startSFTP starts a mock SSH server with an SFTP subsystem and returns a connected
+// client and the directory the server serves.
+func startSFTP(t *testing.T) (*SFTPClient, string) {
+	t.Helper()
+	return startSFTPWith(t, sftpSubsystemOption)
+}
+
+// startSFTPWith is startSFTP, with the subsystem for the served directory registered by
+// subsystem.
+func startSFTPWith(t *testing.T, subsystem func(root string) ssh.Option) (*SFTPClient, string) {
+	t.Helper()
+	root := t.TempDir()
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {}, passwordFor("files", "pw"), subsystem(root))
+	t.Cleanup(stopServer)
+
+	c, err := ConnectSFTP(SSHConfig{
+		Address:         addr,
+		User:            "files",
+		Password:        "pw",
+		KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+		HostKeyPolicy:   HostKeyAcceptNew,
+	})
+	if err != nil {
+		t.Fatalf("ConnectSFTP() unexpected error: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, root
+}
+
+// renamingLister serves the directory root like an SFTP server listing its entries under
+// the names chosen by rename, as a hostile server could.
+type renamingLister struct {
+	root   string
+	rename func(name string) string
+}
+
+func (l renamingLister) local(r *sftp.Request) string {
+	return filepath.Join(l.root, filepath.FromSlash(r.Filepath))
+}
+
+func (l renamingLister) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	return os.Open(l.local(r))
+}
+
+func (l renamingLister) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	p := l.local(r)
+	switch r.Method {
+	case "Stat":
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		return fileInfos{info}, nil
+	case "List":
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		var infos fileInfos
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, renamedFileInfo{FileInfo: info, name: l.rename(entry.Name())})
+		}
+		return infos, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// fileInfos is a sftp.ListerAt listing its elements.
+type fileInfos []fs.FileInfo
+
+func (f fileInfos) ListAt(dst []fs.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(f)) {
+		return 0, io.EOF
+	}
+	n := copy(dst, f[offset:])
+	if n < len(dst) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// renamedFileInfo is a FileInfo with another name.
+type renamedFileInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (i renamedFileInfo) Name() string { return i.name }
+
+// writeTestFile creates a file with the given content, permissions and modification time.
+func writeTestFile(t *testing.T, name string, data []byte, perm fs.FileMode, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(name, data, perm); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	if err := os.Chmod(name, perm); err != nil {
+		t.Fatalf("Failed to chmod %s: %v", name, err)
+	}
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set times of %s: %v", name, err)
+	}
+}
+
+// checkTestFile verifies the content, permissions and modification time of a file.
+func checkTestFile(t *testing.T, name string, data []byte, perm fs.FileMode, mtime time.Time) {
+	t.Helper()
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", name, err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("%s: content mismatch (%d bytes, expected %d)", name, len(got), len(data))
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", name, err)
+	}
+	if info.Mode().Perm() != perm {
+		t.Errorf("%s: expected mode %v, got %v", name, perm, info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("%s: expected mtime %v, got %v", name, mtime, info.ModTime())
+	}
+}
+
+func TestSFTPClient_UploadDownload(t *testing.T) {
+	c, root := startSFTP(t)
+	ctx := context.Background()
+	local := t.TempDir()
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	data := bytes.Repeat([]byte("jet-access "), 10000) // Several chunks
+
+	src := filepath.Join(local, "notes.txt")
+	writeTestFile(t, src, data, 0o640, mtime)
+
+	var last [2]int64
+	calls := 0
+	progress := func(path string, transferred, total int64) {
+		calls++
+		last = [2]int64{transferred, total}
+	}
+	if err := c.Upload(ctx, src, "notes.txt", TransferOptions{Progress: progress}); err != nil {
+		t.Fatalf("Upload() unexpected error: %v", err)
+	}
+	checkTestFile(t, filepath.Join(root, "notes.txt"), data, 0o640, mtime)
+	if calls < 3 || last != [2]int64{int64(len(data)), int64(len(data))} {
+		t.Errorf("Expected several progress calls ending at %d/%d, got %d calls ending at %v", len(data), len(data), calls, last)
+	}
+
+	dst := filepath.Join(local, "copy.txt")
+	if err := c.Download(ctx, "notes.txt", dst, TransferOptions{}); err != nil {
+		t.Fatalf("Download() unexpected error: %v", err)
+	}
+	checkTestFile(t, dst, data, 0o640, mtime)
+
+	// An existing directory as the destination receives the file under its own name.
+	if err := c.Download(ctx, "notes.txt", local+"/", TransferOptions{}); err != nil {
+		t.Fatalf("Download() into directory unexpected error: %v", err)
+	}
+	checkTestFile(t, filepath.Join(local, "notes.txt"), data, 0o640, mtime)
+}
+
+func TestSFTPClient_Recursive(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	c, root := startSFTP(t)
+	ctx := context.Background()
+	local := t.TempDir()
+	mtime := time.Date(2023, 11, 5, 8, 30, 0, 0, time.UTC)
+
+	tree := filepath.Join(local, "site")
+	if err := os.MkdirAll(filepath.Join(tree, "assets"), 0o755); err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	writeTestFile(t, filepath.Join(tree, "index.html"), []byte("<h1>hi</h1>"), 0o644, mtime)
+	writeTestFile(t, filepath.Join(tree, "assets", "run.sh"), []byte("#!/bin/sh\n"), 0o755, mtime)
+	if err := os.Symlink("index.html", filepath.Join(tree, "link.html")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := os.Chtimes(filepath.Join(tree, "assets"), mtime, mtime); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+
+	if err := c.Upload(ctx, tree, "deploy", TransferOptions{}); err == nil || !strings.Contains(err.Error(), "recursive") {
+		t.Errorf("Expected error containing 'recursive', but got: %v", err)
+	}
+	if err := c.Mkdir(ctx, "deploy", 0o755); err != nil {
+		t.Fatalf("Mkdir() unexpected error: %v", err)
+	}
+	if err := c.Upload(ctx, tree, "deploy", TransferOptions{Recursive: true}); err != nil {
+		t.Fatalf("Upload() unexpected error: %v", err)
+	}
+	checkTestFile(t, filepath.Join(root, "deploy", "site", "index.html"), []byte("<h1>hi</h1>"), 0o644, mtime)
+	checkTestFile(t, filepath.Join(root, "deploy", "site", "assets", "run.sh"), []byte("#!/bin/sh\n"), 0o755, mtime)
+	if _, err := os.Lstat(filepath.Join(root, "deploy", "site", "link.html")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected symlink to be skipped, got: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(root, "deploy", "site", "assets")); err != nil || !info.ModTime().Equal(mtime) {
+		t.Errorf("Expected directory mtime %v to be preserved, got %v (err: %v)", mtime, info.ModTime(), err)
+	}
+
+	back := filepath.Join(t.TempDir(), "restored")
+	if err := c.Download(ctx, "deploy/site", back, TransferOptions{Recursive: true}); err != nil {
+		t.Fatalf("Download() unexpected error: %v", err)
+	}
+	checkTestFile(t, filepath.Join(back, "index.html"), []byte("<h1>hi</h1>"), 0o644, mtime)
+	checkTestFile(t, filepath.Join(back, "assets", "run.sh"), []byte("#!/bin/sh\n"), 0o755, mtime)
+}
+
+func TestSFTPClient_DownloadHostileNames(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	// pkg/sftp skips "." and ".." and keeps only the last element of other listed names, so
+	// those cannot escape the download directory; the names it passes on as they are must
+	// be rejected.
+	tests := []struct {
+		name        string
+		listedName  string // The name the server lists for the file "payload"
+		expectError bool
+	}{
+		{name: "Parent Directory", listedName: "../escaped"},
+		{name: "Nested Traversal", listedName: "a/../../escaped"},
+		{name: "Absolute Path", listedName: "/tmp/escaped"},
+		{name: "Dot Dot", listedName: ".."},
+		{name: "Backslash", listedName: "..\\escaped", expectError: true},
+		{name: "Empty", listedName: "", expectError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, root := startSFTPWith(t, func(root string) ssh.Option {
+				lister := renamingLister{root: root, rename: func(name string) string {
+					if name == "payload" {
+						return tt.listedName
+					}
+					return name
+				}}
+				return sftpSubsystemHandler(func(s ssh.Session) {
+					server := sftp.NewRequestServer(s, sftp.Handlers{FileGet: lister, FileList: lister})
+					defer server.Close()
+					server.Serve()
+				})
+			})
+			if err := os.Mkdir(filepath.Join(root, "tree"), 0o755); err != nil {
+				t.Fatalf("Failed to create tree: %v", err)
+			}
+			writeTestFile(t, filepath.Join(root, "tree", "payload"), []byte("pwned"), 0o644, time.Now())
+
+			base := t.TempDir()
+			err := c.Download(context.Background(), "tree", filepath.Join(base, "out"), TransferOptions{Recursive: true})
+			if tt.expectError && (err == nil || !strings.Contains(err.Error(), "invalid file name")) {
+				t.Errorf("Expected error containing 'invalid file name', but got: %v", err)
+			}
+			entries, _ := os.ReadDir(filepath.Join(base, "out"))
+			others, _ := os.ReadDir(base)
+			if len(others) != 1 || len(entries) > 1 || len(entries) == 1 && entries[0].Name() != "escaped" {
+				t.Errorf("Expected nothing written outside the download directory, got %v and %v", others, entries)
+			}
+		})
+	}
+}
+
+func TestSFTPClient_StatReadDir(t *testing.T) {
+	c, root := startSFTP(t)
+	ctx := context.Background()
+	mtime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Mkdir(filepath.Join(root, "logs"), 0o750); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	writeTestFile(t, filepath.Join(root, "logs", "a.log"), []byte("aaa"), 0o600, mtime)
+	writeTestFile(t, filepath.Join(root, "logs", "b.log"), []byte("bbbbb"), 0o644, mtime)
+
+	tests := []struct {
+		name        string
+		path        string
+		expectDir   bool
+		expectSize  int64
+		expectMode  fs.FileMode
+		expectError error
+	}{
+		{name: "File", path: "logs/b.log", expectSize: 5, expectMode: 0o644},
+		{name: "Directory", path: "logs", expectDir: true, expectMode: fs.ModeDir | 0o750},
+		{name: "Missing", path: "nope", expectError: fs.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := c.Stat(ctx, tt.path)
+			if tt.expectError != nil {
+				if !errors.Is(err, tt.expectError) {
+					t.Errorf("Expected error matching %v, got: %v", tt.expectError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Stat() unexpected error: %v", err)
+			}
+			if info.IsDir() != tt.expectDir || info.Mode() != tt.expectMode {
+				t.Errorf("Expected mode %v, got %v", tt.expectMode, info.Mode())
+			}
+			if !tt.expectDir && (info.Size() != tt.expectSize || !info.ModTime().Equal(mtime)) {
+				t.Errorf("Expected size %d and mtime %v, got %d and %v", tt.expectSize, mtime, info.Size(), info.ModTime())
+			}
+		})
+	}
+
+	entries, err := c.ReadDir(ctx, "logs")
+	if err != nil {
+		t.Fatalf("ReadDir() unexpected error: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "a.log,b.log" {
+		t.Errorf("Expected entries a.log,b.log, got %v", names)
+	}
+
+	expectPath := path.Join(filepath.ToSlash(root), "logs")
+	if p, err := c.RealPath(ctx, "logs/../logs"); err != nil || p != expectPath {
+		t.Errorf("Expected RealPath %s, got %q (err: %v)", expectPath, p, err)
+	}
+}
+
+func TestSFTPClient_Cancel(t *testing.T) {
+	c, root := startSFTP(t)
+	local := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "big.bin"), make([]byte, 10*sftpChunkSize), 0o644, time.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	progress := func(path string, transferred, total int64) {
+		if transferred > 0 {
+			cancel()
+		}
+	}
+	dst := filepath.Join(local, "big.bin")
+	err := c.Download(ctx, "big.bin", dst, TransferOptions{Progress: progress})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got: %v", err)
+	}
+	if _, err := os.Stat(dst); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected partial download to be removed, got: %v", err)
+	}
+
+	// The client stays usable after a cancelled transfer.
+	if _, err := c.Stat(context.Background(), "big.bin"); err != nil {
+		t.Errorf("Stat() after cancel unexpected error: %v", err)
+	}
+}
+
+func TestConnectSFTP_NoSubsystem(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {}, passwordFor("files", "pw"))
+	defer stopServer()
+
+	_, err := ConnectSFTP(SSHConfig{
+		Address:         addr,
+		User:            "files",
+		Password:        "pw",
+		KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+		HostKeyPolicy:   HostKeyAcceptNew,
+	})
+	if err == nil || !strings.Contains(err.Error(), "failed to start SFTP subsystem") {
+		t.Errorf("Expected error containing 'failed to start SFTP subsystem', but got: %v", err)
+	}
+}
@@ -0,0 +1,343 @@
+// pkg/sshclient/socks.go
+
+package sshclient
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SOCKS5 protocol constants (RFC 1928 and RFC 1929).
+const (
+	socksVersion         = 0x05
+	socksAuthVersion     = 0x01
+	socksMethodNoAuth    = 0x00
+	socksMethodUserPass  = 0x02
+	socksMethodNoAccept  = 0xff
+	socksCmdConnect      = 0x01
+	socksAtypIPv4        = 0x01
+	socksAtypDomain      = 0x03
+	socksAtypIPv6        = 0x04
+	socksSucceeded       = 0x00
+	socksGeneralFailure  = 0x01
+	socksNotAllowed      = 0x02
+	socksConnRefused     = 0x05
+	socksCmdNotSupported = 0x07
+	socksAtypNotSupport  = 0x08
+
+	// socksHandshakeTimeout bounds how long a client may take to send its request.
+	socksHandshakeTimeout = 30 * time.Second
+)
+
+// SOCKSOptions configures a dynamic (SOCKS5) forward.
+type SOCKSOptions struct {
+	// Optional: require RFC 1929 username/password authentication, e.g. on shared machines.
+	Username string
+	Password string
+
+	// Optional: destinations clients may connect to. AllowedNetworks lists CIDRs such as
+	// "10.0.0.0/8" for IP destinations, and AllowedHosts lists host name patterns in
+	// path.Match syntax, such as "*.internal", for named ones. Host names are resolved by
+	// the SSH server, so they cannot be checked against the networks: a name is allowed if
+	// it matches a pattern, whatever address it resolves to. With both empty, any
+	// destination is allowed.
+	AllowedNetworks []string
+	AllowedHosts    []string
+}
+
+// socksServer holds the parsed SOCKSOptions.
+type socksServer struct {
+	username, password string
+	allowed            []*net.IPNet
+	allowedHosts       []string
+	dial               func(addr string) (net.Conn, error)
+}
+
+// ParseDynamicForward parses an ssh -D style specification, [bind_address:]port.
+// Without a bind address the proxy listens on localhost only.
+func ParseDynamicForward(spec string) (Forward, error) {
+	host, port := "localhost", spec
+	if i := lastColon(spec); i >= 0 {
+		host, port = spec[:i], spec[i+1:]
+		if len(host) > 1 && host[0] == '[' && host[len(host)-1] == ']' {
+			host = host[1 : len(host)-1]
+		}
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return Forward{}, fmt.Errorf("invalid dynamic forward %q: bad port %q", spec, port)
+	}
+	return Forward{LocalAddr: net.JoinHostPort(host, port), Dynamic: true}, nil
+}
+
+// lastColon returns the index of the last ':' outside square brackets, or -1.
+func lastColon(s string) int {
+	for i := len(s) - 1; i >= 0; i-- {
+		switch s[i] {
+		case ':':
+			return i
+		case ']':
+			return -1
+		}
+	}
+	return -1
+}
+
+// ForwardDynamic starts a SOCKS5 proxy on listenAddr that opens every CONNECT request
+// through the SSH server, the equivalent of ssh -D. Host names in requests are resolved
+// by the SSH server, so names from the private network work.
+func (f *PortForwarder) ForwardDynamic(listenAddr string, opts SOCKSOptions) (*Tunnel, error) {
+	if (opts.Username == "") != (opts.Password == "") {
+		return nil, errors.New("SOCKS username and password must be set together")
+	}
+	server := &socksServer{
+		username: opts.Username,
+		password: opts.Password,
+		dial:     func(addr string) (net.Conn, error) { return f.sshClient().Dial("tcp", addr) },
+	}
+	for _, cidr := range opts.AllowedNetworks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed network %q: %w", cidr, err)
+		}
+		server.allowed = append(server.allowed, network)
+	}
+	for _, pattern := range opts.AllowedHosts {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, fmt.Errorf("invalid allowed host pattern %q", pattern)
+		}
+		server.allowedHosts = append(server.allowedHosts, strings.ToLower(pattern))
+	}
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
+	}
+	return f.start(Forward{LocalAddr: listenAddr, Dynamic: true}, listener, server.connect)
+}
+
+// connect runs the SOCKS5 handshake on conn and returns the connection to the requested
+// destination. The client has been sent the reply by the time it returns.
+func (s *socksServer) connect(conn net.Conn) (net.Conn, error) {
+	if err := conn.SetDeadline(time.Now().Add(socksHandshakeTimeout)); err != nil {
+		return nil, err
+	}
+	if err := s.negotiate(conn); err != nil {
+		return nil, fmt.Errorf("SOCKS handshake failed: %w", err)
+	}
+
+	addr, ip, err := readSOCKSRequest(conn)
+	if err != nil {
+		var replyErr *socksReplyError
+		if errors.As(err, &replyErr) {
+			writeSOCKSReply(conn, replyErr.code)
+		}
+		return nil, fmt.Errorf("SOCKS request failed: %w", err)
+	}
+	if !s.allows(addr, ip) {
+		writeSOCKSReply(conn, socksNotAllowed)
+		return nil, fmt.Errorf("SOCKS request to %s denied: destination is not allowed", addr)
+	}
+
+	target, err := s.dial(addr)
+	if err != nil {
+		writeSOCKSReply(conn, socksDialReplyCode(err))
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	if err := writeSOCKSReply(conn, socksSucceeded); err != nil {
+		target.Close()
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		target.Close()
+		return nil, err
+	}
+	return target, nil
+}
+
+// negotiate reads the client greeting, selects an authentication method and, for
+// username/password, verifies the credentials.
+func (s *socksServer) negotiate(conn net.Conn) error {
+	var header [2]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return err
+	}
+	if header[0] != socksVersion {
+		return fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return err
+	}
+
+	want := byte(socksMethodNoAuth)
+	if s.username != "" {
+		want = socksMethodUserPass
+	}
+	offered := false
+	for _, m := range methods {
+		if m == want {
+			offered = true
+			break
+		}
+	}
+	if !offered {
+		conn.Write([]byte{socksVersion, socksMethodNoAccept})
+		return errors.New("client does not support the required authentication method")
+	}
+	if _, err := conn.Write([]byte{socksVersion, want}); err != nil {
+		return err
+	}
+	if want == socksMethodUserPass {
+		return s.authenticate(conn)
+	}
+	return nil
+}
+
+// authenticate runs the RFC 1929 username/password subnegotiation.
+func (s *socksServer) authenticate(conn net.Conn) error {
+	var version [1]byte
+	if _, err := io.ReadFull(conn, version[:]); err != nil {
+		return err
+	}
+	if version[0] != socksAuthVersion {
+		return fmt.Errorf("unsupported SOCKS auth version %d", version[0])
+	}
+	username, err := readSOCKSString(conn)
+	if err != nil {
+		return err
+	}
+	password, err := readSOCKSString(conn)
+	if err != nil {
+		return err
+	}
+
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(s.username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) == 1
+	if !userOK || !passOK {
+		conn.Write([]byte{socksAuthVersion, 0x01})
+		return fmt.Errorf("invalid SOCKS credentials for user %q", username)
+	}
+	_, err = conn.Write([]byte{socksAuthVersion, 0x00})
+	return err
+}
+
+// readSOCKSString reads a length-prefixed string.
+func readSOCKSString(r io.Reader) (string, error) {
+	var n [1]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return "", err
+	}
+	b := make([]byte, n[0])
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// socksReplyError is a request error that is reported to the client with code.
+type socksReplyError struct {
+	code byte
+	msg  string
+}
+
+func (e *socksReplyError) Error() string { return e.msg }
+
+// readSOCKSRequest reads a CONNECT request and returns the destination as host:port,
+// plus its IP if the client sent an address rather than a host name.
+func readSOCKSRequest(r io.Reader) (string, net.IP, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", nil, err
+	}
+	if header[0] != socksVersion {
+		return "", nil, fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	if header[1] != socksCmdConnect {
+		return "", nil, &socksReplyError{socksCmdNotSupported, fmt.Sprintf("unsupported SOCKS command %d", header[1])}
+	}
+
+	var host string
+	var ip net.IP
+	switch header[3] {
+	case socksAtypIPv4, socksAtypIPv6:
+		size := net.IPv4len
+		if header[3] == socksAtypIPv6 {
+			size = net.IPv6len
+		}
+		ip = make(net.IP, size)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", nil, err
+		}
+		host = ip.String()
+	case socksAtypDomain:
+		name, err := readSOCKSString(r)
+		if err != nil {
+			return "", nil, err
+		}
+		host = name
+		ip = net.ParseIP(name)
+	default:
+		return "", nil, &socksReplyError{socksAtypNotSupport, fmt.Sprintf("unsupported SOCKS address type %d", header[3])}
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", nil, err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), ip, nil
+}
+
+// allows reports whether the destination addr ("host:port") is permitted by the
+// allowlist: its ip by the allowed networks or, for a host name (a nil ip), the name by
+// the allowed host patterns.
+func (s *socksServer) allows(addr string, ip net.IP) bool {
+	if len(s.allowed) == 0 && len(s.allowedHosts) == 0 {
+		return true
+	}
+	if ip != nil {
+		for _, network := range s.allowed {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range s.allowedHosts {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// socksDialReplyCode maps an error from opening a direct-tcpip channel to a SOCKS reply.
+func socksDialReplyCode(err error) byte {
+	var openErr *ssh.OpenChannelError
+	if errors.As(err, &openErr) {
+		switch openErr.Reason {
+		case ssh.Prohibited:
+			return socksNotAllowed
+		case ssh.ConnectionFailed:
+			return socksConnRefused
+		}
+	}
+	return socksGeneralFailure
+}
+
+// writeSOCKSReply sends a reply with the given code and an unspecified bound address.
+func writeSOCKSReply(w io.Writer, code byte) error {
+	_, err := w.Write([]byte{socksVersion, code, 0x00, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,216 @@
+package sshclient
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDynamicForward(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        string
+		expectAddr  string
+		expectError bool
+	}{
+		{name: "Port Only", spec: "1080", expectAddr: "localhost:1080"},
+		{name: "Bind Address", spec: "0.0.0.0:1080", expectAddr: "0.0.0.0:1080"},
+		{name: "IPv6 Bind Address", spec: "[::1]:1080", expectAddr: "[::1]:1080"},
+		{name: "Bad Port", spec: "localhost:socks", expectError: true},
+		{name: "Empty", spec: "", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fw, err := ParseDynamicForward(tt.spec)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected an error, got %+v", fw)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if fw.LocalAddr != tt.expectAddr || !fw.Dynamic {
+				t.Errorf("Expected dynamic forward on %s, got %+v", tt.expectAddr, fw)
+			}
+		})
+	}
+}
+
+// socksRequest is a SOCKS5 client request used by the tests.
+type socksRequest struct {
+	methods  []byte // Offered authentication methods
+	username string
+	password string
+	cmd      byte
+	host     string // IP literal (sent as an address) or host name
+	port     int
+}
+
+// socksDial runs a SOCKS5 handshake against proxy and returns the connection and the
+// server's final status: the reply code, or the method/auth status if it failed earlier.
+func socksDial(t *testing.T, proxy string, req socksRequest) (net.Conn, byte) {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxy)
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			t.Fatalf("Failed to read from proxy: %v", err)
+		}
+		return b
+	}
+
+	conn.Write(append([]byte{socksVersion, byte(len(req.methods))}, req.methods...))
+	method := read(2)[1]
+	if method == socksMethodNoAccept {
+		return conn, method
+	}
+	if method == socksMethodUserPass {
+		msg := []byte{socksAuthVersion, byte(len(req.username))}
+		msg = append(msg, req.username...)
+		msg = append(msg, byte(len(req.password)))
+		msg = append(msg, req.password...)
+		conn.Write(msg)
+		if status := read(2)[1]; status != 0 {
+			return conn, status
+		}
+	}
+
+	msg := []byte{socksVersion, req.cmd, 0x00}
+	if ip := net.ParseIP(req.host); ip != nil && ip.To4() != nil {
+		msg = append(append(msg, socksAtypIPv4), ip.To4()...)
+	} else {
+		msg = append(append(msg, socksAtypDomain, byte(len(req.host))), req.host...)
+	}
+	msg = binary.BigEndian.AppendUint16(msg, uint16(req.port))
+	conn.Write(msg)
+	reply := read(10)
+	conn.SetDeadline(time.Time{})
+	return &bufferedConn{conn, r}, reply[1]
+}
+
+// bufferedConn reads through r, which may hold data already read from Conn.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func TestPortForwarder_ForwardDynamic(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	f := startForwarder(t)
+	echoAddr := startEchoServer(t, "private:")
+	_, portStr, _ := net.SplitHostPort(echoAddr)
+	echoPort, _ := strconv.Atoi(portStr)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	_, closedPortStr, _ := net.SplitHostPort(l.Addr().String())
+	closedPort, _ := strconv.Atoi(closedPortStr)
+	l.Close()
+
+	start := func(opts SOCKSOptions) string {
+		tunnel, err := f.ForwardDynamic("127.0.0.1:0", opts)
+		if err != nil {
+			t.Fatalf("ForwardDynamic() unexpected error: %v", err)
+		}
+		return tunnel.Addr().String()
+	}
+	open := start(SOCKSOptions{})
+	withAuth := start(SOCKSOptions{Username: "alice", Password: "s3cret"})
+	loopbackOnly := start(SOCKSOptions{AllowedNetworks: []string{"127.0.0.0/8"}})
+	privateOnly := start(SOCKSOptions{AllowedNetworks: []string{"10.0.0.0/8", "192.168.0.0/16"}})
+	localNames := start(SOCKSOptions{AllowedNetworks: []string{"10.0.0.0/8"}, AllowedHosts: []string{"*.internal", "LOCAL*"}})
+
+	noAuth := []byte{socksMethodNoAuth}
+	tests := []struct {
+		name         string
+		proxy        string
+		req          socksRequest
+		expectStatus byte
+	}{
+		{name: "Connect By IP", proxy: open, req: socksRequest{methods: noAuth, cmd: socksCmdConnect, host: "127.0.0.1", port: echoPort}},
+		{name: "Connect By Name Resolved Remotely", proxy: open, req: socksRequest{methods: noAuth, cmd: socksCmdConnect, host: "localhost", port: echoPort}},
+		{name: "Connection Refused", proxy: open, req: socksRequest{methods: noAuth, cmd: socksCmdConnect, host: "127.0.0.1", port: closedPort}, expectStatus: socksConnRefused},
+		{name: "Unsupported Command", proxy: open, req: socksRequest{methods: noAuth, cmd: 0x02, host: "127.0.0.1", port: echoPort}, expectStatus: socksCmdNotSupported},
+		{
+			name:  "Valid Credentials",
+			proxy: withAuth,
+			req:   socksRequest{methods: []byte{socksMethodNoAuth, socksMethodUserPass}, username: "alice", password: "s3cret", cmd: socksCmdConnect, host: "127.0.0.1", port: echoPort},
+		},
+		{
+			name:         "Invalid Credentials",
+			proxy:        withAuth,
+			req:          socksRequest{methods: []byte{socksMethodUserPass}, username: "alice", password: "wrong", cmd: socksCmdConnect, host: "127.0.0.1", port: echoPort},
+			expectStatus: 0x01,
+		},
+		{name: "Client Without Auth Support", proxy: withAuth, req: socksRequest{methods: noAuth}, expectStatus: socksMethodNoAccept},
+		{name: "Allowed Network", proxy: loopbackOnly, req: socksRequest{methods: noAuth, cmd: socksCmdConnect, host: "127.0.0.1", port: echoPort}},
+		{name: "Disallowed Network", proxy: privateOnly, req: socksRequest{methods: noAuth, cmd: socksCmdConnect, host: "127.0.0.1", port: echoPort}, expectStatus: socksNotAllowed},
+		{name: "Host Name Without Allowed Hosts", proxy: loopbackOnly, req: socksRequest{methods: noAuth, cmd: socksCmdConnect, host: "localhost", port: echoPort}, expectStatus: socksNotAllowed},
+		{name: "Allowed Host Pattern", proxy: localNames, req: socksRequest{methods: noAuth, cmd: socksCmdConnect, host: "LocalHost", port: echoPort}},
+		{name: "Disallowed Host Name", proxy: localNames, req: socksRequest{methods: noAuth, cmd: socksCmdConnect, host: "example.com", port: echoPort}, expectStatus: socksNotAllowed},
+		{name: "IP Not Matched By Host Patterns", proxy: localNames, req: socksRequest{methods: noAuth, cmd: socksCmdConnect, host: "127.0.0.1", port: echoPort}, expectStatus: socksNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, status := socksDial(t, tt.proxy, tt.req)
+			defer conn.Close()
+			if status != tt.expectStatus {
+				t.Fatalf("Expected status %#x, got %#x", tt.expectStatus, status)
+			}
+			if status != socksSucceeded {
+				return
+			}
+			if got := roundTrip(t, conn, bufio.NewReader(conn), "hello"); got != "private:hello" {
+				t.Errorf("Expected %q, got %q", "private:hello", got)
+			}
+		})
+	}
+}
+
+func TestPortForwarder_ForwardDynamicOptions(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	f := startForwarder(t)
+	tests := []struct {
+		name          string
+		opts          SOCKSOptions
+		errorContains string
+	}{
+		{name: "Username Without Password", opts: SOCKSOptions{Username: "alice"}, errorContains: "must be set together"},
+		{name: "Invalid CIDR", opts: SOCKSOptions{AllowedNetworks: []string{"10.0.0.0/33"}}, errorContains: "invalid allowed network"},
+		{name: "Invalid Host Pattern", opts: SOCKSOptions{AllowedHosts: []string{"db[.internal"}}, errorContains: "invalid allowed host pattern"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := f.ForwardDynamic("127.0.0.1:0", tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+				t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+			}
+		})
+	}
+}
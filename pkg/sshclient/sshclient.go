@@ -3,12 +3,13 @@
 package sshclient
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"os"
+	"time"
 
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/term" // For interacting with the terminal (getting size, setting raw mode)
+	"golang.org/x/crypto/ssh/agent"
 )
 
 // SSHConfig holds the necessary parameters to establish an SSH connection.
@@ -19,167 +20,166 @@ type SSHConfig struct {
 	Key        []byte // Optional: Content of the private SSH key (can be nil or empty)
 	Passphrase string // Optional: Passphrase for the private key (can be empty)
 	Password   string // Optional: Password for password authentication (can be empty, alternative to Key)
+
+	// Encrypted keys (OpenSSH bcrypt or legacy PEM encryption)
+	PassphrasePrompt PassphrasePromptFunc // Optional: asks for the passphrase of Key when Passphrase is empty (default: the Session's streams, see Session)
+	KeyCache         *KeyCache            // Optional: keeps Key once decrypted after prompting, for the configurations sharing it (default: ask every time)
+
+	// Certificate authentication (e.g. a key signed by Vault's SSH secrets engine)
+	Signer      ssh.Signer // Optional: pre-built signer, e.g. an in-memory ephemeral key (used instead of Key)
+	Certificate []byte     // Optional: OpenSSH user certificate for Key/Signer, in authorized_keys format
+
+	// Keyboard-interactive (PAM, MFA) authentication
+	ChallengeResponder ChallengeResponder // Optional: answers prompts (default: Password for "Password:" prompts, the Session's streams for the rest)
+
+	// ssh-agent authentication and forwarding
+	UseAgent     bool        // Optional: authenticate with the keys held by the agent
+	ForwardAgent bool        // Optional: forward the agent into the remote session
+	AgentSocket  string      // Optional: agent socket path (default: $SSH_AUTH_SOCK)
+	Agent        agent.Agent // Optional: agent to use instead of the local one, e.g. from NewKeyringAgent
+
+	// Timeouts. Cancelling the context passed to ConnectAndShellContext or Run also
+	// aborts the connection.
+	DialTimeout      time.Duration // Optional: limit on establishing the TCP connection (default: 30s)
+	HandshakeTimeout time.Duration // Optional: limit on the SSH handshake and authentication, including any prompts (default: none)
+	IdleTimeout      time.Duration // Optional: end ConnectAndShell sessions after this long without input or output (default: none)
+
+	// Keepalives (like OpenSSH's ServerAliveInterval and ServerAliveCountMax). A connection
+	// whose server stops answering is closed and reported as ErrConnectionLost.
+	KeepaliveInterval  time.Duration // Optional: send keepalive@openssh.com this often (default: none)
+	KeepaliveMaxMissed int           // Optional: unanswered keepalives before giving up (default: 3)
+
+	// Connection sharing
+	Pool        *Pool  // Optional: borrow the connection from this pool, shared by user@host:port
+	ControlPath string // Optional: reuse the connection of a control master on this socket, if one is running
+
+	// Cryptographic algorithms (like OpenSSH's KexAlgorithms, Ciphers, MACs and HostKeyAlgorithms).
+	// Lists that are set replace the policy's, in order of preference.
+	AlgorithmPolicy   AlgorithmPolicy // Optional: AlgorithmsModern, AlgorithmsCompatible or AlgorithmsFIPS (default: the x/crypto defaults)
+	KeyExchanges      []string        // Optional: key exchange algorithms
+	Ciphers           []string        // Optional: ciphers
+	MACs              []string        // Optional: MACs (not used with AEAD ciphers such as AES-GCM)
+	HostKeyAlgorithms []string        // Optional: host key algorithms; those of keys in known_hosts are preferred
+
+	// Jump hosts (ProxyJump)
+	JumpHosts []SSHConfig // Optional: hosts to tunnel through, in order; each with its own credentials and host key policy
+
+	// Session recording (ConnectAndShell)
+	Recording *RecordingOptions // Optional: record the session as an asciicast v2 file
+
+	// Host key verification
+	KnownHostsFiles []string          // Optional: known_hosts files to verify against (default: ~/.ssh/known_hosts); new keys go to the first
+	HostKeyPolicy   HostKeyPolicy     // Optional: how to treat hosts missing from known_hosts (default: HostKeyStrict)
+	HostKeyPrompt   HostKeyPromptFunc // Optional: asks the user about unknown hosts in HostKeyAsk mode (default: the Session's streams)
+
+	// Host key pinning (e.g. from the Vault host secret). When set, known_hosts is not consulted
+	// and any other key is rejected with a *HostKeyMismatchError.
+	HostKeys            []string // Optional: pinned public keys in authorized_keys or known_hosts format
+	HostKeyFingerprints []string // Optional: pinned fingerprints ("SHA256:..." or "MD5:aa:bb:...")
 }
 
-// ConnectAndShell establishes an SSH connection using the provided configuration
-// and starts an interactive shell session, connecting local Stdin/Stdout/Stderr.
-func ConnectAndShell(cfg SSHConfig) error {
+// clientConfig builds the ssh.ClientConfig (authentication, host key verification and algorithms) for cfg.
+// ag is the ssh-agent to authenticate with when cfg.UseAgent is set (may be nil).
+func (cfg SSHConfig) clientConfig(ag agent.Agent) (*ssh.ClientConfig, error) {
 	// --- 1. Prepare Authentication Methods ---
-	authMethods := []ssh.AuthMethod{}
-
-	// Add key-based authentication if key content is provided
-	if len(cfg.Key) > 0 {
-		signer, err := ssh.ParsePrivateKey(cfg.Key)
-		if err != nil {
-			// If parsing fails, try with passphrase if provided
-			if cfg.Passphrase != "" {
-				rawKey, err := ssh.ParseRawPrivateKeyWithPassphrase(cfg.Key, []byte(cfg.Passphrase))
-				if err != nil {
-					return fmt.Errorf("failed to parse private key with passphrase: %w", err)
-				}
-				signer, err = ssh.NewSignerFromKey(rawKey)
-				if err != nil {
-					return fmt.Errorf("failed to create signer from parsed key: %w", err)
-				}
-
-			} else {
-				// If no passphrase was provided or passphrase also failed
-				return fmt.Errorf("failed to parse private key: %w", err)
-			}
-		}
-		authMethods = append(authMethods, ssh.PublicKeys(signer))
-	}
-
-	// Add password authentication if password is provided (Key takes precedence if both exist)
-	// A real-world scenario might prioritize key auth, but this adds password if key isn't used.
-	// You might adjust this logic based on your Vault secret structure and priority.
-	if cfg.Password != "" {
-		authMethods = append(authMethods, ssh.Password(cfg.Password))
-	}
-
-	if len(authMethods) == 0 {
-		return fmt.Errorf("no authentication methods successfully configured (no valid key or password provided)")
+	authMethods, err := cfg.authMethods(ag)
+	if err != nil {
+		return nil, err
 	}
 
 	// --- 2. Configure the SSH Client ---
-	config := &ssh.ClientConfig{
-		User: cfg.User,
-		Auth: authMethods,
-		// HostKeyCallback is CRITICAL for security.
-		// InsecureIgnoreHostKey() is DANGEROUS and should ONLY be used for initial testing/MVP validation.
-		// A proper implementation MUST verify the host key against a trusted source (e.g., known_hosts file).
-		// For this MVP, we use InsecureIgnoreHostKey() BUT IT MUST BE REPLACED.
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // !!! SECURITY RISK - DO NOT USE IN PRODUCTION !!!
-		// Recommended (Future): HostKeyCallback: ssh.KnownHosts(getKnownHostsFile()), // Implement known_hosts handling
+	// Host keys are verified against known_hosts; unknown hosts are handled per cfg.HostKeyPolicy.
+	hostKeyCallback, hostKeyAlgorithms, err := cfg.hostKeyCallback()
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up host key verification: %w", err)
 	}
 
-	log.Printf("Attempting SSH connection to %s@%s...", cfg.User, cfg.Address)
-
-	// --- 3. Establish the Connection ---
-	client, err := ssh.Dial("tcp", cfg.Address, config)
+	// --- 3. Apply the algorithm policy ---
+	algorithms, err := cfg.algorithms()
 	if err != nil {
-		return fmt.Errorf("failed to dial SSH server %s: %w", cfg.Address, err)
+		return nil, err
 	}
-	defer client.Close() // Ensure client connection is closed when function exits
 
-	log.Println("SSH connection established.")
+	return &ssh.ClientConfig{
+		Config: ssh.Config{
+			KeyExchanges: algorithms.KeyExchanges,
+			Ciphers:      algorithms.Ciphers,
+			MACs:         algorithms.MACs,
+		},
+		User:              cfg.User,
+		Auth:              authMethods,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: restrictHostKeyAlgorithms(hostKeyAlgorithms, algorithms.HostKeyAlgorithms),
+	}, nil
+}
 
-	// --- 4. Create a Session ---
-	session, err := client.NewSession()
+// dial establishes an authenticated SSH connection to cfg.Address, through cfg.JumpHosts if any.
+func dial(ctx context.Context, cfg SSHConfig) (*ssh.Client, error) {
+	via, err := dialJumpHosts(ctx, cfg.JumpHosts)
 	if err != nil {
-		return fmt.Errorf("failed to create SSH session: %w", err)
+		return nil, err
 	}
-	defer session.Close() // Ensure session is closed when function exits
-
-	// --- 5. Set up Terminal (PTY) for Interactive Shell ---
-	// Get the file descriptor for standard input
-	fd := int(os.Stdin.Fd())
-	// Check if the terminal is interactive
-	if term.IsTerminal(fd) {
-		// Put the terminal in raw mode to handle shell input correctly
-		oldState, err := term.MakeRaw(fd)
-		if err != nil {
-			log.Printf("Warning: Could not set terminal to raw mode: %v. Interactive shell features may be limited.", err)
-			// Continue without raw mode, but warn the user
-		} else {
-			// Restore the terminal state when the function exits
-			defer func() {
-				err := term.Restore(fd, oldState)
-				if err != nil {
-					log.Printf("Warning: Failed to restore terminal: %v", err)
-				}
-			}()
-		}
-
-		// Get the terminal size to inform the remote session
-		width, height, err := term.GetSize(fd)
-		if err != nil {
-			log.Printf("Warning: Could not get terminal size: %v", err)
-			width, height = 80, 24 // Use a default size if unable to get actual size
-		}
+	return dialHop(ctx, via, cfg)
+}
 
-		// Request a pseudo-terminal (PTY)
-		modes := ssh.TerminalModes{
-			ssh.ECHO:          1,     // enable echoing
-			ssh.TTY_OP_ISPEED: 14400, // input speed, affects Ctrl+C
-			ssh.TTY_OP_OSPEED: 14400, // output speed
+// dialHop establishes an authenticated SSH connection to cfg.Address, tunneled through via
+// if it is not nil. dialHop takes ownership of via: it is closed along with the new client.
+func dialHop(ctx context.Context, via *ssh.Client, cfg SSHConfig) (*ssh.Client, error) {
+	ag, agentConn, err := cfg.agent()
+	if err != nil {
+		closeClient(via)
+		return nil, err
+	}
+	release := func() {
+		if agentConn != nil {
+			agentConn.Close()
 		}
+		closeClient(via)
+	}
 
-		if err := session.RequestPty("xterm-256color", height, width, modes); err != nil { // Use a common term type
-			return fmt.Errorf("failed to request PTY: %w", err)
-		}
+	config, err := cfg.clientConfig(ag)
+	if err != nil {
+		release()
+		return nil, err
+	}
 
+	if via != nil {
+		log.Printf("Attempting SSH connection to %s@%s through jump host...", cfg.User, cfg.Address)
 	} else {
-		log.Println("Stdin is not a terminal. Running in non-interactive mode.")
-		// No PTY requested for non-interactive input
+		log.Printf("Attempting SSH connection to %s@%s...", cfg.User, cfg.Address)
 	}
 
-	// --- 6. Connect Standard I/O Streams ---
-	// Connect local standard input to the remote session's standard input
-	session.Stdin = os.Stdin
-	// Connect remote session's standard output to local standard output
-	session.Stdout = os.Stdout
-	// Connect remote session's standard error to local standard error
-	session.Stderr = os.Stderr
-
-	// --- 7. Start the Remote Shell ---
-	if err := session.Shell(); err != nil {
-		return fmt.Errorf("failed to start remote shell: %w", err)
+	client, err := connect(ctx, via, cfg, config)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("failed to dial SSH server %s: %w", cfg.Address, err)
 	}
 
-	log.Println("Interactive shell started. Type 'exit' to disconnect.")
-
-	// --- 8. Wait for the Session to End ---
-	// This blocks until the remote shell session is closed (e.g., user types 'exit', connection drops)
-	if err := session.Wait(); err != nil {
-		// Check if the error is just a non-zero exit status from the remote command/shell
-		if exitErr, ok := err.(*ssh.ExitError); ok {
-			log.Printf("Session ended with non-zero exit status: %d", exitErr.ExitStatus())
-			// Depending on requirements, you might return this error or nil
-			return nil // Often, a non-zero shell exit isn't treated as a tool failure
+	if cfg.ForwardAgent {
+		if err := forwardAgent(client, ag); err != nil {
+			client.Close()
+			release()
+			return nil, err
 		}
-		return fmt.Errorf("SSH session ended with unexpected error: %w", err)
 	}
-
-	log.Println("SSH session disconnected gracefully.")
-
-	return nil // Indicate successful connection and session handling
+	// The agent connection and the jump host connection live as long as the SSH connection.
+	go func() {
+		_ = client.Wait()
+		release()
+	}()
+	return client, nil
 }
 
-// TODO (Future): Implement a proper HostKeyCallback using a known_hosts file
-/*
-func getKnownHostsFile() string {
-	// This function would determine the path to the known_hosts file based on OS
-	// and user's home directory.
-	// For example:
-	// usr, err := user.Current()
-	// if err != nil {
-	//     log.Printf("Warning: Could not determine user home directory: %v", err)
-	//     return "" // Indicate no known_hosts file could be found
-	// }
-	// return filepath.Join(usr.HomeDir, ".ssh", "known_hosts")
-	return "" // Placeholder
+// ConnectAndShell establishes an SSH connection using the provided configuration
+// and starts an interactive shell session, connecting local Stdin/Stdout/Stderr.
+// Local terminal resizes are propagated to the remote PTY. To run a shell on other
+// streams, use a Session.
+func ConnectAndShell(cfg SSHConfig) error {
+	return ConnectAndShellContext(context.Background(), cfg)
 }
-*/
 
-// TODO (Future): Implement SCP functionality in this package (e.g., UploadFile, DownloadFile functions)
+// ConnectAndShellContext is ConnectAndShell, aborting the connection attempt or ending the
+// session when ctx is done. Errors are those of Session.Shell.
+func ConnectAndShellContext(ctx context.Context, cfg SSHConfig) error {
+	return TerminalSession().Shell(ctx, cfg)
+}
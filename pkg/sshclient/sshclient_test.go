@@ -12,6 +12,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -351,6 +352,10 @@ func TestConnectAndShell_Integration(t *testing.T) {
 			}
 			defer cleanup()
 
+			// Trust the mock server's (freshly generated) host key on first use.
+			tt.cfg.KnownHostsFiles = []string{filepath.Join(t.TempDir(), "known_hosts")}
+			tt.cfg.HostKeyPolicy = HostKeyAcceptNew
+
 			// 5. Run ConnectAndShell in a goroutine
 			errChan := make(chan error, 1)
 			go func() {
@@ -0,0 +1,480 @@
+// pkg/sshclient/sshconfig.go
+
+package sshclient
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxConfigJumpHosts bounds how many ProxyJump hops are followed for one host.
+const maxConfigJumpHosts = 8
+
+// ConfigFile is a parsed OpenSSH client configuration file (ssh_config(5)). Of its keywords,
+// Host, Match (host, originalhost and all), HostName, Port, User, IdentityFile, ProxyJump
+// and UserKnownHostsFile are used; the others are ignored.
+type ConfigFile struct {
+	blocks []configBlock
+}
+
+// configBlock holds the options of a Host or Match section, or those before the first one.
+type configBlock struct {
+	hosts   []string         // Host patterns; nil for Match sections
+	match   []matchCriterion // Match criteria, all of which must match
+	options []configOption
+}
+
+// matchCriterion is a criterion of a Match line, e.g. "host *.internal,db-?".
+type matchCriterion struct {
+	keyword  string   // "all", "host" or "originalhost"; anything else never matches
+	patterns []string // Comma-separated patterns of host and originalhost
+}
+
+// configOption is a keyword (lower case) with its arguments.
+type configOption struct {
+	keyword string
+	args    []string
+}
+
+// HostConfig is what a ConfigFile says about a host, as raw values: tokens and "~" in
+// paths are not expanded yet. Empty fields were not set.
+type HostConfig struct {
+	Host                string   // The host name or alias looked up
+	HostName            string   // Real host name to connect to
+	Port                string   // Port to connect to
+	User                string   // User to log in as
+	IdentityFiles       []string // Private key files, in order
+	ProxyJump           string   // Comma-separated [user@]host[:port] jump hosts, or "none"
+	UserKnownHostsFiles []string // known_hosts files
+}
+
+// DefaultConfigFile returns the path to the user's OpenSSH configuration (~/.ssh/config),
+// or an empty string if the home directory is unknown.
+func DefaultConfigFile() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		log.Printf("Warning: Could not determine user home directory: %v", err)
+		return ""
+	}
+	return filepath.Join(homeDir, ".ssh", "config")
+}
+
+// LoadConfigFile parses the ssh_config file at path. A missing file is an empty configuration.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &ConfigFile{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SSH config file: %w", err)
+	}
+	defer f.Close()
+	config, err := ParseConfigFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// ParseConfigFile parses an ssh_config file from r.
+func ParseConfigFile(r io.Reader) (*ConfigFile, error) {
+	config := &ConfigFile{}
+	// Options before the first Host or Match line apply to every host
+	block := &configBlock{hosts: []string{"*"}}
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keyword, args, err := splitConfigLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("line %d: %s without a value", lineNo, keyword)
+		}
+
+		switch keyword {
+		case "host":
+			config.blocks = append(config.blocks, *block)
+			block = &configBlock{hosts: args}
+		case "match":
+			criteria, err := parseMatch(args)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			config.blocks = append(config.blocks, *block)
+			block = &configBlock{match: criteria}
+		default:
+			block.options = append(block.options, configOption{keyword: keyword, args: args})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	config.blocks = append(config.blocks, *block)
+	return config, nil
+}
+
+// splitConfigLine splits a line into its keyword (in lower case) and arguments. The
+// keyword may be followed by "=", and arguments containing spaces may be double-quoted.
+func splitConfigLine(line string) (string, []string, error) {
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+
+	var args []string
+	for rest != "" {
+		var arg string
+		if rest[0] == '"' {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing < 0 {
+				return "", nil, fmt.Errorf("unterminated quote in %s", keyword)
+			}
+			arg, rest = rest[1:closing+1], rest[closing+2:]
+		} else if end := strings.IndexAny(rest, " \t"); end >= 0 {
+			arg, rest = rest[:end], rest[end:]
+		} else {
+			arg, rest = rest, ""
+		}
+		if strings.HasPrefix(arg, "#") {
+			break // Trailing comment
+		}
+		args = append(args, arg)
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	return keyword, args, nil
+}
+
+// parseMatch parses the criteria of a Match line. Criteria other than all, host and
+// originalhost (e.g. exec or user) are kept so the section never matches.
+func parseMatch(args []string) ([]matchCriterion, error) {
+	var criteria []matchCriterion
+	for i := 0; i < len(args); i++ {
+		keyword := strings.ToLower(args[i])
+		switch keyword {
+		case "all":
+			criteria = append(criteria, matchCriterion{keyword: keyword})
+		case "host", "originalhost":
+			if i+1 == len(args) {
+				return nil, fmt.Errorf("Match %s without patterns", keyword)
+			}
+			i++
+			criteria = append(criteria, matchCriterion{keyword: keyword, patterns: strings.Split(args[i], ",")})
+		default:
+			log.Printf("Warning: Unsupported Match criterion %q in SSH config, ignoring its section", args[i])
+			return []matchCriterion{{keyword: keyword}}, nil
+		}
+	}
+	return criteria, nil
+}
+
+// matches reports whether the block applies to the host looked up as alias, whose
+// host name (from HostName, or the alias) is hostName so far.
+func (b configBlock) matches(alias, hostName string) bool {
+	if b.hosts != nil {
+		return matchHostPatterns(alias, b.hosts)
+	}
+	for _, c := range b.match {
+		switch c.keyword {
+		case "all":
+		case "host":
+			if !matchHostPatterns(hostName, c.patterns) {
+				return false
+			}
+		case "originalhost":
+			if !matchHostPatterns(alias, c.patterns) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// matchHostPatterns reports whether host matches any of the patterns and none of the
+// negated ("!pattern") ones.
+func matchHostPatterns(host string, patterns []string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			if matchWildcard(strings.ToLower(negated), strings.ToLower(host)) {
+				return false
+			}
+		} else if matchWildcard(strings.ToLower(pattern), strings.ToLower(host)) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchWildcard matches s against pattern, where "*" matches any run of characters and
+// "?" any single character.
+func matchWildcard(pattern, s string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchWildcard(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
+
+// Lookup returns the settings for host, a Host alias or host name. As with OpenSSH, the
+// first value found for an option wins, except IdentityFile whose values add up.
+func (f *ConfigFile) Lookup(host string) HostConfig {
+	hc := HostConfig{Host: host}
+	for _, b := range f.blocks {
+		hostName := hc.HostName
+		if hostName == "" {
+			hostName = host
+		}
+		if !b.matches(host, hostName) {
+			continue
+		}
+		for _, opt := range b.options {
+			switch opt.keyword {
+			case "hostname":
+				setOnce(&hc.HostName, opt.args[0])
+			case "port":
+				setOnce(&hc.Port, opt.args[0])
+			case "user":
+				setOnce(&hc.User, opt.args[0])
+			case "proxyjump":
+				setOnce(&hc.ProxyJump, opt.args[0])
+			case "identityfile":
+				hc.IdentityFiles = append(hc.IdentityFiles, opt.args[0])
+			case "userknownhostsfile":
+				if hc.UserKnownHostsFiles == nil {
+					hc.UserKnownHostsFiles = opt.args
+				}
+			}
+		}
+	}
+	return hc
+}
+
+// setOnce sets *field to value unless it is already set.
+func setOnce(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+// Resolve returns base pointed at target, "[user@]host" where host is a Host alias or a
+// host name, with the settings the file has for it: the address from HostName and Port
+// (default 22), the user (unless given in target), the first IdentityFile that exists
+// (with its "-cert.pub" certificate, if any), UserKnownHostsFile and ProxyJump, whose
+// hosts are resolved the same way. Settings the file does not have keep base's values.
+func (f *ConfigFile) Resolve(target string, base SSHConfig) (SSHConfig, error) {
+	user, host, ok := strings.Cut(target, "@")
+	if !ok {
+		user, host = "", target
+	}
+	if host == "" {
+		return SSHConfig{}, errors.New("no host given")
+	}
+	return f.resolve(user, host, "", base, true, nil)
+}
+
+// resolve implements Resolve for user@host, with port (if set) overriding the file's
+// Port. The host's ProxyJump is only followed if followJump is set; chain holds the
+// hosts being resolved for ProxyJump loop detection.
+func (f *ConfigFile) resolve(user, host, port string, base SSHConfig, followJump bool, chain []string) (SSHConfig, error) {
+	hc := f.Lookup(host)
+	cfg := base
+	if user == "" {
+		user = hc.User
+	}
+	if user != "" {
+		cfg.User = user
+	}
+	if port == "" {
+		port = hc.Port
+	}
+	if port == "" {
+		port = "22"
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return SSHConfig{}, fmt.Errorf("invalid port %q for %s", port, host)
+	}
+
+	hostName := host
+	if hc.HostName != "" {
+		var err error
+		if hostName, err = expandConfigTokens(hc.HostName, host, "", "", "", "HostName"); err != nil {
+			return SSHConfig{}, err
+		}
+	}
+	cfg.Address = net.JoinHostPort(hostName, port)
+	expand := func(path, keyword string) (string, error) {
+		return expandConfigTokens(path, host, hostName, port, cfg.User, keyword)
+	}
+
+	for _, identity := range hc.IdentityFiles {
+		path, err := expand(identity, "IdentityFile")
+		if err != nil {
+			return SSHConfig{}, err
+		}
+		key, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			log.Printf("Warning: Identity file %s not found, skipping", path)
+			continue
+		}
+		if err != nil {
+			return SSHConfig{}, fmt.Errorf("failed to read SSH key file: %w", err)
+		}
+		cfg.Key, cfg.Signer, cfg.Certificate = key, nil, nil
+		if cert, err := os.ReadFile(path + "-cert.pub"); err == nil {
+			cfg.Certificate = cert
+		}
+		break
+	}
+
+	var knownHosts []string
+	for _, file := range hc.UserKnownHostsFiles {
+		if strings.EqualFold(file, "none") {
+			continue
+		}
+		path, err := expand(file, "UserKnownHostsFile")
+		if err != nil {
+			return SSHConfig{}, err
+		}
+		knownHosts = append(knownHosts, path)
+	}
+	if len(knownHosts) > 0 {
+		cfg.KnownHostsFiles = knownHosts
+	}
+
+	if !followJump || hc.ProxyJump == "" {
+		return cfg, nil
+	}
+	if strings.EqualFold(hc.ProxyJump, "none") {
+		cfg.JumpHosts = nil
+		return cfg, nil
+	}
+	return f.withJumpHosts(host, hc.ProxyJump, base, cfg, chain)
+}
+
+// withJumpHosts sets cfg.JumpHosts to the hosts of proxyJump, the ProxyJump of host. The
+// first jump host's own ProxyJump is followed; the later ones are reached through the
+// earlier ones instead, as with OpenSSH.
+func (f *ConfigFile) withJumpHosts(host, proxyJump string, base, cfg SSHConfig, chain []string) (SSHConfig, error) {
+	chain = append(chain, host)
+	if len(chain) > maxConfigJumpHosts {
+		return SSHConfig{}, fmt.Errorf("jump host chain for %s is longer than %d hosts", chain[0], maxConfigJumpHosts)
+	}
+
+	base.JumpHosts = nil
+	var hops []SSHConfig
+	for i, spec := range strings.Split(proxyJump, ",") {
+		user, jumpHost, port, err := parseJumpSpec(spec)
+		if err != nil {
+			return SSHConfig{}, fmt.Errorf("invalid ProxyJump for %s: %w", host, err)
+		}
+		if slices.Contains(chain, jumpHost) {
+			return SSHConfig{}, fmt.Errorf("jump host loop: %s -> %s", strings.Join(chain, " -> "), jumpHost)
+		}
+		hop, err := f.resolve(user, jumpHost, port, base, i == 0, chain)
+		if err != nil {
+			return SSHConfig{}, fmt.Errorf("failed to resolve jump host %s for %s: %w", jumpHost, host, err)
+		}
+		hops = append(hops, hop)
+	}
+	cfg.JumpHosts = hops
+	return cfg, nil
+}
+
+// parseJumpSpec parses a ProxyJump host, "[user@]host[:port]" or "ssh://[user@]host[:port]".
+func parseJumpSpec(spec string) (user, host, port string, err error) {
+	spec = strings.TrimPrefix(strings.TrimSpace(spec), "ssh://")
+	if u, rest, ok := strings.Cut(spec, "@"); ok {
+		user, spec = u, rest
+	}
+	host = spec
+	if strings.HasPrefix(spec, "[") || strings.Count(spec, ":") == 1 {
+		if host, port, err = net.SplitHostPort(spec); err != nil {
+			return "", "", "", err
+		}
+	}
+	if host == "" {
+		return "", "", "", fmt.Errorf("missing host in %q", spec)
+	}
+	return user, host, port, nil
+}
+
+// expandConfigTokens expands a leading "~" and the tokens of ssh_config(5) in value, the
+// argument of keyword: %% (a literal "%"), %d (home directory), %h (host name), %n (host
+// as given), %p (port), %r (remote user) and %u (local user).
+func expandConfigTokens(value, host, hostName, port, remoteUser, keyword string) (string, error) {
+	homeDir, _ := os.UserHomeDir()
+	if value == "~" || strings.HasPrefix(value, "~/") {
+		value = homeDir + value[1:]
+	}
+	if hostName == "" {
+		hostName = host
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+1 == len(value) {
+			return "", fmt.Errorf("%s %q ends with %%", keyword, value)
+		}
+		i++
+		switch value[i] {
+		case '%':
+			b.WriteByte('%')
+		case 'd':
+			b.WriteString(homeDir)
+		case 'h':
+			b.WriteString(hostName)
+		case 'n':
+			b.WriteString(host)
+		case 'p':
+			b.WriteString(port)
+		case 'r':
+			b.WriteString(remoteUser)
+		case 'u':
+			if u, err := user.Current(); err == nil {
+				b.WriteString(u.Username)
+			}
+		default:
+			return "", fmt.Errorf("unknown token %%%c in %s %q", value[i], keyword, value)
+		}
+	}
+	return b.String(), nil
+}
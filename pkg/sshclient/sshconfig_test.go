@@ -0,0 +1,244 @@
+package sshclient
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSSHConfig = `
+# Defaults for the bastion-reachable hosts
+User ops
+
+Host db db-?
+    HostName 10.0.0.5
+    Port 2222
+    IdentityFile ~/.ssh/db_ed25519
+    IdentityFile ~/.ssh/id_rsa
+
+Host web-* !web-legacy
+    User deploy
+    ProxyJump bastion
+
+Host web-legacy
+    HostName="old web.example.com"
+
+Match host 10.0.0.*
+    User dba
+    UserKnownHostsFile ~/.ssh/known_hosts.internal %d/.ssh/known_hosts
+
+Match originalhost db-2
+    Port 2200
+
+Match exec "true"
+    User nobody
+
+Host *
+    Port 22
+    User fallback # Trailing comment
+`
+
+func TestConfigFile_Lookup(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	config, err := ParseConfigFile(strings.NewReader(testSSHConfig))
+	if err != nil {
+		t.Fatalf("ParseConfigFile() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		host       string
+		expectHost HostConfig
+	}{
+		{
+			host: "db",
+			expectHost: HostConfig{Host: "db", HostName: "10.0.0.5", Port: "2222", User: "ops",
+				IdentityFiles:       []string{"~/.ssh/db_ed25519", "~/.ssh/id_rsa"},
+				UserKnownHostsFiles: []string{"~/.ssh/known_hosts.internal", "%d/.ssh/known_hosts"}},
+		},
+		{
+			// The first value found wins, so the earlier Port is kept
+			host: "db-2",
+			expectHost: HostConfig{Host: "db-2", HostName: "10.0.0.5", Port: "2222", User: "ops",
+				IdentityFiles:       []string{"~/.ssh/db_ed25519", "~/.ssh/id_rsa"},
+				UserKnownHostsFiles: []string{"~/.ssh/known_hosts.internal", "%d/.ssh/known_hosts"}},
+		},
+		{host: "web-1", expectHost: HostConfig{Host: "web-1", Port: "22", User: "ops", ProxyJump: "bastion"}},
+		{host: "web-legacy", expectHost: HostConfig{Host: "web-legacy", HostName: "old web.example.com", Port: "22", User: "ops"}},
+		{host: "WEB-legacy", expectHost: HostConfig{Host: "WEB-legacy", HostName: "old web.example.com", Port: "22", User: "ops"}},
+		{host: "10.0.0.9", expectHost: HostConfig{Host: "10.0.0.9", Port: "22", User: "ops",
+			UserKnownHostsFiles: []string{"~/.ssh/known_hosts.internal", "%d/.ssh/known_hosts"}}},
+		{host: "example.com", expectHost: HostConfig{Host: "example.com", Port: "22", User: "ops"}},
+	}
+	for _, tt := range tests {
+		if got := config.Lookup(tt.host); !reflect.DeepEqual(got, tt.expectHost) {
+			t.Errorf("Lookup(%q):\nexpected %+v\ngot      %+v", tt.host, tt.expectHost, got)
+		}
+	}
+
+	// Without global options, the Host * block applies
+	config, err = ParseConfigFile(strings.NewReader("Host a\n  Port 1\nHost *\n  User fallback\n"))
+	if err != nil {
+		t.Fatalf("ParseConfigFile() unexpected error: %v", err)
+	}
+	if got := config.Lookup("b"); got.User != "fallback" || got.Port != "" {
+		t.Errorf("Expected only the Host * options, got %+v", got)
+	}
+}
+
+func TestParseConfigFile_Errors(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        string
+		errorContains string
+	}{
+		{name: "Unterminated Quote", config: "Host a\n  HostName \"a b\n", errorContains: "line 2: unterminated quote"},
+		{name: "Missing Value", config: "Host\n", errorContains: "line 1: host without a value"},
+		{name: "Match Without Patterns", config: "Match host\n", errorContains: "line 1: Match host without patterns"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseConfigFile(strings.NewReader(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+				t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+			}
+		})
+	}
+}
+
+func TestConfigFile_Resolve(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	dir := t.TempDir()
+	writeFile := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	writeFile("id_web-1", "web key")
+	writeFile("id_web-1-cert.pub", "web cert")
+	writeFile("id_bastion", "bastion key")
+	configFile := filepath.Join(dir, "config")
+	writeFile("config", strings.ReplaceAll(`
+Host web-*
+    User deploy
+    IdentityFile DIR/missing
+    IdentityFile DIR/id_%n
+    UserKnownHostsFile DIR/known_hosts_%r
+    ProxyJump admin@bastion:2200,inner
+
+Host bastion
+    HostName bastion.example.com
+    IdentityFile DIR/id_bastion
+    ProxyJump outer
+
+Host inner
+    ProxyJump ignored
+
+Host loop-a
+    ProxyJump loop-b
+Host loop-b
+    ProxyJump loop-a
+
+Host bad-port
+    Port ssh
+
+Host bad-token
+    IdentityFile %x
+`, "DIR", dir))
+	config, err := LoadConfigFile(configFile)
+	if err != nil {
+		t.Fatalf("LoadConfigFile() unexpected error: %v", err)
+	}
+
+	base := SSHConfig{User: "root", Key: []byte("default key"), HostKeyPolicy: HostKeyAsk}
+	tests := []struct {
+		name          string
+		target        string
+		expectConfig  SSHConfig
+		errorContains string
+	}{
+		{
+			name:   "Not Configured",
+			target: "example.com",
+			expectConfig: SSHConfig{Address: "example.com:22", User: "root", Key: []byte("default key"),
+				HostKeyPolicy: HostKeyAsk},
+		},
+		{
+			name:   "User Override",
+			target: "alice@::1",
+			expectConfig: SSHConfig{Address: "[::1]:22", User: "alice", Key: []byte("default key"),
+				HostKeyPolicy: HostKeyAsk},
+		},
+		{
+			name:   "Alias With Jump Hosts",
+			target: "web-1",
+			expectConfig: SSHConfig{
+				Address: "web-1:22", User: "deploy", Key: []byte("web key"), Certificate: []byte("web cert"),
+				KnownHostsFiles: []string{filepath.Join(dir, "known_hosts_deploy")}, HostKeyPolicy: HostKeyAsk,
+				JumpHosts: []SSHConfig{
+					{
+						Address: "bastion.example.com:2200", User: "admin", Key: []byte("bastion key"), HostKeyPolicy: HostKeyAsk,
+						JumpHosts: []SSHConfig{{Address: "outer:22", User: "root", Key: []byte("default key"), HostKeyPolicy: HostKeyAsk}},
+					},
+					{Address: "inner:22", User: "root", Key: []byte("default key"), HostKeyPolicy: HostKeyAsk},
+				},
+			},
+		},
+		{name: "Jump Loop", target: "loop-a", errorContains: "jump host loop: loop-a -> loop-b -> loop-a"},
+		{name: "Invalid Port", target: "bad-port", errorContains: `invalid port "ssh" for bad-port`},
+		{name: "Unknown Token", target: "bad-token", errorContains: "unknown token %x in IdentityFile"},
+		{name: "No Host", target: "root@", errorContains: "no host given"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := config.Resolve(tt.target, base)
+			if tt.errorContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expectConfig) {
+				t.Errorf("Resolve(%q):\nexpected %+v\ngot      %+v", tt.target, tt.expectConfig, got)
+			}
+		})
+	}
+
+	// A missing file is an empty configuration
+	empty, err := LoadConfigFile(filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatalf("LoadConfigFile() unexpected error for a missing file: %v", err)
+	}
+	if got, err := empty.Resolve("web-1", base); err != nil || got.Address != "web-1:22" {
+		t.Errorf("Expected the defaults for a missing file, got %+v (%v)", got, err)
+	}
+}
+
+func TestExpandConfigTokens(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skipf("No home directory: %v", err)
+	}
+	got, err := expandConfigTokens("~/.ssh/%h_%p_%r_%n_100%%", "db", "10.0.0.5", "2222", "dba", "IdentityFile")
+	if err != nil {
+		t.Fatalf("expandConfigTokens() unexpected error: %v", err)
+	}
+	if expected := home + "/.ssh/10.0.0.5_2222_dba_db_100%"; got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	if _, err := expandConfigTokens("key%", "db", "", "22", "dba", "IdentityFile"); err == nil {
+		t.Error("Expected an error for a trailing %")
+	}
+}
@@ -0,0 +1,130 @@
+// pkg/sshclient/terminal.go
+
+package sshclient
+
+import (
+	"log"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Default PTY size used when the terminal size cannot be determined.
+const (
+	defaultTerminalWidth  = 80
+	defaultTerminalHeight = 24
+)
+
+// terminalPromptMu serializes the questions asked on the local terminal (passphrases, host
+// keys, keyboard-interactive), so that concurrent connections such as those of
+// RunOnHosts do not interleave their prompts.
+var terminalPromptMu sync.Mutex
+
+// termType is the terminal type requested for the remote PTY.
+const termType = "xterm-256color"
+
+// TerminalSize is the source of the window size for the remote PTY.
+type TerminalSize interface {
+	// Size returns the current width and height in characters.
+	Size() (width, height int, err error)
+	// Resizes returns a channel that receives a value whenever the size may have
+	// changed, and a function that stops the notifications.
+	Resizes() (<-chan struct{}, func())
+}
+
+// fdTerminalSize reports the size of the terminal behind a file descriptor and
+// signals resizes on SIGWINCH (where the platform has it).
+type fdTerminalSize struct {
+	fd int
+}
+
+func (t fdTerminalSize) Size() (int, int, error) {
+	return term.GetSize(t.fd)
+}
+
+func (t fdTerminalSize) Resizes() (<-chan struct{}, func()) {
+	return notifyResize()
+}
+
+// RawMode switches a local terminal to raw mode, so that keys such as Ctrl+C reach the
+// remote shell instead of being handled locally.
+type RawMode interface {
+	// MakeRaw puts the terminal in raw mode and returns a function restoring its previous state.
+	MakeRaw() (restore func() error, err error)
+}
+
+// fdRawMode puts the terminal behind a file descriptor in raw mode.
+type fdRawMode struct {
+	fd int
+}
+
+func (t fdRawMode) MakeRaw() (func() error, error) {
+	oldState, err := term.MakeRaw(t.fd)
+	if err != nil {
+		return nil, err
+	}
+	return func() error { return term.Restore(t.fd, oldState) }, nil
+}
+
+// terminalSizeOrDefault returns the current size from sizes, or 80x24 if it is unknown.
+func terminalSizeOrDefault(sizes TerminalSize) (int, int) {
+	width, height, err := sizes.Size()
+	if err != nil || width <= 0 || height <= 0 {
+		if err != nil {
+			log.Printf("Warning: Could not get terminal size: %v", err)
+		}
+		return defaultTerminalWidth, defaultTerminalHeight
+	}
+	return width, height
+}
+
+// watchWindowSize forwards size changes reported by sizes to the remote PTY of session,
+// starting from the size the PTY was requested with, and reports them to onResize if it
+// is not nil. The returned function stops watching and waits for the watcher to exit.
+func watchWindowSize(session *ssh.Session, sizes TerminalSize, width, height int, onResize func(width, height int)) func() {
+	resized, stopNotify := sizes.Resizes()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			case _, ok := <-resized:
+				if !ok {
+					return
+				}
+			}
+
+			w, h, err := sizes.Size()
+			if err != nil {
+				log.Printf("Warning: Could not get terminal size: %v", err)
+				continue
+			}
+			if w == width && h == height {
+				continue
+			}
+			if err := session.WindowChange(h, w); err != nil {
+				log.Printf("Warning: Failed to send window change: %v", err)
+				continue
+			}
+			width, height = w, h
+			if onResize != nil {
+				onResize(w, h)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			stopNotify()
+			close(done)
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// fakeTerminalSize is a TerminalSize whose size is changed by the test.
+type fakeTerminalSize struct {
+	mu            sync.Mutex
+	width, height int
+	resized       chan struct{}
+	stopped       chan struct{}
+}
+
+func newFakeTerminalSize(width, height int) *fakeTerminalSize {
+	return &fakeTerminalSize{width: width, height: height, resized: make(chan struct{}), stopped: make(chan struct{})}
+}
+
+func (f *fakeTerminalSize) Size() (int, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.width, f.height, nil
+}
+
+func (f *fakeTerminalSize) Resizes() (<-chan struct{}, func()) {
+	return f.resized, func() { close(f.stopped) }
+}
+
+// resize sets the new size and notifies the watcher, like a SIGWINCH would.
+func (f *fakeTerminalSize) resize(width, height int) {
+	f.mu.Lock()
+	f.width, f.height = width, height
+	f.mu.Unlock()
+	f.resized <- struct{}{}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes and reads.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitForOutput polls buf until it contains s or the timeout expires.
+func waitForOutput(t *testing.T, buf *syncBuffer, s string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(buf.String(), s) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %q, got output %q", s, buf.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSession_WindowResize(t *testing.T) {
+	// The mock shell reports every window size it sees and exits after the third one.
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {
+		_, winCh, isPty := s.Pty()
+		if !isPty {
+			fmt.Fprintln(s, "No PTY requested.")
+			s.Exit(1)
+			return
+		}
+		seen := 0
+		for win := range winCh {
+			fmt.Fprintf(s, "window %dx%d\n", win.Width, win.Height)
+			if seen++; seen == 3 {
+				break
+			}
+		}
+		s.Exit(0)
+	}, ssh.PasswordAuth(func(ctx ssh.Context, pass string) bool { return pass == "pw" }))
+	defer stopServer()
+
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	sizes := newFakeTerminalSize(100, 30)
+	var stdout, stderr syncBuffer
+	cfg := SSHConfig{
+		Address:         addr,
+		User:            "resizeuser",
+		Password:        "pw",
+		KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+		HostKeyPolicy:   HostKeyAcceptNew,
+	}
+
+	session := &Session{Stdin: strings.NewReader(""), Stdout: &stdout, Stderr: &stderr, TerminalSize: sizes}
+
+	errChan := make(chan error, 1)
+	go func() { errChan <- session.Shell(context.Background(), cfg) }()
+
+	waitForOutput(t, &stdout, "window 100x30")
+	sizes.resize(132, 43)
+	waitForOutput(t, &stdout, "window 132x43")
+	sizes.resize(132, 43) // Unchanged size: no window-change request
+	sizes.resize(80, 24)
+
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shell() timed out")
+	}
+
+	// The mock server's PTY emulation turns "\n" into "\r\n".
+	got := strings.ReplaceAll(stdout.String(), "\r\n", "\n")
+	want := "window 100x30\nwindow 132x43\nwindow 80x24\n"
+	if got != want {
+		t.Errorf("Expected output %q, got %q (stderr: %q)", want, got, stderr.String())
+	}
+	select {
+	case <-sizes.stopped:
+	default:
+		t.Error("Expected resize notifications to be stopped after the session ended")
+	}
+}
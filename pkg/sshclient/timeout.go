@@ -0,0 +1,222 @@
+// pkg/sshclient/timeout.go
+
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultDialTimeout limits establishing the TCP connection when SSHConfig.DialTimeout
+// is not set, so an unreachable address fails instead of hanging for minutes.
+const defaultDialTimeout = 30 * time.Second
+
+// Connection errors, matchable with errors.Is. The returned errors wrap them with the
+// details (e.g. the address or the authentication methods that were tried).
+var (
+	ErrDialTimeout       = errors.New("timed out connecting to the SSH server")
+	ErrHandshakeTimeout  = errors.New("timed out during the SSH handshake")
+	ErrAuthFailed        = errors.New("SSH authentication failed")
+	ErrHostKeyMismatch   = errors.New("SSH host key mismatch")
+	ErrIdleTimeout       = errors.New("SSH session timed out due to inactivity")
+	ErrConnectionLost    = errors.New("SSH connection lost")
+	ErrNoCommonAlgorithm = errors.New("no SSH algorithm in common with the server")
+)
+
+// Is makes a *HostKeyMismatchError match ErrHostKeyMismatch.
+func (e *HostKeyMismatchError) Is(target error) bool {
+	return target == ErrHostKeyMismatch
+}
+
+// dialTimeout returns the TCP connect limit for cfg.
+func (cfg SSHConfig) dialTimeout() time.Duration {
+	if cfg.DialTimeout > 0 {
+		return cfg.DialTimeout
+	}
+	return defaultDialTimeout
+}
+
+// dialTCP opens a TCP connection to addr, directly or through the via client, giving up
+// after timeout or when ctx is done.
+func dialTCP(ctx context.Context, via *ssh.Client, addr string, timeout time.Duration) (net.Conn, error) {
+	dialCtx, cancel := context.WithTimeoutCause(ctx, timeout, ErrDialTimeout)
+	defer cancel()
+
+	var conn net.Conn
+	var err error
+	if via != nil {
+		conn, err = via.DialContext(dialCtx, "tcp", addr)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(dialCtx, "tcp", addr)
+	}
+	if err == nil {
+		return conn, nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	var netErr net.Error
+	if errors.Is(context.Cause(dialCtx), ErrDialTimeout) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return nil, fmt.Errorf("%w after %s", ErrDialTimeout, timeout)
+	}
+	return nil, err
+}
+
+// handshake runs the SSH handshake and authentication on conn, giving up after timeout
+// (if positive) or when ctx is done, by closing conn. Authentication failures are
+// reported as ErrAuthFailed, and failures to agree on algorithms as ErrNoCommonAlgorithm.
+func handshake(ctx context.Context, conn net.Conn, addr string, config *ssh.ClientConfig, timeout time.Duration) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, error) {
+	stopCancel := context.AfterFunc(ctx, func() { conn.Close() })
+	var timedOut atomic.Bool
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() {
+			timedOut.Store(true)
+			conn.Close()
+		})
+	}
+	// stop disarms the cancellation and the timer, reporting whether conn is still open.
+	stop := func() bool {
+		open := stopCancel()
+		if timer != nil && !timer.Stop() {
+			open = false
+		}
+		return open
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err == nil && stop() {
+		return c, chans, reqs, nil
+	}
+	stop()
+	if c != nil {
+		c.Close()
+	}
+	switch {
+	case ctx.Err() != nil:
+		err = ctx.Err()
+	case timedOut.Load():
+		err = fmt.Errorf("%w after %s", ErrHandshakeTimeout, timeout)
+	case hasMessagePrefix(err, authFailedPrefix):
+		err = fmt.Errorf("%w: %w", ErrAuthFailed, err)
+	case hasMessagePrefix(err, noCommonAlgorithmPrefix):
+		logNegotiationFailure(addr, err)
+		err = fmt.Errorf("%w: %w", ErrNoCommonAlgorithm, err)
+	}
+	return nil, nil, nil, err
+}
+
+// The x/crypto client reports exhausted authentication methods and failed algorithm
+// negotiation only in the message of an error created with fmt.Errorf: ssh.ServerAuthError
+// is only returned by servers. TestHandshakeErrorPrefixes pins these prefixes against the
+// x/crypto version in go.mod.
+const (
+	authFailedPrefix        = "ssh: unable to authenticate"
+	noCommonAlgorithmPrefix = "ssh: no common algorithm"
+)
+
+// hasMessagePrefix reports whether err or an error it wraps has a message starting with
+// prefix, such as the cause of an "ssh: handshake failed" error.
+func hasMessagePrefix(err error, prefix string) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if strings.HasPrefix(err.Error(), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// idleTimer calls onIdle once no activity has been reported for timeout. Streams wrapped
+// with reader and writer report activity whenever data passes through them.
+type idleTimer struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	last    atomic.Int64 // Time of the last activity, in Unix nanoseconds
+	timer   *time.Timer
+	onIdle  func()
+	fired   atomic.Bool
+}
+
+// newIdleTimer starts an idle timer.
+func newIdleTimer(timeout time.Duration, onIdle func()) *idleTimer {
+	t := &idleTimer{timeout: timeout, onIdle: onIdle}
+	t.touch()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timer = time.AfterFunc(timeout, t.check)
+	return t
+}
+
+// touch records activity.
+func (t *idleTimer) touch() {
+	t.last.Store(time.Now().UnixNano())
+}
+
+// check fires the timer if the session has been idle long enough and otherwise waits
+// for the rest of the timeout.
+func (t *idleTimer) check() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	idle := time.Since(time.Unix(0, t.last.Load()))
+	if idle < t.timeout {
+		t.timer.Reset(t.timeout - idle)
+		return
+	}
+	t.fired.Store(true)
+	t.onIdle()
+}
+
+// Stop stops the timer.
+func (t *idleTimer) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timer.Stop()
+}
+
+// Fired reports whether the timer has called onIdle.
+func (t *idleTimer) Fired() bool {
+	return t.fired.Load()
+}
+
+// reader returns r, reporting activity on every read that returns data.
+func (t *idleTimer) reader(r io.Reader) io.Reader {
+	return idleReader{r: r, t: t}
+}
+
+// writer returns w, reporting activity on every write.
+func (t *idleTimer) writer(w io.Writer) io.Writer {
+	return idleWriter{w: w, t: t}
+}
+
+type idleReader struct {
+	r io.Reader
+	t *idleTimer
+}
+
+func (r idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.t.touch()
+	}
+	return n, err
+}
+
+type idleWriter struct {
+	w io.Writer
+	t *idleTimer
+}
+
+func (w idleWriter) Write(p []byte) (int, error) {
+	w.t.touch()
+	return w.w.Write(p)
+}
@@ -0,0 +1,254 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// startSilentServer returns the address of a server that accepts TCP connections but
+// never speaks SSH.
+func startSilentServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestDial_Errors(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {}, passwordFor("alice", "secret"))
+	defer stopServer()
+	silentAddr := startSilentServer(t)
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+
+	// A known_hosts file revoking the server's key, which is learned by declining it
+	var hostKey gossh.PublicKey
+	_, err := dial(context.Background(), SSHConfig{Address: addr, User: "alice", Password: "secret",
+		KnownHostsFiles: []string{knownHosts}, HostKeyPolicy: HostKeyAsk,
+		HostKeyPrompt: func(hostname string, remote net.Addr, key gossh.PublicKey) (bool, error) {
+			hostKey = key
+			return false, nil
+		}})
+	if hostKey == nil {
+		t.Fatalf("Failed to learn the server's host key: %v", err)
+	}
+	revokedHosts := filepath.Join(t.TempDir(), "known_hosts_revoked")
+	if err := os.WriteFile(revokedHosts, append([]byte("@revoked * "), gossh.MarshalAuthorizedKey(hostKey)...), 0o600); err != nil {
+		t.Fatalf("Failed to write known_hosts: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		cfg           SSHConfig
+		ctxTimeout    time.Duration
+		expectError   error
+		errorContains string
+	}{
+		{
+			name:        "Dial Timeout",
+			cfg:         SSHConfig{Address: addr, User: "alice", Password: "secret", DialTimeout: time.Nanosecond},
+			expectError: ErrDialTimeout,
+		},
+		{
+			name:          "Handshake Timeout",
+			cfg:           SSHConfig{Address: silentAddr, User: "alice", Password: "secret", HandshakeTimeout: 100 * time.Millisecond},
+			expectError:   ErrHandshakeTimeout,
+			errorContains: "after 100ms",
+		},
+		{
+			name:        "Context Deadline",
+			cfg:         SSHConfig{Address: silentAddr, User: "alice", Password: "secret"},
+			ctxTimeout:  100 * time.Millisecond,
+			expectError: context.DeadlineExceeded,
+		},
+		{
+			name:          "Wrong Password",
+			cfg:           SSHConfig{Address: addr, User: "alice", Password: "wrong", KnownHostsFiles: []string{knownHosts}, HostKeyPolicy: HostKeyAcceptNew},
+			expectError:   ErrAuthFailed,
+			errorContains: "unable to authenticate",
+		},
+		{
+			name:          "Host Key Mismatch",
+			cfg:           SSHConfig{Address: addr, User: "alice", Password: "secret", HostKeyFingerprints: []string{"SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}},
+			expectError:   ErrHostKeyMismatch,
+			errorContains: "host key mismatch",
+		},
+		{
+			name:          "Revoked Host Key",
+			cfg:           SSHConfig{Address: addr, User: "alice", Password: "secret", KnownHostsFiles: []string{revokedHosts}, HostKeyPolicy: HostKeyAcceptNew},
+			expectError:   ErrHostKeyMismatch,
+			errorContains: "has been revoked",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.ctxTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.ctxTimeout)
+				defer cancel()
+			}
+			start := time.Now()
+			client, err := dial(ctx, tt.cfg)
+			if client != nil {
+				client.Close()
+			}
+			if !errors.Is(err, tt.expectError) {
+				t.Fatalf("Expected error matching %v, got: %v", tt.expectError, err)
+			}
+			if tt.errorContains != "" && !strings.Contains(err.Error(), tt.errorContains) {
+				t.Errorf("Expected error containing '%s', but got: %v", tt.errorContains, err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("Expected to give up promptly, took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestHandshakeErrorPrefixes(t *testing.T) {
+	// The errors of x/crypto itself, which dial classifies by their messages
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {}, passwordFor("alice", "secret"),
+		serverAlgorithms(gossh.Config{Ciphers: []string{"aes128-ctr"}}))
+	defer stopServer()
+
+	tests := []struct {
+		name   string
+		config gossh.ClientConfig
+		prefix string
+	}{
+		{
+			name:   "Authentication Failure",
+			config: gossh.ClientConfig{User: "alice", Auth: []gossh.AuthMethod{gossh.Password("wrong")}},
+			prefix: authFailedPrefix,
+		},
+		{
+			name: "No Common Algorithm",
+			config: gossh.ClientConfig{User: "alice", Auth: []gossh.AuthMethod{gossh.Password("secret")},
+				Config: gossh.Config{Ciphers: []string{"aes256-gcm@openssh.com"}}},
+			prefix: noCommonAlgorithmPrefix,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.HostKeyCallback = gossh.InsecureIgnoreHostKey()
+			client, err := gossh.Dial("tcp", addr, &tt.config)
+			if client != nil {
+				client.Close()
+			}
+			if !hasMessagePrefix(err, tt.prefix) {
+				t.Errorf("Expected x/crypto to report an error starting with %q, got: %v", tt.prefix, err)
+			}
+		})
+	}
+}
+
+func TestSession_ShellTimeouts(t *testing.T) {
+	originalLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(originalLogOutput)
+
+	addr, stopServer := startMockSSHServer(t, func(s ssh.Session) {
+		io.WriteString(s, "welcome\n")
+		<-s.Context().Done()
+	}, passwordFor("alice", "secret"))
+	defer stopServer()
+
+	tests := []struct {
+		name        string
+		idleTimeout time.Duration
+		ctxTimeout  time.Duration
+		expectError error
+	}{
+		{name: "Idle Timeout", idleTimeout: 200 * time.Millisecond, expectError: ErrIdleTimeout},
+		{name: "Context Canceled", ctxTimeout: 200 * time.Millisecond, expectError: context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.ctxTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.ctxTimeout)
+				defer cancel()
+			}
+			stdinReader, stdinWriter := io.Pipe()
+			defer stdinWriter.Close()
+			var stdout syncBuffer
+			cfg := SSHConfig{
+				Address:         addr,
+				User:            "alice",
+				Password:        "secret",
+				KnownHostsFiles: []string{filepath.Join(t.TempDir(), "known_hosts")},
+				HostKeyPolicy:   HostKeyAcceptNew,
+				IdleTimeout:     tt.idleTimeout,
+			}
+			session := &Session{Stdin: stdinReader, Stdout: &stdout, Stderr: io.Discard}
+
+			start := time.Now()
+			errChan := make(chan error, 1)
+			go func() { errChan <- session.Shell(ctx, cfg) }()
+			select {
+			case err := <-errChan:
+				if !errors.Is(err, tt.expectError) {
+					t.Fatalf("Expected error matching %v, got: %v", tt.expectError, err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Shell() did not end the session")
+			}
+			if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+				t.Errorf("Session ended early, after %s", elapsed)
+			}
+			if !strings.Contains(stdout.String(), "welcome") {
+				t.Errorf("Expected the session output, got %q", stdout.String())
+			}
+		})
+	}
+}
+
+func TestIdleTimer_Activity(t *testing.T) {
+	fired := make(chan struct{})
+	idle := newIdleTimer(150*time.Millisecond, func() { close(fired) })
+	defer idle.Stop()
+
+	w := idle.writer(io.Discard)
+	for range 5 {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("x"))
+	}
+	if idle.Fired() {
+		t.Fatal("Idle timer fired despite activity")
+	}
+	select {
+	case <-fired:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Idle timer did not fire after activity stopped")
+	}
+}
@@ -0,0 +1,237 @@
+// pkg/sshclient/transfer.go
+
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ProgressFunc reports the progress of a file transfer: transferred of total bytes of
+// the file at path (the source path) have been copied so far.
+type ProgressFunc func(path string, transferred, total int64)
+
+// TransferOptions configures Upload and Download.
+type TransferOptions struct {
+	// Optional: copy directories and their contents, like cp -r.
+	Recursive bool
+
+	// Optional: called when each file starts and after every chunk.
+	Progress ProgressFunc
+}
+
+// FileTransfer copies files and directories to and from a remote host. It is implemented
+// by SFTPClient and SCPClient.
+type FileTransfer interface {
+	Upload(ctx context.Context, localPath, remotePath string, opts TransferOptions) error
+	Download(ctx context.Context, remotePath, localPath string, opts TransferOptions) error
+	Close() error
+}
+
+// ConnectFileTransfer establishes an SSH connection using cfg and returns an SFTP client,
+// or an SCP client if the server has no SFTP subsystem. Closing the returned client closes
+// the SSH connection.
+func ConnectFileTransfer(cfg SSHConfig) (FileTransfer, error) {
+	client, release, err := acquire(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newSFTPClient(client)
+	if err == nil {
+		c.release = release
+		return c, nil
+	}
+	if !errors.Is(err, ErrSFTPUnavailable) {
+		release()
+		return nil, err
+	}
+	log.Printf("Warning: %v; falling back to SCP", err)
+	return &SCPClient{client: client, release: release}, nil
+}
+
+// Upload copies the local file or directory localPath to remotePath. If remotePath is an
+// existing directory, localPath is copied into it. File permissions and modification
+// times are preserved. Symbolic links and special files inside directories are skipped.
+func (c *SFTPClient) Upload(ctx context.Context, localPath, remotePath string, opts TransferOptions) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	if remote, err := c.Stat(ctx, remotePath); err == nil && remote.IsDir() {
+		remotePath = path.Join(remotePath, filepath.Base(localPath))
+	}
+	if info.IsDir() {
+		if !opts.Recursive {
+			return fmt.Errorf("%s is a directory (recursive copy not requested)", localPath)
+		}
+		return c.uploadDir(ctx, localPath, remotePath, info, opts.Progress)
+	}
+	return c.uploadFile(ctx, localPath, remotePath, info, opts.Progress)
+}
+
+// Download copies the remote file or directory remotePath to localPath. If localPath is
+// an existing directory, remotePath is copied into it. File permissions and modification
+// times are preserved. Symbolic links and special files inside directories are skipped.
+func (c *SFTPClient) Download(ctx context.Context, remotePath, localPath string, opts TransferOptions) error {
+	info, err := c.Stat(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	if local, err := os.Stat(localPath); err == nil && local.IsDir() {
+		localPath = filepath.Join(localPath, path.Base(remotePath))
+	}
+	if info.IsDir() {
+		if !opts.Recursive {
+			return fmt.Errorf("%s is a directory (recursive copy not requested)", remotePath)
+		}
+		return c.downloadDir(ctx, remotePath, localPath, info, opts.Progress)
+	}
+	return c.downloadFile(ctx, remotePath, localPath, info, opts.Progress)
+}
+
+// uploadFile copies one regular file and then sets its permissions and times.
+func (c *SFTPClient) uploadFile(ctx context.Context, localPath, remotePath string, info fs.FileInfo, progress ProgressFunc) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	report := progressReporter(localPath, info.Size(), progress)
+	if err := c.writeFile(ctx, remotePath, info.Mode(), f, report); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", localPath, err)
+	}
+	if err := c.setstat(ctx, remotePath, info); err != nil {
+		return fmt.Errorf("failed to preserve attributes of %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// uploadDir copies a directory tree. Directory attributes are set after the contents
+// so that writing the contents does not change the modification time.
+func (c *SFTPClient) uploadDir(ctx context.Context, localDir, remoteDir string, info fs.FileInfo, progress ProgressFunc) error {
+	// Owner write access is needed to create the contents; the real mode is set below.
+	if err := c.Mkdir(ctx, remoteDir, info.Mode()|0o700); err != nil {
+		if existing, statErr := c.Stat(ctx, remoteDir); statErr != nil || !existing.IsDir() {
+			return fmt.Errorf("failed to create remote directory %s: %w", remoteDir, err)
+		}
+	}
+
+	entries, err := os.ReadDir(localDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		src := filepath.Join(localDir, entry.Name())
+		dst := path.Join(remoteDir, entry.Name())
+		entryInfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entryInfo.IsDir():
+			err = c.uploadDir(ctx, src, dst, entryInfo, progress)
+		case entryInfo.Mode().IsRegular():
+			err = c.uploadFile(ctx, src, dst, entryInfo, progress)
+		default:
+			log.Printf("Warning: skipping %s: not a regular file or directory", src)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := c.setstat(ctx, remoteDir, info); err != nil {
+		return fmt.Errorf("failed to preserve attributes of %s: %w", remoteDir, err)
+	}
+	return nil
+}
+
+// downloadFile copies one regular file and then sets its permissions and times. A
+// partially written file is removed if the transfer fails.
+func (c *SFTPClient) downloadFile(ctx context.Context, remotePath, localPath string, info fs.FileInfo, progress ProgressFunc) (err error) {
+	f, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm()|0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(localPath)
+		}
+	}()
+
+	report := progressReporter(remotePath, info.Size(), progress)
+	if err := c.readFile(ctx, remotePath, f, report); err != nil {
+		return fmt.Errorf("failed to download %s: %w", remotePath, err)
+	}
+	return preserveLocal(f.Name(), info)
+}
+
+// downloadDir copies a remote directory tree, setting directory attributes after the
+// contents.
+func (c *SFTPClient) downloadDir(ctx context.Context, remoteDir, localDir string, info fs.FileInfo, progress ProgressFunc) error {
+	if err := os.Mkdir(localDir, info.Mode().Perm()|0o700); err != nil && !errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("failed to create directory %s: %w", localDir, err)
+	}
+
+	entries, err := c.ReadDir(ctx, remoteDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		// The names come from the server, which must not be able to write outside localDir
+		if name := entry.Name(); name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+			return fmt.Errorf("remote sftp sent invalid file name %q in %s", name, remoteDir)
+		}
+		src := path.Join(remoteDir, entry.Name())
+		dst := filepath.Join(localDir, entry.Name())
+		switch {
+		case entry.IsDir():
+			err = c.downloadDir(ctx, src, dst, entry, progress)
+		case entry.Mode().IsRegular():
+			err = c.downloadFile(ctx, src, dst, entry, progress)
+		default:
+			log.Printf("Warning: skipping %s: not a regular file or directory", src)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return preserveLocal(localDir, info)
+}
+
+// preserveLocal applies the permissions and modification time of info to a local path.
+func preserveLocal(localPath string, info fs.FileInfo) error {
+	if err := os.Chmod(localPath, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to preserve permissions of %s: %w", localPath, err)
+	}
+	if err := os.Chtimes(localPath, time.Time{}, info.ModTime()); err != nil {
+		return fmt.Errorf("failed to preserve modification time of %s: %w", localPath, err)
+	}
+	return nil
+}
+
+// progressReporter adapts progress to the per-chunk callback of readFile and writeFile.
+// It reports the start of the file immediately.
+func progressReporter(name string, total int64, progress ProgressFunc) func(n int64) {
+	if progress == nil {
+		return func(int64) {}
+	}
+	progress(name, 0, total)
+	var transferred int64
+	return func(n int64) {
+		transferred += n
+		progress(name, transferred, total)
+	}
+}